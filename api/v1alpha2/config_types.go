@@ -19,16 +19,161 @@ package v1alpha2
 import (
 	"net"
 	"regexp"
+	"time"
 )
 
 type DoorkeeperConfigT struct {
 	LogLevel       string                 `yaml:"logLevel"`
 	Address        string                 `yaml:"address"`
 	Port           string                 `yaml:"port"`
+	ReloadInterval time.Duration          `yaml:"reloadInterval,omitempty"`
 	Modifiers      []ModifierConfigT      `yaml:"modifiers"`
 	Auths          []AuthorizationConfigT `yaml:"authorizations"`
 	RequestAuthReq []RequestAuthReqT      `yaml:"requestAuthRequirements"`
 	Response       ResponseConfigT        `yaml:"response"`
+	Kubernetes     KubernetesConfigT      `yaml:"kubernetes,omitempty"`
+	ClientIp       ClientIpConfigT        `yaml:"clientIp,omitempty"`
+	Security       SecurityConfigT        `yaml:"security,omitempty"`
+	Logs           LogsConfigT            `yaml:"logs,omitempty"`
+	RequestId      RequestIdConfigT       `yaml:"requestId,omitempty"`
+	Audit          AuditConfigT           `yaml:"audit,omitempty"`
+	Tls            TlsConfigT             `yaml:"tls,omitempty"`
+	Server         ServerConfigT          `yaml:"server,omitempty"`
+}
+
+//--------------------------------
+// Server
+//--------------------------------
+
+// ServerConfigT sets the limits of the listener. Changes in it need a restart
+type ServerConfigT struct {
+	ReadTimeout           time.Duration         `yaml:"readTimeout,omitempty"`           // defaults to 10s
+	ReadHeaderTimeout     time.Duration         `yaml:"readHeaderTimeout,omitempty"`     // defaults to readTimeout
+	WriteTimeout          time.Duration         `yaml:"writeTimeout,omitempty"`          // defaults to 10s
+	IdleTimeout           time.Duration         `yaml:"idleTimeout,omitempty"`           // defaults to 30s
+	MaxHeaderBytes        int                   `yaml:"maxHeaderBytes,omitempty"`        // defaults to 1MB
+	MaxConcurrentRequests int                   `yaml:"maxConcurrentRequests,omitempty"` // unlimited when not set
+	Shutdown              ServerShutdownConfigT `yaml:"shutdown,omitempty"`
+}
+
+// ServerShutdownConfigT sets how the server stops: readiness fails during the delay,
+// so the proxies stop sending requests, and then in-flight requests are drained
+type ServerShutdownConfigT struct {
+	Delay        time.Duration `yaml:"delay,omitempty"`
+	DrainTimeout time.Duration `yaml:"drainTimeout,omitempty"` // defaults to 20s
+}
+
+//--------------------------------
+// TLS
+//--------------------------------
+
+// TlsConfigT enables TLS on the listener. Certificates are reloaded when their files change
+type TlsConfigT struct {
+	CertFile     string `yaml:"certFile"`
+	KeyFile      string `yaml:"keyFile"`
+	ClientCaFile string `yaml:"clientCaFile,omitempty"` // requires client certificates signed by these CAs
+	MinVersion   string `yaml:"minVersion,omitempty"`   // values: 1.2|1.3. Defaults to 1.2
+
+	// the metrics endpoint is open to any client unless set, as the health checks
+	MetricsClientCert bool `yaml:"metricsClientCert,omitempty"`
+}
+
+//--------------------------------
+// Audit
+//--------------------------------
+
+// AuditConfigT sets where the trail of the decisions is written, apart from the logs
+type AuditConfigT struct {
+	Sink   string             `yaml:"sink,omitempty"` // values: FILE|SYSLOG|UDP|HTTP. Disabled when not set
+	File   AuditFileConfigT   `yaml:"file,omitempty"`
+	Syslog AuditSyslogConfigT `yaml:"syslog,omitempty"`
+	Udp    AuditUdpConfigT    `yaml:"udp,omitempty"`
+	Http   AuditHttpConfigT   `yaml:"http,omitempty"`
+}
+
+type AuditFileConfigT struct {
+	Path       string `yaml:"path"`
+	MaxSizeMb  int    `yaml:"maxSizeMb,omitempty"`  // defaults to 100
+	MaxBackups int    `yaml:"maxBackups,omitempty"` // defaults to 10
+}
+
+type AuditSyslogConfigT struct {
+	Network string `yaml:"network,omitempty"` // values: udp|tcp|unix. Local syslog when not set
+	Address string `yaml:"address,omitempty"`
+	Tag     string `yaml:"tag,omitempty"` // defaults to doorkeeper
+}
+
+type AuditUdpConfigT struct {
+	Address string `yaml:"address"`
+}
+
+type AuditHttpConfigT struct {
+	Url           string            `yaml:"url"`
+	Headers       map[string]string `yaml:"headers,omitempty"`
+	Timeout       time.Duration     `yaml:"timeout,omitempty"`       // defaults to 5s
+	BatchSize     int               `yaml:"batchSize,omitempty"`     // defaults to 100
+	FlushInterval time.Duration     `yaml:"flushInterval,omitempty"` // defaults to 1s
+}
+
+//--------------------------------
+// Request ID
+//--------------------------------
+
+type RequestIdConfigT struct {
+	Headers        []string `yaml:"headers,omitempty"`        // defaults to x-request-id, traceparent
+	ResponseHeader string   `yaml:"responseHeader,omitempty"` // defaults to x-request-id
+	Generator      string   `yaml:"generator,omitempty"`      // values: UUIDV7|ULID. Defaults to UUIDV7
+}
+
+//--------------------------------
+// Logs
+//--------------------------------
+
+type LogsConfigT struct {
+	Format    string             `yaml:"format,omitempty"` // values: JSON|LOGFMT|TEXT. Defaults to JSON
+	Decision  DecisionLogConfigT `yaml:"decision,omitempty"`
+	Redaction RedactionConfigT   `yaml:"redaction,omitempty"`
+}
+
+// DecisionLogConfigT sets the line logged once per request with its decision
+type DecisionLogConfigT struct {
+	Fields   []string                   `yaml:"fields,omitempty"` // defaults to requestID, decision, reason, statusCode, method, host, path, clientIp, durationMs
+	Sampling DecisionLogSamplingConfigT `yaml:"sampling,omitempty"`
+}
+
+// DecisionLogSamplingConfigT sets the ratio of the decisions logged, from 0 to 1. Defaults to 1
+type DecisionLogSamplingConfigT struct {
+	Allowed *float64 `yaml:"allowed,omitempty"`
+	Denied  *float64 `yaml:"denied,omitempty"`
+}
+
+// RedactionConfigT sets the values removed from the requests in logs.
+// Names are added to the default ones, which are always redacted
+type RedactionConfigT struct {
+	Mode               string   `yaml:"mode,omitempty"` // values: MASK|HASH. Defaults to MASK
+	Headers            []string `yaml:"headers,omitempty"`
+	QueryParams        []string `yaml:"queryParams,omitempty"`
+	HeaderPatterns     []string `yaml:"headerPatterns,omitempty"`     // matched against lowercase names
+	QueryParamPatterns []string `yaml:"queryParamPatterns,omitempty"` // matched against lowercase names
+}
+
+//--------------------------------
+// Security
+//--------------------------------
+
+type SecurityConfigT struct {
+	// Rejects md5 and sha1 in HMAC authorizations, and HMAC_SHA1 in signed cookie ones
+	DisallowWeakHmacAlgorithms bool `yaml:"disallowWeakHmacAlgorithms,omitempty"`
+}
+
+//--------------------------------
+// Client IP
+//--------------------------------
+
+type ClientIpConfigT struct {
+	Sources         []string `yaml:"sources,omitempty"` // values: XFF|FORWARDED|X_REAL_IP|X_ENVOY_EXTERNAL_ADDRESS|REMOTE_ADDR
+	TrustedNetworks []string `yaml:"trustedNetworks,omitempty"`
+	TrustedHops     int      `yaml:"trustedHops,omitempty"`
 }
 
 //--------------------------------
@@ -46,7 +191,7 @@ type ModifierPathConfigT struct {
 	Replace string `yaml:"replace"`
 
 	// Carry stuff
-	CompiledRegex *regexp.Regexp
+	CompiledRegex *regexp.Regexp `yaml:"-"`
 }
 
 type ModifierHeaderConfigT struct {
@@ -55,7 +200,7 @@ type ModifierHeaderConfigT struct {
 	Replace string `yaml:"replace"`
 
 	// Carry stuff
-	CompiledRegex *regexp.Regexp
+	CompiledRegex *regexp.Regexp `yaml:"-"`
 }
 
 //--------------------------------
@@ -64,17 +209,22 @@ type ModifierHeaderConfigT struct {
 
 type AuthorizationConfigT struct {
 	Name  string           `yaml:"name"`
-	Type  string           `yaml:"type"` // values: HMAC|CIDR
+	Type  string           `yaml:"type"` // values: HMAC|IPLIST|MATCH|GEOIP|SCHEDULE|SIGNED_COOKIE
 	Param AuthParamConfigT `yaml:"param"`
 
-	Hmac   HmacConfigT   `yaml:"hmac"`
-	IpList IpListConfigT `yaml:"ipList"`
-	Match  MatchConfigT  `yaml:"match"`
+	Hmac         HmacConfigT         `yaml:"hmac"`
+	IpList       IpListConfigT       `yaml:"ipList"`
+	Match        MatchConfigT        `yaml:"match"`
+	GeoIp        GeoIpConfigT        `yaml:"geoIp,omitempty"`
+	Schedule     ScheduleConfigT     `yaml:"schedule,omitempty"`
+	SignedCookie SignedCookieConfigT `yaml:"signedCookie,omitempty"`
 }
 
 type AuthParamConfigT struct {
-	Type string `yaml:"type"` // values: Header|Query
-	Name string `yaml:"name"` // values: :host|:authority|<header-name>
+	Type    string `yaml:"type"`              // values: HEADER|QUERY|CLIENT_IP|COOKIE|PATH_SEGMENT|PATH_REGEX|QUERY_STRING
+	Name    string `yaml:"name"`              // values: :host|:authority|<header-name>|<query-name>|<cookie-name>
+	Index   int    `yaml:"index,omitempty"`   // for PATH_SEGMENT, starting at 0. Negative values count from the end
+	Pattern string `yaml:"pattern,omitempty"` // for PATH_REGEX, the value is the first capture group
 }
 
 // HMAC
@@ -83,10 +233,35 @@ type HmacConfigT struct {
 	Type                string `yaml:"type"` // values: URL
 	EncryptionKey       string `yaml:"encryptionKey"`
 	EncryptionAlgorithm string `yaml:"encryptionAlgorithm"`
+	KeyEncoding         string `yaml:"keyEncoding,omitempty"` // values: hex|base64|base64url|raw. Defaults to hex
 
 	//
 	MandatoryFields []string       `yaml:"mandatoryFields,omitempty"`
 	Url             HmacUrlConfigT `yaml:"url,omitempty"`
+
+	// Compatibility with tokens generated by other tools
+	Compatibility string `yaml:"compatibility,omitempty"` // values: AKAMAI
+	Salt          string `yaml:"salt,omitempty"`          // for AKAMAI compatibility
+
+	// Grammar of the tokens, for tokens generated by third-party systems
+	Token HmacTokenConfigT `yaml:"token,omitempty"`
+}
+
+type HmacTokenConfigT struct {
+	FieldSeparator string `yaml:"fieldSeparator,omitempty"` // defaults to ~
+	ValueSeparator string `yaml:"valueSeparator,omitempty"` // defaults to =
+	AclSeparator   string `yaml:"aclSeparator,omitempty"`   // defaults to !
+
+	HmacField string `yaml:"hmacField,omitempty"` // defaults to hmac
+	ExpField  string `yaml:"expField,omitempty"`  // defaults to exp
+	StField   string `yaml:"stField,omitempty"`   // defaults to st
+	IpField   string `yaml:"ipField,omitempty"`   // defaults to ip
+	AclField  string `yaml:"aclField,omitempty"`  // defaults to acl
+	UrlField  string `yaml:"urlField,omitempty"`  // defaults to url
+	IdField   string `yaml:"idField,omitempty"`   // defaults to id, logged in audit logs
+
+	DigestEncoding string `yaml:"digestEncoding,omitempty"` // values: hex|base64|base64url. Defaults to hex
+	DigestLength   int    `yaml:"digestLength,omitempty"`   // bytes the digest is truncated to, at least 10
 }
 
 type HmacUrlConfigT struct {
@@ -99,14 +274,76 @@ type HmacUrlConfigT struct {
 // IPLIST
 
 type IpListConfigT struct {
-	Separator       string   `yaml:"separator"`
-	Reverse         bool     `yaml:"reverse"`
-	Cidr            string   `yaml:"cidr"`
-	TrustedNetworks []string `yaml:"trustedNetworks"`
+	Separator       string             `yaml:"separator"`
+	Reverse         bool               `yaml:"reverse"`
+	Cidr            string             `yaml:"cidr"`
+	Cidrs           []string           `yaml:"cidrs,omitempty"`
+	CidrFiles       []string           `yaml:"cidrFiles,omitempty"`
+	CidrUrls        []IpListUrlConfigT `yaml:"cidrUrls,omitempty"`
+	TrustedNetworks []string           `yaml:"trustedNetworks"`
 
 	// Carry stuff
-	CidrCompiled            *net.IPNet
-	TrustedNetworksCompiled []*net.IPNet
+	CidrCompiled            *net.IPNet   `yaml:"-"`
+	TrustedNetworksCompiled []*net.IPNet `yaml:"-"`
+}
+
+type IpListUrlConfigT struct {
+	Url             string        `yaml:"url"`
+	Format          string        `yaml:"format,omitempty"`    // values: TEXT|JSON
+	Selectors       []string      `yaml:"selectors,omitempty"` // JSONPath-like expressions, for JSON format
+	RefreshInterval time.Duration `yaml:"refreshInterval,omitempty"`
+	Timeout         time.Duration `yaml:"timeout,omitempty"`
+	MaxSize         int64         `yaml:"maxSize,omitempty"` // in bytes
+	CacheFile       string        `yaml:"cacheFile,omitempty"`
+}
+
+// GEOIP
+
+type GeoIpConfigT struct {
+	Reverse    bool     `yaml:"reverse,omitempty"`
+	Databases  []string `yaml:"databases"`            // paths to MaxMind .mmdb files, e.g. GeoLite2-Country and GeoLite2-ASN
+	Countries  []string `yaml:"countries,omitempty"`  // ISO 3166-1 alpha-2 codes, e.g. ES
+	Continents []string `yaml:"continents,omitempty"` // values: AF|AN|AS|EU|NA|OC|SA
+	Asns       []uint   `yaml:"asns,omitempty"`
+}
+
+// SIGNED_COOKIE
+
+type SignedCookieConfigT struct {
+	Algorithm   string                   `yaml:"algorithm"`             // values: RSA_SHA1|RSA_SHA256|HMAC_SHA1|HMAC_SHA256|HMAC_SHA512
+	KeyEncoding string                   `yaml:"keyEncoding,omitempty"` // for HMAC algorithms. values: hex|base64|base64url|raw. Defaults to hex
+	Keys        []SignedCookieKeyConfigT `yaml:"keys"`
+
+	// Names of the cookies, defaulting to the ones of CloudFront
+	Cookies SignedCookieNamesConfigT `yaml:"cookies,omitempty"`
+}
+
+type SignedCookieKeyConfigT struct {
+	Id  string `yaml:"id,omitempty"` // sent in the key pair id cookie. Optional with only one key
+	Key string `yaml:"key"`          // PEM public key for RSA algorithms, encoded secret for HMAC ones
+}
+
+type SignedCookieNamesConfigT struct {
+	Policy    string `yaml:"policy,omitempty"`
+	Signature string `yaml:"signature,omitempty"`
+	KeyPairId string `yaml:"keyPairId,omitempty"`
+	Expires   string `yaml:"expires,omitempty"`
+}
+
+// SCHEDULE
+
+type ScheduleConfigT struct {
+	Reverse  bool                    `yaml:"reverse,omitempty"`
+	TimeZone string                  `yaml:"timeZone,omitempty"` // IANA name, e.g. Europe/Madrid. Defaults to UTC
+	Windows  []ScheduleWindowConfigT `yaml:"windows"`
+}
+
+type ScheduleWindowConfigT struct {
+	Days  []string `yaml:"days,omitempty"`  // values: MON|TUE|WED|THU|FRI|SAT|SUN
+	From  string   `yaml:"from,omitempty"`  // HH:MM
+	To    string   `yaml:"to,omitempty"`    // HH:MM, exclusive
+	Start string   `yaml:"start,omitempty"` // RFC3339 or YYYY-MM-DD[THH:MM[:SS]] in the time zone
+	End   string   `yaml:"end,omitempty"`   // exclusive, same formats as start
 }
 
 // MATCH
@@ -115,8 +352,22 @@ type MatchConfigT struct {
 	Reverse bool   `yaml:"reverse"`
 	Pattern string `yaml:"pattern"`
 
+	// All the conditions must be satisfied
+	Conditions []MatchConditionConfigT `yaml:"conditions,omitempty"`
+
 	// Carry stuff
-	CompiledRegex *regexp.Regexp
+	CompiledRegex *regexp.Regexp `yaml:"-"`
+}
+
+type MatchConditionConfigT struct {
+	Source   string   `yaml:"source"`           // values: METHOD|HOST|PATH|QUERY_STRING|HEADER|QUERY|COOKIE|CLIENT_IP
+	Name     string   `yaml:"name,omitempty"`   // for HEADER, QUERY and COOKIE sources
+	Operator string   `yaml:"operator"`         // values: REGEX|EXACT|PREFIX|SUFFIX|ONE_OF|RANGE|PRESENT|ABSENT
+	Value    string   `yaml:"value,omitempty"`  // for REGEX, EXACT, PREFIX and SUFFIX operators
+	Values   []string `yaml:"values,omitempty"` // for ONE_OF operator
+	Min      *float64 `yaml:"min,omitempty"`    // for RANGE operator, inclusive
+	Max      *float64 `yaml:"max,omitempty"`    // for RANGE operator, inclusive
+	Reverse  bool     `yaml:"reverse,omitempty"`
 }
 
 //--------------------------------
@@ -143,3 +394,16 @@ type ResponseT struct {
 	Headers    map[string]string `yaml:"headers"`
 	Body       string            `yaml:"body"`
 }
+
+// --------------------------------
+// Kubernetes
+// --------------------------------
+
+type KubernetesConfigT struct {
+	Enabled    bool   `yaml:"enabled"`
+	Namespace  string `yaml:"namespace,omitempty"`  // defaults to the namespace of the pod
+	Kubeconfig string `yaml:"kubeconfig,omitempty"` // defaults to the in-cluster config
+
+	// modifiers apply to every request, so policies can only define them when allowed
+	AllowModifiers bool `yaml:"allowModifiers,omitempty"`
+}
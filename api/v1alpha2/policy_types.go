@@ -0,0 +1,32 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+const (
+	PolicyGroup    = "doorkeeper.freepik.com"
+	PolicyVersion  = "v1alpha2"
+	PolicyKind     = "DoorkeeperPolicy"
+	PolicyResource = "doorkeeperpolicies"
+)
+
+// DoorkeeperPolicySpecT is the spec of DoorkeeperPolicy resources. Their content
+// is merged into the config, as if it was one more config file
+type DoorkeeperPolicySpecT struct {
+	Modifiers      []ModifierConfigT      `yaml:"modifiers,omitempty"`
+	Auths          []AuthorizationConfigT `yaml:"authorizations,omitempty"`
+	RequestAuthReq []RequestAuthReqT      `yaml:"requestAuthRequirements,omitempty"`
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+type TestSuiteT struct {
+	Now   string      `yaml:"now"` // values: RFC3339 date|unix timestamp
+	Tests []TestCaseT `yaml:"tests"`
+}
+
+type TestCaseT struct {
+	Name    string       `yaml:"name"`
+	Request TestRequestT `yaml:"request"`
+	Expect  TestExpectT  `yaml:"expect"`
+}
+
+type TestRequestT struct {
+	Method     string            `yaml:"method"`
+	Host       string            `yaml:"host"`
+	Path       string            `yaml:"path"` // path including the raw query (e.g. /video.mp4?token=...)
+	Headers    map[string]string `yaml:"headers"`
+	RemoteAddr string            `yaml:"remoteAddr"`
+
+	// ClientCertificate sends the request as if it carried a verified client certificate, for mTLS listeners
+	ClientCertificate bool `yaml:"clientCertificate,omitempty"`
+}
+
+type TestExpectT struct {
+	Decision   string            `yaml:"decision"`         // values: allow|deny
+	Reason     string            `yaml:"reason,omitempty"` // reason of the denial, e.g. expired
+	StatusCode int               `yaml:"statusCode,omitempty"`
+	Headers    map[string]string `yaml:"headers,omitempty"`
+}
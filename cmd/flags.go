@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+)
+
+// pathsFlag is a flag accepting several paths, either repeating
+// the flag or separating them with commas
+type pathsFlag struct {
+	paths []string
+	isSet bool
+}
+
+func newPathsFlag(defaults ...string) *pathsFlag {
+	return &pathsFlag{paths: defaults}
+}
+
+func (f *pathsFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(f.paths, ",")
+}
+
+func (f *pathsFlag) Set(value string) error {
+	// the first time it is set, defaults are discarded
+	if !f.isSet {
+		f.paths = nil
+		f.isSet = true
+	}
+
+	for _, pathv := range strings.Split(value, ",") {
+		if pathv = strings.TrimSpace(pathv); pathv != "" {
+			f.paths = append(f.paths, pathv)
+		}
+	}
+
+	return nil
+}
@@ -27,20 +27,37 @@ import (
 
 var (
 	logLevelFlag = flag.String("log-level", "info", "Verbosity level for logs")
-	configFlag   = flag.String("config", "doorkeeper.yaml", "Path to the config file")
+	configFlag   = newPathsFlag("doorkeeper.yaml")
+)
+
+func init() {
+	flag.Var(configFlag, "config", "Paths to the config files or directories, repeated or comma-separated")
+}
+
+const (
+	testCommand   = "test"
+	schemaCommand = "schema"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == testCommand {
+		os.Exit(runTest(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == schemaCommand {
+		os.Exit(runSchema())
+	}
+
 	flag.Parse()
 
-	extLogger := logger.NewLogger(logger.GetLevel(*logLevelFlag))
+	extLogger := logger.NewLogger(logger.GetLevel(*logLevelFlag), logger.FormatJSON)
 	logFields := utils.GetDefaultLogFields()
 
 	/////////////////////////////
 	// EXECUTION FLOW RELATED
 	/////////////////////////////
 
-	s, err := doorkeeper.NewDoorkeeper(*configFlag)
+	s, err := doorkeeper.NewDoorkeeper(configFlag.paths)
 	if err != nil {
 		logFields.Set(utils.LogFieldKeyError, err.Error())
 		extLogger.Fatal("fail in http server creation", logFields)
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"doorkeeper/internal/config"
+)
+
+// runSchema prints the JSON Schema of the config file
+// and returns the exit code for the process
+func runSchema() int {
+	schema, err := config.GenerateJSONSchema()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to generate config schema: %s\n", err.Error())
+		return 1
+	}
+
+	fmt.Println(string(schema))
+	return 0
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"doorkeeper/internal/clock"
+	"doorkeeper/internal/config"
+	"doorkeeper/internal/doorkeeper"
+	"doorkeeper/internal/logger"
+)
+
+// runTest executes the test cases of a suite file against a config file
+// and returns the exit code for the process: 0 when every test passes
+func runTest(args []string) int {
+	testFlags := flag.NewFlagSet(testCommand, flag.ExitOnError)
+	logLevelFlag := testFlags.String("log-level", "error", "Verbosity level for logs")
+	configFlag := newPathsFlag("doorkeeper.yaml")
+	testFlags.Var(configFlag, "config", "Paths to the config files or directories, repeated or comma-separated")
+	testsFlag := testFlags.String("tests", "doorkeeper.tests.yaml", "Path to the test suite file")
+	nowFlag := testFlags.String("now", "", "Fixed time for the clock (RFC3339 or unix timestamp), overrides the suite one")
+	testFlags.Parse(args)
+
+	suite, err := config.ParseTestSuiteFile(*testsFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to parse test suite file: %s\n", err.Error())
+		return 2
+	}
+
+	now := suite.Now
+	if *nowFlag != "" {
+		now = *nowFlag
+	}
+
+	if now != "" {
+		fixedTime, err := config.ParseTestSuiteTime(now)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to parse clock time: %s\n", err.Error())
+			return 2
+		}
+		clock.SetFixed(fixedTime)
+	}
+
+	d, err := doorkeeper.NewTestDoorkeeper(configFlag.paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to load config: %s\n", err.Error())
+		return 2
+	}
+	d.SetLogLevel(logger.GetLevel(*logLevelFlag))
+
+	failed := 0
+	results := d.RunTestSuite(suite)
+	for _, resv := range results {
+		if resv.Passed {
+			fmt.Printf("PASS %s\n", resv.Name)
+			continue
+		}
+
+		failed++
+		fmt.Printf("FAIL %s\n", resv.Name)
+		for _, fv := range resv.Failures {
+			fmt.Printf("     %s\n", fv)
+		}
+	}
+
+	fmt.Printf("\n%d passed, %d failed\n", len(results)-failed, failed)
+	if failed > 0 {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,144 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"doorkeeper/api/v1alpha2"
+	"doorkeeper/internal/metrics"
+)
+
+const (
+	SinkFILE   = "FILE"
+	SinkSYSLOG = "SYSLOG"
+	SinkUDP    = "UDP"
+	SinkHTTP   = "HTTP"
+
+	resultWritten = "written"
+	resultDropped = "dropped"
+	resultFailed  = "failed"
+
+	// queueSize is the number of records waiting to be sent by the network sinks.
+	// Further records are dropped, so a slow destination does not delay the decisions
+	queueSize = 10000
+)
+
+var (
+	Sinks = []string{SinkFILE, SinkSYSLOG, SinkUDP, SinkHTTP}
+
+	recordsTotal = metrics.NewCounterVec("doorkeeper_audit_records_total",
+		"Audit records sent to the sink, by result: written, dropped or failed", "sink", "result")
+)
+
+// RecordT is the trail of a decision over a request
+type RecordT struct {
+	Time         time.Time `json:"time"`
+	RequestID    string    `json:"requestID"`
+	ClientIp     string    `json:"clientIp,omitempty"`
+	Method       string    `json:"method"`
+	Host         string    `json:"host"`
+	Path         string    `json:"path"`
+	Requirements []string  `json:"requirements,omitempty"`
+	Decision     string    `json:"decision"`
+	Reason       string    `json:"reason,omitempty"`
+	Identities   []string  `json:"identities,omitempty"`
+}
+
+// SinkI is a destination of the audit records.
+// Closing it writes the pending records until the context is done
+type SinkI interface {
+	Write(record RecordT) error
+	Close(ctx context.Context) error
+}
+
+// NewSink returns the sink set in the config, or nil when audit is disabled
+func NewSink(cfg v1alpha2.AuditConfigT) (sink SinkI, err error) {
+	switch cfg.Sink {
+	case "":
+		{
+			return nil, nil
+		}
+	case SinkFILE:
+		{
+			return newFileSink(cfg.File)
+		}
+	case SinkSYSLOG:
+		{
+			s, err := newSyslogSink(cfg.Syslog)
+			if err != nil {
+				return nil, err
+			}
+			return newQueuedSink(SinkSYSLOG, s), nil
+		}
+	case SinkUDP:
+		{
+			s, err := newUdpSink(cfg.Udp)
+			if err != nil {
+				return nil, err
+			}
+			return newQueuedSink(SinkUDP, s), nil
+		}
+	case SinkHTTP:
+		{
+			return newHttpSink(cfg.Http)
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported audit sink '%s'", cfg.Sink)
+}
+
+// Check validates the config of the sink without opening it
+func Check(cfg v1alpha2.AuditConfigT) error {
+	switch cfg.Sink {
+	case "":
+		return nil
+	case SinkFILE:
+		{
+			if cfg.File.Path == "" {
+				return fmt.Errorf("file path must be set for %s sink", cfg.Sink)
+			}
+
+			if cfg.File.MaxSizeMb < 0 || cfg.File.MaxBackups < 0 {
+				return fmt.Errorf("file max size and backups must be positive numbers")
+			}
+		}
+	case SinkSYSLOG:
+		{
+			if (cfg.Syslog.Network == "") != (cfg.Syslog.Address == "") {
+				return fmt.Errorf("syslog network and address must be set together, or none of them for the local syslog")
+			}
+		}
+	case SinkUDP:
+		{
+			if cfg.Udp.Address == "" {
+				return fmt.Errorf("udp address must be set for %s sink", cfg.Sink)
+			}
+		}
+	case SinkHTTP:
+		{
+			if cfg.Http.Url == "" {
+				return fmt.Errorf("http url must be set for %s sink", cfg.Sink)
+			}
+
+			if cfg.Http.Timeout < 0 || cfg.Http.FlushInterval < 0 || cfg.Http.BatchSize < 0 {
+				return fmt.Errorf("http timeout, flush interval and batch size must be positive")
+			}
+		}
+	default:
+		return fmt.Errorf("audit sink must be one of %v", Sinks)
+	}
+
+	return nil
+}
+
+// encode returns the record as a JSON line
+func encode(record RecordT) ([]byte, error) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(line, '\n'), nil
+}
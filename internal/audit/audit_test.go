@@ -0,0 +1,323 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"doorkeeper/api/v1alpha2"
+)
+
+func TestFileSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	sink, err := NewSink(v1alpha2.AuditConfigT{Sink: SinkFILE, File: v1alpha2.AuditFileConfigT{Path: path, MaxBackups: 2}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer sink.Close(context.Background())
+
+	// records of ~1/3 of the size, so every 3 records the file is rotated
+	s := sink.(*fileSinkT)
+	line, _ := encode(RecordT{RequestID: "1"})
+	s.maxSize = int64(3 * len(line))
+
+	for i := 0; i < 10; i++ {
+		if err = sink.Write(RecordT{RequestID: "1"}); err != nil {
+			t.Fatalf("unexpected error writing record %d: %v", i, err)
+		}
+	}
+
+	for _, filev := range []string{path, path + ".1", path + ".2"} {
+		if _, err = os.Stat(filev); err != nil {
+			t.Errorf("file '%s' not found: %v", filev, err)
+		}
+	}
+
+	if _, err = os.Stat(path + ".3"); err == nil {
+		t.Errorf("more backups than the max kept")
+	}
+}
+
+func TestFileSinkFailedRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	// the file can not be renamed over a directory with content
+	if err := os.MkdirAll(filepath.Join(path+".1", "content"), 0o750); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sink, err := NewSink(v1alpha2.AuditConfigT{Sink: SinkFILE, File: v1alpha2.AuditFileConfigT{Path: path, MaxBackups: 1}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer sink.Close(context.Background())
+
+	s := sink.(*fileSinkT)
+	line, _ := encode(RecordT{RequestID: "1"})
+	s.maxSize = int64(len(line))
+
+	errs := 0
+	for i := 0; i < 5; i++ {
+		if err = sink.Write(RecordT{RequestID: "1"}); err != nil {
+			errs++
+		}
+	}
+
+	if errs != 1 {
+		t.Errorf("rotation errors reported = %d, want 1", errs)
+	}
+
+	content, _ := os.ReadFile(path)
+	if got := strings.Count(string(content), "\n"); got != 5 {
+		t.Errorf("records in file = %d, want all of them appended", got)
+	}
+
+	// the rotation is retried once the interval passes
+	os.RemoveAll(path + ".1")
+	s.rotateFailedAt = time.Now().Add(-rotateRetryInterval)
+	if err = sink.Write(RecordT{RequestID: "1"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if _, err = os.Stat(path + ".1"); err != nil {
+		t.Errorf("file not rotated after the retry interval: %v", err)
+	}
+}
+
+func TestUdpSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+
+	sink, err := NewSink(v1alpha2.AuditConfigT{Sink: SinkUDP, Udp: v1alpha2.AuditUdpConfigT{Address: conn.LocalAddr().String()}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := sink.(*queuedSinkT); !ok {
+		t.Fatalf("udp sink is not queued, so it would write in the request path")
+	}
+
+	for _, idv := range []string{"1", "2"} {
+		if err = sink.Write(RecordT{RequestID: idv, Decision: "allow"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, idv := range []string{"1", "2"} {
+		buffer := make([]byte, 1024)
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			t.Fatalf("record not received: %v", err)
+		}
+
+		var record RecordT
+		if err = json.Unmarshal(buffer[:n], &record); err != nil || record.RequestID != idv {
+			t.Errorf("record = %+v (%v), want request id '%s'", record, err, idv)
+		}
+	}
+
+	if err = sink.Close(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err = sink.Write(RecordT{RequestID: "3"}); err == nil {
+		t.Errorf("record written to a closed sink")
+	}
+
+	if err = sink.Close(context.Background()); err != nil {
+		t.Errorf("unexpected error closing twice: %v", err)
+	}
+}
+
+// blockingSenderT blocks every send until released, as an unreachable destination
+type blockingSenderT struct {
+	release chan struct{}
+	sent    chan []byte
+}
+
+func (s *blockingSenderT) send(ctx context.Context, lines [][]byte) error {
+	<-s.release
+	for _, linev := range lines {
+		s.sent <- linev
+	}
+	return nil
+}
+
+func (s *blockingSenderT) close() error {
+	return errors.New("closed")
+}
+
+func TestQueuedSinkDoesNotBlock(t *testing.T) {
+	sender := &blockingSenderT{release: make(chan struct{}), sent: make(chan []byte, queueSize+1)}
+	sink := newQueuedSink(SinkSYSLOG, sender)
+
+	// one record is being sent, and the rest fill the queue
+	dropped := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < queueSize+10; i++ {
+			if err := sink.Write(RecordT{RequestID: "1"}); err != nil {
+				dropped++
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("writes blocked by the destination")
+	}
+
+	if dropped == 0 {
+		t.Errorf("no records dropped with the queue full")
+	}
+
+	close(sender.release)
+	if err := sink.Close(context.Background()); err == nil || err.Error() != "closed" {
+		t.Errorf("Close() error = %v, want the one of the destination", err)
+	}
+
+	if got := len(sender.sent); got != queueSize+10-dropped {
+		t.Errorf("records sent = %d, want the %d queued", got, queueSize+10-dropped)
+	}
+}
+
+func TestHttpSink(t *testing.T) {
+	batches := make(chan []string, 10)
+	failing := atomic.Bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		batches <- strings.Split(strings.TrimSpace(string(body)), "\n")
+	}))
+	defer server.Close()
+
+	sink, err := NewSink(v1alpha2.AuditConfigT{Sink: SinkHTTP, Http: v1alpha2.AuditHttpConfigT{
+		Url:           server.URL,
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// full batches are sent without waiting for the flush interval
+	for _, idv := range []string{"1", "2", "3"} {
+		if err = sink.Write(RecordT{RequestID: idv}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	select {
+	case batch := <-batches:
+		if len(batch) != 2 {
+			t.Errorf("batch = %v, want 2 records", batch)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("full batch not sent")
+	}
+
+	// failed batches are reported by the next write
+	failing.Store(true)
+	if err = sink.Write(RecordT{RequestID: "4"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for err == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		err = sink.Write(RecordT{RequestID: "5"})
+	}
+	if err == nil || !strings.Contains(err.Error(), "failed sending 2 records") {
+		t.Errorf("Write() error = %v, want the failure of the previous batch", err)
+	}
+
+	// the rest of the records are sent on close
+	failing.Store(false)
+	if err = sink.Close(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if len(batches) == 0 {
+		t.Errorf("queued records not sent on close")
+	}
+}
+
+func TestHttpSinkCloseDeadline(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	sink, err := NewSink(v1alpha2.AuditConfigT{Sink: SinkHTTP, Http: v1alpha2.AuditHttpConfigT{
+		Url:       server.URL,
+		BatchSize: 1,
+		Timeout:   time.Hour,
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, idv := range []string{"1", "2", "3"} {
+		if err = sink.Write(RecordT{RequestID: idv}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// the destination hangs, so closing stops at the deadline dropping the records left
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = sink.Close(ctx)
+	if err == nil {
+		t.Errorf("Close() error = nil, want the records not sent")
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Close() took %v, want it bounded by the context", elapsed)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	line, err := encode(RecordT{Time: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), RequestID: "1", Decision: "deny", Reason: "expired"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(line)))
+	if !scanner.Scan() || scanner.Scan() {
+		t.Errorf("record is not a single line: %q", line)
+	}
+
+	want := `{"time":"2024-01-02T03:04:05Z","requestID":"1","method":"","host":"","path":"","decision":"deny","reason":"expired"}` + "\n"
+	if string(line) != want {
+		t.Errorf("encode() = %q, want %q", line, want)
+	}
+}
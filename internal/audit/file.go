@@ -0,0 +1,123 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"doorkeeper/api/v1alpha2"
+)
+
+const (
+	defaultFileMaxSizeMb  = 100
+	defaultFileMaxBackups = 10
+
+	// rotateRetryInterval is the time the file keeps growing after a failed rotation before retrying it
+	rotateRetryInterval = time.Minute
+)
+
+// fileSinkT appends the records to a file, rotating it when it reaches its max size.
+// Rotated files are renamed to <path>.1, <path>.2... being <path>.1 the newest
+type fileSinkT struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mutex          sync.Mutex
+	file           *os.File
+	size           int64
+	rotateFailedAt time.Time
+}
+
+func newFileSink(cfg v1alpha2.AuditFileConfigT) (s *fileSinkT, err error) {
+	s = &fileSinkT{
+		path:       cfg.Path,
+		maxSize:    int64(cfg.MaxSizeMb) * 1024 * 1024,
+		maxBackups: cfg.MaxBackups,
+	}
+
+	if s.maxSize == 0 {
+		s.maxSize = defaultFileMaxSizeMb * 1024 * 1024
+	}
+
+	if s.maxBackups == 0 {
+		s.maxBackups = defaultFileMaxBackups
+	}
+
+	err = s.open()
+	return s, err
+}
+
+func (s *fileSinkT) open() (err error) {
+	s.file, err = os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("unable to open audit file: %s", err.Error())
+	}
+
+	info, err := s.file.Stat()
+	if err != nil {
+		return fmt.Errorf("unable to stat audit file: %s", err.Error())
+	}
+	s.size = info.Size()
+
+	return err
+}
+
+// rotate shifts the backups, dropping the oldest one, and starts a new file
+func (s *fileSinkT) rotate() (err error) {
+	closeErr := s.file.Close()
+
+	os.Remove(fmt.Sprintf("%s.%d", s.path, s.maxBackups))
+	for i := s.maxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+	}
+
+	// the file is opened again even when it can not be closed or renamed, to keep writing
+	err = os.Rename(s.path, s.path+".1")
+	openErr := s.open()
+	if err != nil {
+		return fmt.Errorf("unable to rotate audit file: %s", err.Error())
+	}
+
+	return errors.Join(closeErr, openErr)
+}
+
+func (s *fileSinkT) Write(record RecordT) (err error) {
+	line, err := encode(record)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// records keep being appended when the rotation fails, which is reported
+	// once per retry instead of on every record
+	var rotateErr error
+	if s.size > 0 && s.size+int64(len(line)) > s.maxSize && time.Since(s.rotateFailedAt) >= rotateRetryInterval {
+		rotateErr = s.rotate()
+		if rotateErr != nil {
+			s.rotateFailedAt = time.Now()
+		}
+	}
+
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		recordsTotal.Inc(SinkFILE, resultFailed)
+		return errors.Join(rotateErr, err)
+	}
+
+	recordsTotal.Inc(SinkFILE, resultWritten)
+	return rotateErr
+}
+
+func (s *fileSinkT) Close(ctx context.Context) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.file.Close()
+}
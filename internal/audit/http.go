@@ -0,0 +1,77 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"doorkeeper/api/v1alpha2"
+)
+
+const (
+	defaultHttpTimeout       = 5 * time.Second
+	defaultHttpFlushInterval = time.Second
+	defaultHttpBatchSize     = 100
+)
+
+// httpSenderT posts the batches of records as JSON lines (application/x-ndjson).
+// It is written through a queuedSinkT
+type httpSenderT struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func newHttpSink(cfg v1alpha2.AuditHttpConfigT) (s *queuedSinkT, err error) {
+	sender := &httpSenderT{
+		url:     cfg.Url,
+		headers: cfg.Headers,
+		client:  &http.Client{Timeout: cfg.Timeout},
+	}
+
+	if sender.client.Timeout == 0 {
+		sender.client.Timeout = defaultHttpTimeout
+	}
+
+	batchSize := cfg.BatchSize
+	if batchSize == 0 {
+		batchSize = defaultHttpBatchSize
+	}
+
+	flushInterval := cfg.FlushInterval
+	if flushInterval == 0 {
+		flushInterval = defaultHttpFlushInterval
+	}
+
+	return newBatchedSink(SinkHTTP, sender, batchSize, flushInterval), err
+}
+
+func (s *httpSenderT) send(ctx context.Context, lines [][]byte) (err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(bytes.Join(lines, nil)))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	for hk, hv := range s.headers {
+		req.Header.Set(hk, hv)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (s *httpSenderT) close() error {
+	return nil
+}
@@ -0,0 +1,82 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"log/syslog"
+	"net"
+
+	"doorkeeper/api/v1alpha2"
+)
+
+const (
+	defaultSyslogTag = "doorkeeper"
+)
+
+// syslogSinkT sends the records to a syslog daemon, local or remote, with auth facility.
+// Both network sinks are written through a queuedSinkT
+type syslogSinkT struct {
+	writer *syslog.Writer
+}
+
+func newSyslogSink(cfg v1alpha2.AuditSyslogConfigT) (s *syslogSinkT, err error) {
+	s = &syslogSinkT{}
+
+	tag := cfg.Tag
+	if tag == "" {
+		tag = defaultSyslogTag
+	}
+
+	s.writer, err = syslog.Dial(cfg.Network, cfg.Address, syslog.LOG_INFO|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return s, fmt.Errorf("unable to connect to syslog: %s", err.Error())
+	}
+
+	return s, err
+}
+
+func (s *syslogSinkT) send(ctx context.Context, lines [][]byte) (err error) {
+	for _, linev := range lines {
+		err = s.writer.Info(string(linev))
+		if err != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (s *syslogSinkT) close() error {
+	return s.writer.Close()
+}
+
+// udpSinkT sends each record in a datagram
+type udpSinkT struct {
+	conn net.Conn
+}
+
+func newUdpSink(cfg v1alpha2.AuditUdpConfigT) (s *udpSinkT, err error) {
+	s = &udpSinkT{}
+
+	s.conn, err = net.Dial("udp", cfg.Address)
+	if err != nil {
+		return s, fmt.Errorf("unable to set udp audit address: %s", err.Error())
+	}
+
+	return s, err
+}
+
+func (s *udpSinkT) send(ctx context.Context, lines [][]byte) (err error) {
+	for _, linev := range lines {
+		_, err = s.conn.Write(linev)
+		if err != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (s *udpSinkT) close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,187 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// senderI is a destination receiving the records in batches, from the queue of a queuedSinkT
+type senderI interface {
+	send(ctx context.Context, lines [][]byte) error
+	close() error
+}
+
+// queuedSinkT sends the records from its own goroutine, so a slow or unreachable
+// destination does not delay the decisions. Records over the queue size are dropped.
+// Records are sent one by one, or in batches of batchSize every flushInterval
+type queuedSinkT struct {
+	sink          string
+	sender        senderI
+	batchSize     int
+	flushInterval time.Duration
+
+	// queueMutex guards the queue from being written once closed
+	queueMutex sync.RWMutex
+	queue      chan []byte
+	closed     bool
+	done       chan struct{}
+
+	// sending is canceled when closing takes longer than allowed, dropping the records left
+	sending context.Context
+	cancel  context.CancelFunc
+
+	// sendErr is the last failure sending a batch, reported once by the next write
+	sendErrMutex sync.Mutex
+	sendErr      error
+}
+
+func newQueuedSink(sink string, sender senderI) *queuedSinkT {
+	return newBatchedSink(sink, sender, 1, 0)
+}
+
+func newBatchedSink(sink string, sender senderI, batchSize int, flushInterval time.Duration) *queuedSinkT {
+	s := &queuedSinkT{
+		sink:          sink,
+		sender:        sender,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		queue:         make(chan []byte, queueSize),
+		done:          make(chan struct{}),
+	}
+	s.sending, s.cancel = context.WithCancel(context.Background())
+
+	go s.run()
+
+	return s
+}
+
+// Write queues the record to be sent. The failure of a previous batch
+// is returned once, as it can not be reported when it happens
+func (s *queuedSinkT) Write(record RecordT) (err error) {
+	line, err := encode(record)
+	if err != nil {
+		return err
+	}
+
+	s.queueMutex.RLock()
+	defer s.queueMutex.RUnlock()
+
+	if s.closed {
+		recordsTotal.Inc(s.sink, resultDropped)
+		return fmt.Errorf("audit %s sink is closed, record dropped", s.sink)
+	}
+
+	select {
+	case s.queue <- line:
+		{
+			s.sendErrMutex.Lock()
+			err, s.sendErr = s.sendErr, nil
+			s.sendErrMutex.Unlock()
+
+			return err
+		}
+	default:
+		{
+			recordsTotal.Inc(s.sink, resultDropped)
+			return fmt.Errorf("audit %s queue is full, record dropped", s.sink)
+		}
+	}
+}
+
+// run sends the batches when they are full or on every flush interval,
+// until the queue is closed
+func (s *queuedSinkT) run() {
+	defer close(s.done)
+
+	var flush <-chan time.Time
+	if s.flushInterval > 0 {
+		ticker := time.NewTicker(s.flushInterval)
+		defer ticker.Stop()
+		flush = ticker.C
+	}
+
+	batch := [][]byte{}
+	for {
+		select {
+		case line, ok := <-s.queue:
+			{
+				if !ok {
+					s.send(batch)
+					return
+				}
+
+				batch = append(batch, line)
+				if len(batch) >= s.batchSize {
+					s.send(batch)
+					batch = [][]byte{}
+				}
+			}
+		case <-flush:
+			{
+				s.send(batch)
+				batch = [][]byte{}
+			}
+		}
+	}
+}
+
+// send delivers the batch, counting its records by result.
+// Once sending is canceled, the records are dropped without trying
+func (s *queuedSinkT) send(batch [][]byte) {
+	if len(batch) == 0 {
+		return
+	}
+
+	result := resultWritten
+	defer func() {
+		for range batch {
+			recordsTotal.Inc(s.sink, result)
+		}
+	}()
+
+	if s.sending.Err() != nil {
+		result = resultDropped
+		return
+	}
+
+	err := s.sender.send(s.sending, batch)
+	if err != nil {
+		result = resultFailed
+
+		s.sendErrMutex.Lock()
+		s.sendErr = fmt.Errorf("audit %s sink failed sending %d records: %s", s.sink, len(batch), err.Error())
+		s.sendErrMutex.Unlock()
+	}
+}
+
+// Close sends the records queued and closes the destination. When the context is done
+// first, the records left are dropped and the destination is closed without waiting
+// for the batch being sent, so closing never takes longer than allowed
+func (s *queuedSinkT) Close(ctx context.Context) (err error) {
+	s.queueMutex.Lock()
+	if s.closed {
+		s.queueMutex.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.queue)
+	s.queueMutex.Unlock()
+
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		{
+			err = fmt.Errorf("audit %s sink closed before sending every record: %s", s.sink, ctx.Err().Error())
+		}
+	}
+	s.cancel()
+
+	closeErr := s.sender.close()
+	if err == nil {
+		err = closeErr
+	}
+
+	return err
+}
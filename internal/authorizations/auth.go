@@ -2,16 +2,34 @@ package authorizations
 
 import (
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
 
 	"doorkeeper/api/v1alpha2"
+	"doorkeeper/internal/clientip"
 	"doorkeeper/internal/config"
+	"doorkeeper/internal/reasons"
 )
 
 type AuthI interface {
 	Check(*http.Request) error
 }
 
+// ReloaderI is implemented by authorizations depending on external resources,
+// such as files, which are loaded again when they change
+type ReloaderI interface {
+	Reload() (reloaded bool, err error)
+}
+
+// CloserI is implemented by authorizations holding shared resources,
+// which are released once the authorization is replaced
+type CloserI interface {
+	Close()
+}
+
 func GetAuthorization(cfg v1alpha2.AuthorizationConfigT) (AuthI, error) {
 	switch cfg.Type {
 	case config.ConfigAuthTypeHMAC:
@@ -26,7 +44,204 @@ func GetAuthorization(cfg v1alpha2.AuthorizationConfigT) (AuthI, error) {
 		{
 			return NewMatch(cfg)
 		}
+	case config.ConfigAuthTypeGEOIP:
+		{
+			return NewGeoIP(cfg)
+		}
+	case config.ConfigAuthTypeSCHEDULE:
+		{
+			return NewSchedule(cfg)
+		}
+	case config.ConfigAuthTypeSIGNEDCOOKIE:
+		{
+			return NewSignedCookie(cfg)
+		}
 	}
 
 	return nil, fmt.Errorf("unsupported authorization type")
 }
+
+// paramT is the value of the request checked by an authorization
+type paramT struct {
+	paramType string
+	name      string
+
+	index         int
+	compiledRegex *regexp.Regexp
+
+	// rawQuery takes query params as sent, for values signed once escaped
+	rawQuery bool
+}
+
+func newParam(cfg v1alpha2.AuthParamConfigT) (p paramT, err error) {
+	p = paramT{
+		paramType: cfg.Type,
+		name:      cfg.Name,
+		index:     cfg.Index,
+	}
+
+	if p.paramType == config.ConfigAuthParamTypePATHREGEX {
+		p.compiledRegex, err = regexp.Compile(cfg.Pattern)
+	}
+
+	return p, err
+}
+
+// get returns the value of the param. Empty params are reported as errors
+func (p *paramT) get(r *http.Request) (param string, err error) {
+	param, _, err = p.lookup(r)
+	if err != nil {
+		return param, err
+	}
+
+	if param == "" {
+		err = reasons.Errorf(reasons.MissingParam, "empty %s param '%s' in request", p.paramType, p.name)
+	}
+
+	return param, err
+}
+
+// lookup returns the value of the param, and whether it is present in the request,
+// so params present with empty values can be told apart from the missing ones
+func (p *paramT) lookup(r *http.Request) (param string, found bool, err error) {
+	switch p.paramType {
+	case config.ConfigAuthParamTypeHEADER:
+		{
+			values := r.Header.Values(p.name)
+			if len(values) > 0 {
+				param, found = values[0], true
+			}
+		}
+	case config.ConfigAuthParamTypeQUERY:
+		{
+			if p.rawQuery {
+				param, found = rawQueryValue(r.URL.RawQuery, p.name)
+				break
+			}
+
+			values, ok := r.URL.Query()[p.name]
+			if ok && len(values) > 0 {
+				param, found = values[0], true
+			}
+		}
+	case config.ConfigAuthParamTypeCOOKIE:
+		{
+			cookie, cookieErr := r.Cookie(p.name)
+			if cookieErr == nil {
+				param, found = cookie.Value, true
+			}
+		}
+	case config.ConfigAuthParamTypePATHSEGMENT, config.ConfigAuthParamTypePATHREGEX:
+		{
+			start, end, ok := p.pathBounds(r.URL.Path)
+			if ok {
+				param, found = r.URL.Path[start:end], true
+			}
+		}
+	case config.ConfigAuthParamTypeCLIENTIP:
+		{
+			var ip net.IP
+			ip, err = clientip.FromRequest(r)
+			if err != nil {
+				return param, false, reasons.Errorf(reasons.InvalidParam, "unable to get client ip: %s", err.Error())
+			}
+			param, found = ip.String(), true
+		}
+	case config.ConfigAuthParamTypeMETHOD:
+		{
+			param, found = r.Method, true
+		}
+	case config.ConfigAuthParamTypeHOST:
+		{
+			param, found = r.Host, true
+		}
+	case config.ConfigAuthParamTypePATH:
+		{
+			param, found = r.URL.Path, true
+		}
+	case config.ConfigAuthParamTypeQUERYSTRING:
+		{
+			param, found = r.URL.RawQuery, true
+		}
+	default:
+		{
+			err = fmt.Errorf("unsupported param type '%s'", p.paramType)
+		}
+	}
+
+	return param, found, err
+}
+
+// rawQueryValue returns the first value of the query param without unescaping it
+func rawQueryValue(rawQuery, name string) (value string, found bool) {
+	for _, pair := range strings.Split(rawQuery, "&") {
+		key, value, _ := strings.Cut(pair, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil && unescaped == name {
+			return value, true
+		}
+	}
+
+	return value, false
+}
+
+// pathBounds returns where the value of PATH_SEGMENT and PATH_REGEX params is in the path
+func (p *paramT) pathBounds(path string) (start, end int, found bool) {
+	switch p.paramType {
+	case config.ConfigAuthParamTypePATHSEGMENT:
+		{
+			bounds := [][2]int{}
+			for i := 0; i < len(path); i++ {
+				if path[i] == '/' {
+					continue
+				}
+
+				segmentStart := i
+				for i < len(path) && path[i] != '/' {
+					i++
+				}
+				bounds = append(bounds, [2]int{segmentStart, i})
+			}
+
+			index := p.index
+			if index < 0 {
+				index += len(bounds)
+			}
+
+			if index >= 0 && index < len(bounds) {
+				return bounds[index][0], bounds[index][1], true
+			}
+		}
+	case config.ConfigAuthParamTypePATHREGEX:
+		{
+			match := p.compiledRegex.FindStringSubmatchIndex(path)
+			if len(match) > 3 && match[2] >= 0 {
+				return match[2], match[3], true
+			}
+		}
+	}
+
+	return 0, 0, false
+}
+
+// pathWithout returns the path of the request without the value of PATH_SEGMENT and PATH_REGEX params,
+// along with one of the slashes around it, e.g. '/t/<token>/file.mp4' is returned as '/t/file.mp4'.
+// Other params do not change the path
+func (p *paramT) pathWithout(path string) string {
+	start, end, found := p.pathBounds(path)
+	if !found {
+		return path
+	}
+
+	switch {
+	case end < len(path) && path[end] == '/' && (start == 0 || path[start-1] == '/'):
+		{
+			end++
+		}
+	case end == len(path) && start > 0 && path[start-1] == '/':
+		{
+			start--
+		}
+	}
+
+	return path[:start] + path[end:]
+}
@@ -0,0 +1,324 @@
+package authorizations
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"doorkeeper/api/v1alpha2"
+	"doorkeeper/internal/clientip"
+	"doorkeeper/internal/clock"
+	"doorkeeper/internal/config"
+	hmacpkg "doorkeeper/internal/hmac"
+	"doorkeeper/internal/reasons"
+	"doorkeeper/internal/redaction"
+)
+
+func TestParamPath(t *testing.T) {
+	tests := []struct {
+		name        string
+		param       v1alpha2.AuthParamConfigT
+		path        string
+		wantValue   string
+		wantFound   bool
+		wantWithout string
+	}{
+		{
+			name:  "segment in the middle",
+			param: v1alpha2.AuthParamConfigT{Type: config.ConfigAuthParamTypePATHSEGMENT, Index: 1},
+			path:  "/t/token/file.mp4", wantValue: "token", wantFound: true, wantWithout: "/t/file.mp4",
+		},
+		{
+			name:  "segment from the end",
+			param: v1alpha2.AuthParamConfigT{Type: config.ConfigAuthParamTypePATHSEGMENT, Index: -2},
+			path:  "/t/token/file.mp4", wantValue: "token", wantFound: true, wantWithout: "/t/file.mp4",
+		},
+		{
+			name:  "last segment",
+			param: v1alpha2.AuthParamConfigT{Type: config.ConfigAuthParamTypePATHSEGMENT, Index: -1},
+			path:  "/videos/1.ts/token", wantValue: "token", wantFound: true, wantWithout: "/videos/1.ts",
+		},
+		{
+			name:  "first segment",
+			param: v1alpha2.AuthParamConfigT{Type: config.ConfigAuthParamTypePATHSEGMENT, Index: 0},
+			path:  "/token/videos/1.ts", wantValue: "token", wantFound: true, wantWithout: "/videos/1.ts",
+		},
+		{
+			name:  "empty segments are skipped",
+			param: v1alpha2.AuthParamConfigT{Type: config.ConfigAuthParamTypePATHSEGMENT, Index: 1},
+			path:  "//t//token/file.mp4", wantValue: "token", wantFound: true, wantWithout: "//t//file.mp4",
+		},
+		{
+			name:  "segment out of range",
+			param: v1alpha2.AuthParamConfigT{Type: config.ConfigAuthParamTypePATHSEGMENT, Index: 5},
+			path:  "/t/token/file.mp4", wantFound: false, wantWithout: "/t/token/file.mp4",
+		},
+		{
+			name:  "regex capture",
+			param: v1alpha2.AuthParamConfigT{Type: config.ConfigAuthParamTypePATHREGEX, Pattern: "^/t/([^/]+)/"},
+			path:  "/t/token/file.mp4", wantValue: "token", wantFound: true, wantWithout: "/t/file.mp4",
+		},
+		{
+			name:  "regex capture inside a segment",
+			param: v1alpha2.AuthParamConfigT{Type: config.ConfigAuthParamTypePATHREGEX, Pattern: `^/file-([a-z]+)\.mp4$`},
+			path:  "/file-token.mp4", wantValue: "token", wantFound: true, wantWithout: "/file-.mp4",
+		},
+		{
+			name:  "regex not matching",
+			param: v1alpha2.AuthParamConfigT{Type: config.ConfigAuthParamTypePATHREGEX, Pattern: "^/t/([^/]+)/"},
+			path:  "/videos/1.ts", wantFound: false, wantWithout: "/videos/1.ts",
+		},
+		{
+			name:  "query params do not change the path",
+			param: v1alpha2.AuthParamConfigT{Type: config.ConfigAuthParamTypeQUERY, Name: "token"},
+			path:  "/videos/1.ts", wantFound: false, wantWithout: "/videos/1.ts",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := newParam(tt.param)
+			if err != nil {
+				t.Fatalf("unexpected error creating param: %v", err)
+			}
+
+			value, found, err := p.lookup(httptest.NewRequest("GET", tt.path, nil))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if found != tt.wantFound || value != tt.wantValue {
+				t.Errorf("lookup(%q) = (%q, %v), want (%q, %v)", tt.path, value, found, tt.wantValue, tt.wantFound)
+			}
+
+			if without := p.pathWithout(tt.path); without != tt.wantWithout {
+				t.Errorf("pathWithout(%q) = %q, want %q", tt.path, without, tt.wantWithout)
+			}
+		})
+	}
+}
+
+func TestHmacTokenInPath(t *testing.T) {
+	key := "00112233445566778899aabbccddeeff"
+	binaryKey, _ := hex.DecodeString(key)
+
+	sign := func(fields string) string {
+		mac := hmac.New(sha256.New, binaryKey)
+		mac.Write([]byte(fields))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	exp := fmt.Sprintf("exp=%d", time.Now().Add(time.Hour).Unix())
+	urlToken := exp + "~hmac=" + sign(exp+"~url=/t/file.mp4")
+	aclToken := exp + "~acl=*.mp4" + "~hmac=" + sign(exp+"~acl=*.mp4")
+
+	auth, err := NewHmac(v1alpha2.AuthorizationConfigT{
+		Type:  config.ConfigAuthTypeHMAC,
+		Param: v1alpha2.AuthParamConfigT{Type: config.ConfigAuthParamTypePATHSEGMENT, Index: 1},
+		Hmac: v1alpha2.HmacConfigT{
+			Type:                config.ConfigAuthHmacTypeURL,
+			EncryptionKey:       key,
+			EncryptionAlgorithm: "sha256",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating authorization: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{name: "token signing the url without it", path: "/t/" + urlToken + "/file.mp4"},
+		{name: "token with acl", path: "/t/" + aclToken + "/file.mp4"},
+		{name: "token for another file", path: "/t/" + urlToken + "/other.mp4", wantErr: true},
+		{name: "acl not covering the path", path: "/t/" + aclToken + "/file.ts", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := auth.Check(httptest.NewRequest("GET", tt.path, nil))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Check(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			}
+		})
+	}
+
+	// the token is located to be redacted from the logs
+	redactor, err := redaction.NewRedactor(v1alpha2.RedactionConfigT{}, []redaction.PathTokenI{auth})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := redactor.RedactPath("/t/" + urlToken + "/file.mp4"); got != "/t/[REDACTED]/file.mp4" {
+		t.Errorf("RedactPath() = %s, want the token redacted", got)
+	}
+}
+
+func TestHmacAkamaiGolden(t *testing.T) {
+	clock.SetFixed(time.Unix(1700000000, 0))
+	defer clock.Reset()
+
+	// tokens generated by the Akamai EdgeAuth reference tooling
+	urlToken := "exp=1700000100~id=user-1~hmac=818657bef3a3f46960b76bc9aa6bb603ab2f0ee5163c84cfaf83b5b2eece6675"
+	urlEarlyToken := "exp=1700000100~hmac=bfba99054666dd92d7ab55b5fbd64fc96d5f366e8fc5d898d84f97b1133667a1"
+	aclToken := "exp=1700000100~acl=/videos/*~hmac=6af2f79cd57698aa47968f961761fad6cc7423cafd05f11396dbd25b87be8891"
+	aclEarlyToken := "ip=192.0.2.5~st=1699999900~exp=1700000100~acl=%2fvideos%2f%2a~id=user+1~data=a%2cb~hmac=ae7ab2c7b8b87f026eca64d9b9c52d3149441639b8a7489e312d97e88d6c057c"
+
+	tests := []struct {
+		name        string
+		earlyEncode bool
+		path        string
+		wantErr     bool
+	}{
+		{name: "url", path: "/videos/1.ts?__token__=" + urlToken},
+		{name: "url with escape early", earlyEncode: true, path: "/videos/1.ts?__token__=" + urlEarlyToken},
+		{name: "url escaped early without early encode", path: "/videos/1.ts?__token__=" + urlEarlyToken, wantErr: true},
+		{name: "url with early encode not escaped early", earlyEncode: true, path: "/videos/1.ts?__token__=" + urlToken, wantErr: true},
+		{name: "acl", path: "/videos/1.ts?__token__=" + aclToken},
+		{name: "acl with escape early", path: "/videos/1.ts?a=1&__token__=" + aclEarlyToken},
+		{name: "acl with escape early and early encode", earlyEncode: true, path: "/videos/1.ts?__token__=" + aclEarlyToken},
+		{name: "acl not covering the path", path: "/img/1.png?__token__=" + aclEarlyToken, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth, err := NewHmac(v1alpha2.AuthorizationConfigT{
+				Type:  config.ConfigAuthTypeHMAC,
+				Param: v1alpha2.AuthParamConfigT{Type: config.ConfigAuthParamTypeQUERY, Name: "__token__"},
+				Hmac: v1alpha2.HmacConfigT{
+					Type:                config.ConfigAuthHmacTypeURL,
+					EncryptionKey:       "00112233445566778899aabbccddeeff",
+					EncryptionAlgorithm: "sha256",
+					Compatibility:       hmacpkg.CompatibilityAKAMAI,
+					Url:                 v1alpha2.HmacUrlConfigT{EarlyEncode: tt.earlyEncode},
+				},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error creating authorization: %v", err)
+			}
+
+			r := clientip.NewContext(httptest.NewRequest("GET", tt.path, nil), net.ParseIP("192.0.2.5"), nil)
+			err = auth.Check(r)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Check(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMatchOperators(t *testing.T) {
+	float := func(f float64) *float64 { return &f }
+
+	header := func(operator string) v1alpha2.MatchConditionConfigT {
+		return v1alpha2.MatchConditionConfigT{Source: config.ConfigAuthParamTypeHEADER, Name: "X-Level", Operator: operator}
+	}
+	withValue := func(cond v1alpha2.MatchConditionConfigT, value string) v1alpha2.MatchConditionConfigT {
+		cond.Value = value
+		return cond
+	}
+	reversed := func(cond v1alpha2.MatchConditionConfigT) v1alpha2.MatchConditionConfigT {
+		cond.Reverse = true
+		return cond
+	}
+
+	tests := []struct {
+		name       string
+		match      v1alpha2.MatchConfigT
+		url        string
+		header     string
+		wantReason string
+	}{
+		{name: "present", match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{header(config.ConfigAuthMatchOperatorPRESENT)}}, header: "1"},
+		{name: "present missing", match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{header(config.ConfigAuthMatchOperatorPRESENT)}}, wantReason: reasons.NoMatch},
+		{name: "present empty", match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{header(config.ConfigAuthMatchOperatorPRESENT)}}, header: " "},
+		{name: "absent", match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{header(config.ConfigAuthMatchOperatorABSENT)}}},
+		{name: "absent present", match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{header(config.ConfigAuthMatchOperatorABSENT)}}, header: "1", wantReason: reasons.NoMatch},
+
+		{name: "regex", match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{withValue(header(config.ConfigAuthMatchOperatorREGEX), "^[0-9]+$")}}, header: "42"},
+		{name: "regex not matching", match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{withValue(header(config.ConfigAuthMatchOperatorREGEX), "^[0-9]+$")}}, header: "4x", wantReason: reasons.NoMatch},
+		{name: "regex missing", match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{withValue(header(config.ConfigAuthMatchOperatorREGEX), ".*")}}, wantReason: reasons.NoMatch},
+		{name: "exact", match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{withValue(header(config.ConfigAuthMatchOperatorEXACT), "gold")}}, header: "gold"},
+		{name: "exact is case sensitive", match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{withValue(header(config.ConfigAuthMatchOperatorEXACT), "gold")}}, header: "Gold", wantReason: reasons.NoMatch},
+		{name: "exact empty value", match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{header(config.ConfigAuthMatchOperatorEXACT)}}, wantReason: reasons.NoMatch},
+		{name: "prefix", match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{{Source: config.ConfigAuthParamTypePATH, Operator: config.ConfigAuthMatchOperatorPREFIX, Value: "/videos/"}}}, url: "/videos/1.ts"},
+		{name: "prefix not matching", match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{{Source: config.ConfigAuthParamTypePATH, Operator: config.ConfigAuthMatchOperatorPREFIX, Value: "/videos/"}}}, url: "/images/1.png", wantReason: reasons.NoMatch},
+		{name: "suffix", match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{{Source: config.ConfigAuthParamTypePATH, Operator: config.ConfigAuthMatchOperatorSUFFIX, Value: ".ts"}}}, url: "/videos/1.ts?x=.mp4"},
+		{name: "suffix not matching", match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{{Source: config.ConfigAuthParamTypePATH, Operator: config.ConfigAuthMatchOperatorSUFFIX, Value: ".ts"}}}, url: "/videos/1.mp4", wantReason: reasons.NoMatch},
+		{name: "one of", match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{{Source: config.ConfigAuthParamTypeMETHOD, Operator: config.ConfigAuthMatchOperatorONEOF, Values: []string{"GET", "HEAD"}}}}},
+		{name: "one of not matching", match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{{Source: config.ConfigAuthParamTypeMETHOD, Operator: config.ConfigAuthMatchOperatorONEOF, Values: []string{"POST", "PUT"}}}}, wantReason: reasons.NoMatch},
+
+		{name: "range", match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{{Source: config.ConfigAuthParamTypeQUERY, Name: "level", Operator: config.ConfigAuthMatchOperatorRANGE, Min: float(1), Max: float(10)}}}, url: "/?level=5"},
+		{name: "range inclusive min", match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{{Source: config.ConfigAuthParamTypeQUERY, Name: "level", Operator: config.ConfigAuthMatchOperatorRANGE, Min: float(1), Max: float(10)}}}, url: "/?level=1"},
+		{name: "range inclusive max", match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{{Source: config.ConfigAuthParamTypeQUERY, Name: "level", Operator: config.ConfigAuthMatchOperatorRANGE, Min: float(1), Max: float(10)}}}, url: "/?level=10.0"},
+		{name: "range above", match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{{Source: config.ConfigAuthParamTypeQUERY, Name: "level", Operator: config.ConfigAuthMatchOperatorRANGE, Min: float(1), Max: float(10)}}}, url: "/?level=10.5", wantReason: reasons.NoMatch},
+		{name: "range only min", match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{{Source: config.ConfigAuthParamTypeQUERY, Name: "level", Operator: config.ConfigAuthMatchOperatorRANGE, Min: float(-1)}}}, url: "/?level=1e6"},
+		{name: "range not a number", match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{{Source: config.ConfigAuthParamTypeQUERY, Name: "level", Operator: config.ConfigAuthMatchOperatorRANGE, Min: float(1)}}}, url: "/?level=high", wantReason: reasons.NoMatch},
+		{name: "range missing", match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{{Source: config.ConfigAuthParamTypeQUERY, Name: "level", Operator: config.ConfigAuthMatchOperatorRANGE, Min: float(1)}}}, wantReason: reasons.NoMatch},
+
+		{name: "reversed condition", match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{reversed(withValue(header(config.ConfigAuthMatchOperatorEXACT), "gold"))}}, header: "silver"},
+		{name: "reversed condition missing", match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{reversed(withValue(header(config.ConfigAuthMatchOperatorEXACT), "gold"))}}},
+		{name: "reversed match", match: v1alpha2.MatchConfigT{Reverse: true, Conditions: []v1alpha2.MatchConditionConfigT{withValue(header(config.ConfigAuthMatchOperatorEXACT), "gold")}}, header: "gold", wantReason: reasons.NoMatch},
+
+		{name: "all conditions", url: "/videos/1.ts?level=5", header: "gold", match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{
+			{Source: config.ConfigAuthParamTypePATH, Operator: config.ConfigAuthMatchOperatorPREFIX, Value: "/videos/"},
+			{Source: config.ConfigAuthParamTypeQUERYSTRING, Operator: config.ConfigAuthMatchOperatorEXACT, Value: "level=5"},
+			withValue(header(config.ConfigAuthMatchOperatorEXACT), "gold"),
+		}}},
+		{name: "one condition failing", url: "/videos/1.ts", header: "silver", match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{
+			{Source: config.ConfigAuthParamTypePATH, Operator: config.ConfigAuthMatchOperatorPREFIX, Value: "/videos/"},
+			withValue(header(config.ConfigAuthMatchOperatorEXACT), "gold"),
+		}}, wantReason: reasons.NoMatch},
+		{name: "pattern and conditions", url: "/videos/1.ts", header: "gold", match: v1alpha2.MatchConfigT{Pattern: "^example\\.com$", Conditions: []v1alpha2.MatchConditionConfigT{
+			withValue(header(config.ConfigAuthMatchOperatorEXACT), "gold"),
+		}}},
+		{name: "pattern failing", url: "/videos/1.ts", header: "gold", match: v1alpha2.MatchConfigT{Pattern: "^other\\.com$", Conditions: []v1alpha2.MatchConditionConfigT{
+			withValue(header(config.ConfigAuthMatchOperatorEXACT), "gold"),
+		}}, wantReason: reasons.NoMatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth, err := NewMatch(v1alpha2.AuthorizationConfigT{
+				Type:  config.ConfigAuthTypeMATCH,
+				Param: v1alpha2.AuthParamConfigT{Type: config.ConfigAuthParamTypeHOST},
+				Match: tt.match,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error creating authorization: %v", err)
+			}
+
+			url := tt.url
+			if url == "" {
+				url = "/"
+			}
+			r := httptest.NewRequest("GET", "http://example.com"+url, nil)
+			if tt.header != "" {
+				r.Header.Set("X-Level", tt.header)
+			}
+
+			err = auth.Check(r)
+			if reasons.Get(err) != tt.wantReason {
+				t.Errorf("Check() error = %v, want reason '%s'", err, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestMatchInvalidRegex(t *testing.T) {
+	_, err := NewMatch(v1alpha2.AuthorizationConfigT{
+		Type: config.ConfigAuthTypeMATCH,
+		Match: v1alpha2.MatchConfigT{Conditions: []v1alpha2.MatchConditionConfigT{
+			{Source: config.ConfigAuthParamTypePATH, Operator: config.ConfigAuthMatchOperatorREGEX, Value: "("},
+		}},
+	})
+	if err == nil {
+		t.Errorf("NewMatch() error = nil, want the regex error")
+	}
+}
@@ -0,0 +1,110 @@
+package authorizations
+
+import (
+	"doorkeeper/api/v1alpha2"
+	"doorkeeper/internal/geoip"
+	"doorkeeper/internal/reasons"
+	"doorkeeper/internal/utils"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+type GeoIPT struct {
+	param paramT
+
+	reverse    bool
+	countries  []string
+	continents []string
+	asns       []uint
+
+	databases      []string
+	databasesState []utils.FileStateT
+	databasesOpen  atomic.Pointer[geoip.DatabasesT]
+}
+
+func NewGeoIP(cfg v1alpha2.AuthorizationConfigT) (g *GeoIPT, err error) {
+	g = &GeoIPT{
+		reverse:    cfg.GeoIp.Reverse,
+		countries:  cfg.GeoIp.Countries,
+		continents: cfg.GeoIp.Continents,
+		asns:       cfg.GeoIp.Asns,
+		databases:  cfg.GeoIp.Databases,
+	}
+
+	g.param, err = newParam(cfg.Param)
+	if err != nil {
+		return g, err
+	}
+
+	err = g.loadDatabases()
+	return g, err
+}
+
+// loadDatabases opens the databases, replacing the ones in use
+func (a *GeoIPT) loadDatabases() (err error) {
+	// state is taken before reading, so changes while reading are detected later
+	state, err := utils.GetFilesState(a.databases)
+	if err != nil {
+		return err
+	}
+
+	databases, err := geoip.Open(a.databases)
+	if err != nil {
+		return err
+	}
+
+	a.databasesOpen.Store(databases)
+	a.databasesState = state
+
+	return err
+}
+
+// Reload opens the databases again when they change, e.g. after being updated by geoipupdate
+func (a *GeoIPT) Reload() (reloaded bool, err error) {
+	changed, err := utils.FilesChanged(a.databasesState)
+	if err != nil || !changed {
+		return false, err
+	}
+
+	err = a.loadDatabases()
+	return err == nil, err
+}
+
+func (a *GeoIPT) Check(r *http.Request) (err error) {
+	// get params
+
+	paramToCheck, err := a.param.get(r)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(paramToCheck))
+	if ip == nil {
+		return reasons.Errorf(reasons.InvalidParam, "invalid ip '%s' recieved", paramToCheck)
+	}
+
+	// check
+
+	record, err := a.databasesOpen.Load().Lookup(ip)
+	if err != nil {
+		return fmt.Errorf("unable to look up ip in geoip databases: %s", err.Error())
+	}
+
+	valid := (record.Country != "" && slices.Contains(a.countries, record.Country)) ||
+		(record.Continent != "" && slices.Contains(a.continents, record.Continent)) ||
+		(record.Asn != 0 && slices.Contains(a.asns, record.Asn))
+	if a.reverse {
+		valid = !valid
+	}
+
+	if !valid {
+		err = reasons.Errorf(reasons.GeoNotAllowed, "invalid geoip in request: country '%s', continent '%s', asn %d",
+			record.Country, record.Continent, record.Asn)
+	}
+
+	return err
+}
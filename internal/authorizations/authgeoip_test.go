@@ -0,0 +1,225 @@
+package authorizations
+
+import (
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+
+	"doorkeeper/api/v1alpha2"
+	"doorkeeper/internal/config"
+	"doorkeeper/internal/reasons"
+)
+
+// writeGeoIPDatabase writes a database in the path with the records given by network
+func writeGeoIPDatabase(t *testing.T, path string, records map[string]mmdbtype.Map) {
+	t.Helper()
+
+	tree, err := mmdbwriter.New(mmdbwriter.Options{DatabaseType: "Test", RecordSize: 24, IncludeReservedNetworks: true})
+	if err != nil {
+		t.Fatalf("unable to create database: %v", err)
+	}
+
+	for cidr, recordv := range records {
+		_, network, _ := net.ParseCIDR(cidr)
+		if err = tree.Insert(network, recordv); err != nil {
+			t.Fatalf("unable to insert network '%s': %v", cidr, err)
+		}
+	}
+
+	// written aside and renamed, as geoipupdate does
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		t.Fatalf("unable to create database file: %v", err)
+	}
+
+	_, err = tree.WriteTo(file)
+	file.Close()
+	if err != nil {
+		t.Fatalf("unable to write database: %v", err)
+	}
+
+	if err = os.Rename(tmpPath, path); err != nil {
+		t.Fatalf("unable to replace database: %v", err)
+	}
+}
+
+func geoRecord(country, continent string, asn uint32) mmdbtype.Map {
+	record := mmdbtype.Map{}
+	if country != "" {
+		record["country"] = mmdbtype.Map{"iso_code": mmdbtype.String(country)}
+		record["continent"] = mmdbtype.Map{"code": mmdbtype.String(continent)}
+	}
+	if asn != 0 {
+		record["autonomous_system_number"] = mmdbtype.Uint32(asn)
+	}
+	return record
+}
+
+func TestGeoIPCheck(t *testing.T) {
+	dir := t.TempDir()
+	countries := filepath.Join(dir, "country.mmdb")
+	asns := filepath.Join(dir, "asn.mmdb")
+
+	writeGeoIPDatabase(t, countries, map[string]mmdbtype.Map{
+		"192.0.2.0/24":    geoRecord("ES", "EU", 0),
+		"198.51.100.0/24": geoRecord("US", "NA", 0),
+		"2001:db8::/32":   geoRecord("JP", "AS", 0),
+	})
+	writeGeoIPDatabase(t, asns, map[string]mmdbtype.Map{
+		"198.51.100.0/25": geoRecord("", "", 64500),
+	})
+
+	tests := []struct {
+		name       string
+		geoip      v1alpha2.GeoIpConfigT
+		ip         string
+		wantReason string
+	}{
+		{
+			name:  "country allowed",
+			geoip: v1alpha2.GeoIpConfigT{Countries: []string{"ES"}},
+			ip:    "192.0.2.1",
+		},
+		{
+			name:       "country not allowed",
+			geoip:      v1alpha2.GeoIpConfigT{Countries: []string{"ES"}},
+			ip:         "198.51.100.200",
+			wantReason: reasons.GeoNotAllowed,
+		},
+		{
+			name:  "continent allowed",
+			geoip: v1alpha2.GeoIpConfigT{Continents: []string{"AS"}},
+			ip:    "2001:db8::1",
+		},
+		{
+			name:  "asn from another database",
+			geoip: v1alpha2.GeoIpConfigT{Countries: []string{"ES"}, Asns: []uint{64500}},
+			ip:    "198.51.100.1",
+		},
+		{
+			name:       "unknown country not allowed",
+			geoip:      v1alpha2.GeoIpConfigT{Countries: []string{"ES"}},
+			ip:         "203.0.113.1",
+			wantReason: reasons.GeoNotAllowed,
+		},
+		{
+			name:       "reverse denying the countries",
+			geoip:      v1alpha2.GeoIpConfigT{Countries: []string{"ES"}, Reverse: true},
+			ip:         "192.0.2.1",
+			wantReason: reasons.GeoNotAllowed,
+		},
+		{
+			name:  "reverse allowing the rest",
+			geoip: v1alpha2.GeoIpConfigT{Countries: []string{"ES"}, Reverse: true},
+			ip:    "198.51.100.200",
+		},
+		{
+			name:  "reverse allowing unknown countries",
+			geoip: v1alpha2.GeoIpConfigT{Countries: []string{"ES"}, Reverse: true},
+			ip:    "203.0.113.1",
+		},
+		{
+			name:       "invalid ip",
+			geoip:      v1alpha2.GeoIpConfigT{Countries: []string{"ES"}},
+			ip:         "192.0.2",
+			wantReason: reasons.InvalidParam,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.geoip.Databases = []string{countries, asns}
+			auth, err := NewGeoIP(v1alpha2.AuthorizationConfigT{
+				Type:  config.ConfigAuthTypeGEOIP,
+				Param: v1alpha2.AuthParamConfigT{Type: config.ConfigAuthParamTypeHEADER, Name: "x-client-ip"},
+				GeoIp: tt.geoip,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error creating authorization: %v", err)
+			}
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("x-client-ip", tt.ip)
+
+			err = auth.Check(r)
+			if tt.wantReason == "" {
+				if err != nil {
+					t.Errorf("Check() error = %v, want none", err)
+				}
+				return
+			}
+
+			if reason := reasons.Get(err); reason != tt.wantReason {
+				t.Errorf("Check() error = %v with reason '%s', want reason '%s'", err, reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestGeoIPReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	writeGeoIPDatabase(t, path, map[string]mmdbtype.Map{"192.0.2.0/24": geoRecord("ES", "EU", 0)})
+
+	auth, err := NewGeoIP(v1alpha2.AuthorizationConfigT{
+		Type:  config.ConfigAuthTypeGEOIP,
+		Param: v1alpha2.AuthParamConfigT{Type: config.ConfigAuthParamTypeHEADER, Name: "x-client-ip"},
+		GeoIp: v1alpha2.GeoIpConfigT{Databases: []string{path}, Countries: []string{"PT"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating authorization: %v", err)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("x-client-ip", "192.0.2.1")
+
+	if err = auth.Check(r); err == nil {
+		t.Fatalf("Check() error = nil, want the country denied")
+	}
+
+	if reloaded, err := auth.Reload(); reloaded || err != nil {
+		t.Errorf("Reload() = (%v, %v), want nothing reloaded without changes", reloaded, err)
+	}
+
+	// invalid databases keep the current ones
+	if err = os.WriteFile(path, []byte("not a database"), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	touch(t, path, time.Minute)
+
+	if reloaded, err := auth.Reload(); reloaded || err == nil {
+		t.Errorf("Reload() = (%v, %v), want the invalid database reported", reloaded, err)
+	}
+
+	if err = auth.Check(r); reasons.Get(err) != reasons.GeoNotAllowed {
+		t.Errorf("Check() error = %v, want the current database still used", err)
+	}
+
+	// updated databases replace the ones in use
+	writeGeoIPDatabase(t, path, map[string]mmdbtype.Map{"192.0.2.0/24": geoRecord("PT", "EU", 0)})
+	touch(t, path, 2*time.Minute)
+
+	if reloaded, err := auth.Reload(); !reloaded || err != nil {
+		t.Fatalf("Reload() = (%v, %v), want the database reloaded", reloaded, err)
+	}
+
+	if err = auth.Check(r); err != nil {
+		t.Errorf("Check() error = %v, want the country allowed by the new database", err)
+	}
+}
+
+// touch moves the modification time of the file forward, as writes in the same instant may keep it
+func touch(t *testing.T, path string, offset time.Duration) {
+	t.Helper()
+
+	at := time.Now().Add(offset)
+	if err := os.Chtimes(path, at, at); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
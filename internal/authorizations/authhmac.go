@@ -2,8 +2,11 @@ package authorizations
 
 import (
 	"doorkeeper/api/v1alpha2"
+	"doorkeeper/internal/clientip"
 	"doorkeeper/internal/config"
 	"doorkeeper/internal/hmac"
+	"doorkeeper/internal/identity"
+	"doorkeeper/internal/reasons"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -16,14 +19,10 @@ var (
 )
 
 type HmacT struct {
-	paramType string
-	paramName string
+	param paramT
 
-	hmacType            string
-	hmacMandatoryFields []string
-
-	hmacEncryptionKey       string
-	hmacEncryptionAlgorithm string
+	hmacType        string
+	hmacTokenConfig hmac.TokenConfigT
 
 	hmacUrlFrom        string
 	hmacUrlName        string
@@ -33,33 +32,56 @@ type HmacT struct {
 
 func NewHmac(cfg v1alpha2.AuthorizationConfigT) (h *HmacT, err error) {
 	h = &HmacT{
-		paramType: cfg.Param.Type,
-		paramName: cfg.Param.Name,
-
-		hmacType:            cfg.Hmac.Type,
-		hmacMandatoryFields: cfg.Hmac.MandatoryFields,
-
-		hmacEncryptionKey:       cfg.Hmac.EncryptionKey,
-		hmacEncryptionAlgorithm: cfg.Hmac.EncryptionAlgorithm,
+		hmacType: cfg.Hmac.Type,
+		hmacTokenConfig: hmac.TokenConfigT{
+			EncryptionAlgorithm: cfg.Hmac.EncryptionAlgorithm,
+			MandatoryFields:     cfg.Hmac.MandatoryFields,
+			Compatibility:       cfg.Hmac.Compatibility,
+			Salt:                cfg.Hmac.Salt,
+			Format: hmac.TokenFormatT{
+				FieldSeparator: cfg.Hmac.Token.FieldSeparator,
+				ValueSeparator: cfg.Hmac.Token.ValueSeparator,
+				AclSeparator:   cfg.Hmac.Token.AclSeparator,
+				HmacField:      cfg.Hmac.Token.HmacField,
+				ExpField:       cfg.Hmac.Token.ExpField,
+				StField:        cfg.Hmac.Token.StField,
+				IpField:        cfg.Hmac.Token.IpField,
+				AclField:       cfg.Hmac.Token.AclField,
+				UrlField:       cfg.Hmac.Token.UrlField,
+				IdField:        cfg.Hmac.Token.IdField,
+				DigestEncoding: cfg.Hmac.Token.DigestEncoding,
+				DigestLength:   cfg.Hmac.Token.DigestLength,
+			},
+		},
 
 		hmacUrlFrom:        cfg.Hmac.Url.From,
 		hmacUrlName:        cfg.Hmac.Url.Name,
 		hmacUrlEarlyEncode: cfg.Hmac.Url.EarlyEncode,
 		hmacUrlLowerEncode: cfg.Hmac.Url.LowerEncode,
 	}
+
+	h.hmacTokenConfig.EncryptionKey, err = hmac.DecodeKey(cfg.Hmac.EncryptionKey, cfg.Hmac.KeyEncoding, cfg.Hmac.EncryptionAlgorithm)
+	if err != nil {
+		return h, err
+	}
+
+	h.param, err = newParam(cfg.Param)
+
+	// akamai tokens escaped early are signed with the escaped values they are sent with
+	h.param.rawQuery = h.hmacTokenConfig.Compatibility == hmac.CompatibilityAKAMAI
 	return h, err
 }
 
+// PathToken returns where the token is in the path, when it is taken from it
+func (a *HmacT) PathToken(path string) (start, end int, found bool) {
+	return a.param.pathBounds(path)
+}
+
 func (a *HmacT) Check(r *http.Request) (err error) {
 	// get params
 
-	paramToCheck := r.URL.Query().Get(a.paramName)
-	if a.paramType == config.ConfigAuthParamTypeHEADER {
-		paramToCheck = r.Header.Get(a.paramName)
-	}
-
-	if paramToCheck == "" {
-		err = fmt.Errorf("empty %s param '%s' in request", a.paramType, a.paramName)
+	paramToCheck, err := a.param.get(r)
+	if err != nil {
 		return err
 	}
 
@@ -80,30 +102,52 @@ func (a *HmacT) Check(r *http.Request) (err error) {
 }
 
 func (a *HmacT) checkUrlType(r *http.Request, paramToCheck string) (err error) {
-	urlValue := strings.Split(r.URL.Path, "?")[0]
+	// tokens carried in the path are not part of the url they sign
+	urlValue := a.param.pathWithout(strings.Split(r.URL.Path, "?")[0])
 	if a.hmacUrlFrom == config.ConfigAuthHmacUrlFromHEADER {
 		urlValue = r.Header.Get(a.hmacUrlName)
 	}
 
 	if urlValue == "" {
-		return fmt.Errorf("unable to get url value { from: '%s', name: '%s' }", a.hmacUrlFrom, a.hmacUrlName)
+		return reasons.Errorf(reasons.MissingParam, "unable to get url value { from: '%s', name: '%s' }", a.hmacUrlFrom, a.hmacUrlName)
 	}
 
-	if a.hmacUrlEarlyEncode {
-		urlValue = url.PathEscape(urlValue)
+	// paths are checked against the acl of the tokens before encoding them
+	pathValue := strings.Split(urlValue, "?")[0]
 
-		if a.hmacUrlLowerEncode {
-			urlValue = urlEncodeRegex.ReplaceAllStringFunc(urlValue, func(match string) string {
-				return strings.ToLower(match)
-			})
+	switch {
+	case a.hmacUrlEarlyEncode && a.hmacTokenConfig.Compatibility == hmac.CompatibilityAKAMAI:
+		{
+			urlValue = hmac.EscapeAkamai(urlValue)
+		}
+	case a.hmacUrlEarlyEncode:
+		{
+			urlValue = url.PathEscape(urlValue)
+
+			if a.hmacUrlLowerEncode {
+				urlValue = urlEncodeRegex.ReplaceAllStringFunc(urlValue, func(match string) string {
+					return strings.ToLower(match)
+				})
+			}
 		}
 	}
 
+	// the ip is only needed by tokens bound to it
+	clientIp, _ := clientip.FromRequest(r)
+
 	//
 	var generatedHmac, receivedHmac string
-	generatedHmac, receivedHmac, err = hmac.ValidateTokenUrl(paramToCheck, a.hmacEncryptionKey, a.hmacEncryptionAlgorithm, urlValue, a.hmacMandatoryFields)
+	generatedHmac, receivedHmac, err = hmac.ValidateTokenUrl(paramToCheck, a.hmacTokenConfig, hmac.TokenRequestT{
+		Url:      urlValue,
+		Path:     pathValue,
+		ClientIp: clientIp,
+	})
 	_ = generatedHmac
 	_ = receivedHmac
 
+	if err == nil {
+		identity.Set(r, hmac.TokenId(paramToCheck, a.hmacTokenConfig))
+	}
+
 	return err
 }
@@ -2,101 +2,210 @@ package authorizations
 
 import (
 	"doorkeeper/api/v1alpha2"
+	"doorkeeper/internal/clientip"
 	"doorkeeper/internal/config"
+	"doorkeeper/internal/iplist"
+	"doorkeeper/internal/iptrie"
+	"doorkeeper/internal/reasons"
+	"doorkeeper/internal/utils"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"strings"
+	"sync/atomic"
 )
 
 type IPListT struct {
-	paramType string
-	paramName string
+	param paramT
 
 	separator               string
 	reverse                 bool
-	cidrCompiled            *net.IPNet
 	trustedNetworksCompiled []*net.IPNet
+
+	// networks from every source are kept to build
+	// the trie again when any of them changes
+	cidrsCompiled  []*net.IPNet
+	cidrFiles      []string
+	cidrFilesState []utils.FileStateT
+	filesNetworks  []*net.IPNet
+	cidrUrls       []*iplist.RemoteT
+	urlsVersions   []uint64
+	cidrsTrie      atomic.Pointer[iptrie.TrieT]
 }
 
 func NewIPList(cfg v1alpha2.AuthorizationConfigT) (i *IPListT, err error) {
 	i = &IPListT{
-		paramType: cfg.Param.Type,
-		paramName: cfg.Param.Name,
 		separator: cfg.IpList.Separator,
 		reverse:   cfg.IpList.Reverse,
 	}
-	_, i.cidrCompiled, err = net.ParseCIDR(cfg.IpList.Cidr)
+	if i.separator == "" {
+		i.separator = ","
+	}
+
+	i.param, err = newParam(cfg.Param)
+	if err != nil {
+		return i, err
+	}
+
+	i.trustedNetworksCompiled, err = clientip.ParseNetworks(cfg.IpList.TrustedNetworks)
+	if err != nil {
+		return i, err
+	}
+
+	cidrs := cfg.IpList.Cidrs
+	if cfg.IpList.Cidr != "" {
+		cidrs = append([]string{cfg.IpList.Cidr}, cidrs...)
+	}
+
+	i.cidrsCompiled, err = clientip.ParseNetworks(cidrs)
+	if err != nil {
+		return i, err
+	}
+
+	i.cidrFiles = cfg.IpList.CidrFiles
+	err = i.loadCidrFiles()
 	if err != nil {
 		return i, err
 	}
 
-	for _, tnv := range cfg.IpList.TrustedNetworks {
-		var cidr *net.IPNet
-		_, cidr, err = net.ParseCIDR(tnv)
+	for _, urlv := range cfg.IpList.CidrUrls {
+		var remote *iplist.RemoteT
+		remote, err = iplist.GetRemote(urlv)
 		if err != nil {
 			return i, err
 		}
-		i.trustedNetworksCompiled = append(i.trustedNetworksCompiled, cidr)
+		i.cidrUrls = append(i.cidrUrls, remote)
 	}
 
+	i.buildTrie()
 	return i, err
 }
 
-func (a *IPListT) Check(r *http.Request) (err error) {
-	// get params
+// loadCidrFiles reads the networks in the cidr files
+func (a *IPListT) loadCidrFiles() (err error) {
+	// state is taken before reading, so changes while reading are detected later
+	state, err := utils.GetFilesState(a.cidrFiles)
+	if err != nil {
+		return err
+	}
 
-	paramToCheck := r.URL.Query().Get(a.paramName)
-	if a.paramType == config.ConfigAuthParamTypeHEADER {
-		paramToCheck = r.Header.Get(a.paramName)
+	networks := []*net.IPNet{}
+	for _, filev := range a.cidrFiles {
+		var fileNetworks []*net.IPNet
+		fileNetworks, err = iplist.ReadFile(filev)
+		if err != nil {
+			return err
+		}
+		networks = append(networks, fileNetworks...)
 	}
 
-	if paramToCheck == "" {
-		err = fmt.Errorf("empty %s param '%s' in request", a.paramType, a.paramName)
-		return err
+	a.filesNetworks = networks
+	a.cidrFilesState = state
+
+	return err
+}
+
+// buildTrie replaces the trie with a new one containing the networks of all the sources
+func (a *IPListT) buildTrie() {
+	trie := iptrie.New()
+	for _, cidrv := range a.cidrsCompiled {
+		trie.Insert(cidrv)
 	}
 
-	// check
+	for _, cidrv := range a.filesNetworks {
+		trie.Insert(cidrv)
+	}
+
+	a.urlsVersions = make([]uint64, len(a.cidrUrls))
+	for remotei, remotev := range a.cidrUrls {
+		var networks []*net.IPNet
+		networks, a.urlsVersions[remotei] = remotev.Networks()
+		for _, cidrv := range networks {
+			trie.Insert(cidrv)
+		}
+	}
 
-	iplist := strings.Split(paramToCheck, a.separator)
+	a.cidrsTrie.Store(trie)
+}
 
-	// filter trusted networks
-	filteredIpList := []net.IP{}
-	for _, ipv := range iplist {
-		trimipv := strings.TrimSpace(ipv)
-		currentIP := net.ParseIP(trimipv)
-		if currentIP == nil {
-			err = fmt.Errorf("invalid ip '%s' in list recieved", trimipv)
-			return err
+// Reload loads the cidr files again when they change, and fetches the cidr urls
+// when their refresh interval passed. Sources failing keep their last good networks,
+// so their errors are only reported
+func (a *IPListT) Reload() (reloaded bool, err error) {
+	errs := []error{}
+
+	if len(a.cidrFiles) > 0 {
+		changed, err := utils.FilesChanged(a.cidrFilesState)
+		if err == nil && changed {
+			err = a.loadCidrFiles()
+			reloaded = err == nil
 		}
 
-		found := false
-		for _, tnv := range a.trustedNetworksCompiled {
-			if tnv.Contains(currentIP) {
-				found = true
-				break
-			}
+		if err != nil {
+			errs = append(errs, err)
 		}
+	}
 
-		if !found {
-			filteredIpList = append(filteredIpList, currentIP)
+	// remotes are shared, so they may have been refreshed by other authorizations
+	for remotei, remotev := range a.cidrUrls {
+		_, err := remotev.Refresh(false)
+		if err != nil {
+			errs = append(errs, err)
 		}
+
+		_, version := remotev.Networks()
+		reloaded = reloaded || version != a.urlsVersions[remotei]
 	}
 
-	// check filtered ip list
+	if reloaded {
+		a.buildTrie()
+	}
 
-	if len(filteredIpList) != 1 {
-		err = fmt.Errorf("to mutch ips in list after filter trusted networks %v", filteredIpList)
+	return reloaded, errors.Join(errs...)
+}
+
+// Close releases the remotes of the cidr urls, so they are dropped when no other authorization uses them
+func (a *IPListT) Close() {
+	for _, remotev := range a.cidrUrls {
+		remotev.Release()
+	}
+	a.cidrUrls = nil
+}
+
+func (a *IPListT) Check(r *http.Request) (err error) {
+	// get params
+
+	paramToCheck, err := a.param.get(r)
+	if err != nil {
+		return err
+	}
+
+	// check
+
+	// the client ip is already resolved, otherwise the right-most
+	// ip not included in trusted networks is the one of the client
+	var ip net.IP
+	if a.param.paramType == config.ConfigAuthParamTypeCLIENTIP {
+		ip = net.ParseIP(paramToCheck)
+		if ip == nil {
+			err = fmt.Errorf("invalid ip '%s'", paramToCheck)
+		}
+	} else {
+		ip, err = clientip.RightmostUntrusted(strings.Split(paramToCheck, a.separator), a.trustedNetworksCompiled, 0)
+	}
+	if err != nil {
+		err = reasons.Errorf(reasons.InvalidParam, "invalid ip list recieved: %s", err.Error())
 		return err
 	}
 
-	valid := a.cidrCompiled.Contains(filteredIpList[0])
+	valid := a.cidrsTrie.Load().Contains(ip)
 	if a.reverse {
 		valid = !valid
 	}
 
 	if !valid {
-		err = fmt.Errorf("invalid ip in request")
+		err = reasons.Errorf(reasons.IpNotAllowed, "invalid ip in request")
 	}
 
 	return err
@@ -3,54 +3,150 @@ package authorizations
 import (
 	"doorkeeper/api/v1alpha2"
 	"doorkeeper/internal/config"
-	"fmt"
+	"doorkeeper/internal/reasons"
 	"net/http"
 	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 )
 
 type MatchT struct {
-	paramType string
-	paramName string
+	param paramT
 
 	reverse       bool
 	compiledRegex *regexp.Regexp
+
+	conditions []matchConditionT
+}
+
+type matchConditionT struct {
+	param    paramT
+	operator string
+	reverse  bool
+
+	value         string
+	values        []string
+	min           *float64
+	max           *float64
+	compiledRegex *regexp.Regexp
 }
 
 func NewMatch(cfg v1alpha2.AuthorizationConfigT) (h *MatchT, err error) {
 	h = &MatchT{
-		paramType: cfg.Param.Type,
-		paramName: cfg.Param.Name,
-		reverse:   cfg.Match.Reverse,
+		reverse: cfg.Match.Reverse,
+	}
+
+	if cfg.Match.Pattern != "" {
+		h.param, err = newParam(cfg.Param)
+		if err != nil {
+			return h, err
+		}
+
+		h.compiledRegex, err = regexp.Compile(cfg.Match.Pattern)
+		if err != nil {
+			return h, err
+		}
 	}
 
-	h.compiledRegex = regexp.MustCompile(cfg.Match.Pattern)
+	for _, condv := range cfg.Match.Conditions {
+		condition := matchConditionT{
+			operator: condv.Operator,
+			reverse:  condv.Reverse,
+			value:    condv.Value,
+			values:   condv.Values,
+			min:      condv.Min,
+			max:      condv.Max,
+		}
+
+		condition.param, err = newParam(v1alpha2.AuthParamConfigT{Type: condv.Source, Name: condv.Name})
+		if err != nil {
+			return h, err
+		}
+
+		if condition.operator == config.ConfigAuthMatchOperatorREGEX {
+			condition.compiledRegex, err = regexp.Compile(condv.Value)
+			if err != nil {
+				return h, err
+			}
+		}
+
+		h.conditions = append(h.conditions, condition)
+	}
 
 	return h, err
 }
 
 func (a *MatchT) Check(r *http.Request) (err error) {
-	// get params
+	valid := true
 
-	paramToCheck := r.URL.Query().Get(a.paramName)
-	if a.paramType == config.ConfigAuthParamTypeHEADER {
-		paramToCheck = r.Header.Get(a.paramName)
-	}
+	// params are required by the pattern, so missing ones are errors
+	if a.compiledRegex != nil {
+		paramToCheck, err := a.param.get(r)
+		if err != nil {
+			return err
+		}
 
-	if paramToCheck == "" {
-		err = fmt.Errorf("empty %s param '%s' in request", a.paramType, a.paramName)
-		return err
+		valid = a.compiledRegex.MatchString(paramToCheck)
 	}
 
-	// check
+	for _, condv := range a.conditions {
+		if !valid {
+			break
+		}
+
+		valid, err = condv.matches(r)
+		if err != nil {
+			return err
+		}
+	}
 
-	valid := a.compiledRegex.MatchString(paramToCheck)
 	if a.reverse {
 		valid = !valid
 	}
 
 	if !valid {
-		err = fmt.Errorf("invalid match in request")
+		err = reasons.Errorf(reasons.NoMatch, "invalid match in request")
 	}
 
 	return err
 }
+
+// matches returns whether the condition is satisfied by the request.
+// Missing values only satisfy the ABSENT operator
+func (c *matchConditionT) matches(r *http.Request) (result bool, err error) {
+	value, found, err := c.param.lookup(r)
+	if err != nil {
+		return false, err
+	}
+
+	switch c.operator {
+	case config.ConfigAuthMatchOperatorPRESENT:
+		result = found
+	case config.ConfigAuthMatchOperatorABSENT:
+		result = !found
+	case config.ConfigAuthMatchOperatorREGEX:
+		result = found && c.compiledRegex.MatchString(value)
+	case config.ConfigAuthMatchOperatorEXACT:
+		result = found && value == c.value
+	case config.ConfigAuthMatchOperatorPREFIX:
+		result = found && strings.HasPrefix(value, c.value)
+	case config.ConfigAuthMatchOperatorSUFFIX:
+		result = found && strings.HasSuffix(value, c.value)
+	case config.ConfigAuthMatchOperatorONEOF:
+		result = found && slices.Contains(c.values, value)
+	case config.ConfigAuthMatchOperatorRANGE:
+		{
+			number, parseErr := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			result = found && parseErr == nil &&
+				(c.min == nil || number >= *c.min) &&
+				(c.max == nil || number <= *c.max)
+		}
+	}
+
+	if c.reverse {
+		result = !result
+	}
+
+	return result, err
+}
@@ -0,0 +1,37 @@
+package authorizations
+
+import (
+	"doorkeeper/api/v1alpha2"
+	"doorkeeper/internal/clock"
+	"doorkeeper/internal/reasons"
+	"doorkeeper/internal/schedule"
+	"net/http"
+)
+
+type ScheduleT struct {
+	reverse  bool
+	schedule *schedule.ScheduleT
+}
+
+func NewSchedule(cfg v1alpha2.AuthorizationConfigT) (s *ScheduleT, err error) {
+	s = &ScheduleT{
+		reverse: cfg.Schedule.Reverse,
+	}
+
+	s.schedule, err = schedule.NewSchedule(cfg.Schedule)
+
+	return s, err
+}
+
+func (a *ScheduleT) Check(r *http.Request) (err error) {
+	valid := a.schedule.Contains(clock.Now())
+	if a.reverse {
+		valid = !valid
+	}
+
+	if !valid {
+		err = reasons.Errorf(reasons.OutOfSchedule, "request out of schedule")
+	}
+
+	return err
+}
@@ -0,0 +1,136 @@
+package authorizations
+
+import (
+	"doorkeeper/api/v1alpha2"
+	"doorkeeper/internal/clientip"
+	"doorkeeper/internal/clock"
+	"doorkeeper/internal/identity"
+	"doorkeeper/internal/reasons"
+	"doorkeeper/internal/signedcookie"
+	"net/http"
+	"strconv"
+)
+
+const (
+	headerForwardedProto = "X-Forwarded-Proto"
+)
+
+type SignedCookieT struct {
+	verifier *signedcookie.VerifierT
+
+	policyCookie    string
+	signatureCookie string
+	keyPairIdCookie string
+	expiresCookie   string
+}
+
+func NewSignedCookie(cfg v1alpha2.AuthorizationConfigT) (s *SignedCookieT, err error) {
+	s = &SignedCookieT{
+		policyCookie:    cfg.SignedCookie.Cookies.Policy,
+		signatureCookie: cfg.SignedCookie.Cookies.Signature,
+		keyPairIdCookie: cfg.SignedCookie.Cookies.KeyPairId,
+		expiresCookie:   cfg.SignedCookie.Cookies.Expires,
+	}
+
+	if s.policyCookie == "" {
+		s.policyCookie = signedcookie.DefaultPolicyCookie
+	}
+
+	if s.signatureCookie == "" {
+		s.signatureCookie = signedcookie.DefaultSignatureCookie
+	}
+
+	if s.keyPairIdCookie == "" {
+		s.keyPairIdCookie = signedcookie.DefaultKeyPairIdCookie
+	}
+
+	if s.expiresCookie == "" {
+		s.expiresCookie = signedcookie.DefaultExpiresCookie
+	}
+
+	s.verifier, err = signedcookie.NewVerifier(cfg.SignedCookie)
+
+	return s, err
+}
+
+func (a *SignedCookieT) Check(r *http.Request) (err error) {
+	// get params
+
+	signatureCookie, err := r.Cookie(a.signatureCookie)
+	if err != nil {
+		return reasons.Errorf(reasons.MissingParam, "signature cookie '%s' not found in request", a.signatureCookie)
+	}
+
+	signature, err := signedcookie.DecodeBase64(signatureCookie.Value)
+	if err != nil {
+		return reasons.Errorf(reasons.InvalidParam, "invalid signature cookie: %s", err.Error())
+	}
+
+	keyPairId := ""
+	if keyPairIdCookie, err := r.Cookie(a.keyPairIdCookie); err == nil {
+		keyPairId = keyPairIdCookie.Value
+	}
+
+	resource := requestResource(r)
+
+	// custom policies are sent in a cookie, while canned ones
+	// are built from the expiration time and the requested resource
+
+	var policyContent []byte
+	if policyCookie, err := r.Cookie(a.policyCookie); err == nil {
+		policyContent, err = signedcookie.DecodeBase64(policyCookie.Value)
+		if err != nil {
+			return reasons.Errorf(reasons.InvalidParam, "invalid policy cookie: %s", err.Error())
+		}
+	} else {
+		expiresCookie, err := r.Cookie(a.expiresCookie)
+		if err != nil {
+			return reasons.Errorf(reasons.MissingParam, "policy cookie '%s' nor expires cookie '%s' found in request", a.policyCookie, a.expiresCookie)
+		}
+
+		expires, err := strconv.ParseInt(expiresCookie.Value, 10, 64)
+		if err != nil {
+			return reasons.Errorf(reasons.InvalidParam, "invalid expires cookie '%s'", expiresCookie.Value)
+		}
+
+		policyContent = signedcookie.CannedPolicy(resource, expires)
+	}
+
+	// check
+
+	err = a.verifier.Verify(policyContent, signature, keyPairId)
+	if err != nil {
+		return err
+	}
+
+	policy, err := signedcookie.ParsePolicy(policyContent)
+	if err != nil {
+		return err
+	}
+
+	// the ip is only needed by policies restricting it
+	ip, _ := clientip.FromRequest(r)
+
+	err = policy.Allows(resource, ip, clock.Now())
+	if err == nil && keyPairId != "" {
+		identity.Set(r, keyPairId)
+	}
+
+	return err
+}
+
+// requestResource returns the URL requested, as written in the resources of the policies.
+// The scheme is taken from the proxy in front, defaulting to https
+func requestResource(r *http.Request) string {
+	scheme := r.Header.Get(headerForwardedProto)
+	if scheme == "" {
+		scheme = "https"
+	}
+
+	resource := scheme + "://" + r.Host + r.URL.Path
+	if r.URL.RawQuery != "" {
+		resource += "?" + r.URL.RawQuery
+	}
+
+	return resource
+}
@@ -0,0 +1,259 @@
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"doorkeeper/api/v1alpha2"
+)
+
+const (
+	SourceXFF                   = "XFF"
+	SourceFORWARDED             = "FORWARDED"
+	SourceXREALIP               = "X_REAL_IP"
+	SourceXENVOYEXTERNALADDRESS = "X_ENVOY_EXTERNAL_ADDRESS"
+	SourceREMOTEADDR            = "REMOTE_ADDR"
+
+	headerXFF                   = "X-Forwarded-For"
+	headerForwarded             = "Forwarded"
+	headerXRealIP               = "X-Real-Ip"
+	headerXEnvoyExternalAddress = "X-Envoy-External-Address"
+)
+
+var (
+	Sources = []string{
+		SourceXFF,
+		SourceFORWARDED,
+		SourceXREALIP,
+		SourceXENVOYEXTERNALADDRESS,
+		SourceREMOTEADDR,
+	}
+
+	DefaultSources = []string{SourceXFF, SourceREMOTEADDR}
+)
+
+type contextKeyT struct{}
+
+type resultT struct {
+	ip  net.IP
+	err error
+}
+
+// ResolverT gets the IP of the client that originated a request, looking into the
+// configured sources in order until one of them provides it
+type ResolverT struct {
+	sources         []string
+	trustedNetworks []*net.IPNet
+	trustedHops     int
+}
+
+func NewResolver(cfg v1alpha2.ClientIpConfigT) (r *ResolverT, err error) {
+	r = &ResolverT{
+		sources:     cfg.Sources,
+		trustedHops: cfg.TrustedHops,
+	}
+
+	if len(r.sources) == 0 {
+		r.sources = DefaultSources
+	}
+
+	r.trustedNetworks, err = ParseNetworks(cfg.TrustedNetworks)
+	return r, err
+}
+
+// ParseNetworks compiles a list of CIDRs. Single IPs are accepted too
+func ParseNetworks(cidrs []string) (networks []*net.IPNet, err error) {
+	for _, cidrv := range cidrs {
+		cidrv = strings.TrimSpace(cidrv)
+		if !strings.Contains(cidrv, "/") {
+			if ip := net.ParseIP(cidrv); ip != nil {
+				bits := 8 * net.IPv6len
+				if ip.To4() != nil {
+					ip = ip.To4()
+					bits = 8 * net.IPv4len
+				}
+				networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+		}
+
+		var network *net.IPNet
+		_, network, err = net.ParseCIDR(cidrv)
+		if err != nil {
+			return networks, err
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, err
+}
+
+// Resolve returns the IP of the client from the first source providing it
+func (r *ResolverT) Resolve(req *http.Request) (ip net.IP, err error) {
+	for _, sourcev := range r.sources {
+		switch sourcev {
+		case SourceXFF:
+			{
+				ip, err = r.fromList(splitHeaderList(req.Header.Values(headerXFF)))
+			}
+		case SourceFORWARDED:
+			{
+				ip, err = r.fromList(parseForwarded(req.Header.Values(headerForwarded)))
+			}
+		case SourceXREALIP:
+			{
+				ip, err = parseIP(req.Header.Get(headerXRealIP))
+			}
+		case SourceXENVOYEXTERNALADDRESS:
+			{
+				ip, err = parseIP(req.Header.Get(headerXEnvoyExternalAddress))
+			}
+		case SourceREMOTEADDR:
+			{
+				ip, err = parseIP(req.RemoteAddr)
+			}
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid client ip in %s: %s", sourcev, err.Error())
+		}
+
+		if ip != nil {
+			return ip, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unable to get client ip from any of the sources %v", r.sources)
+}
+
+func (r *ResolverT) fromList(list []string) (net.IP, error) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+	return RightmostUntrusted(list, r.trustedNetworks, r.trustedHops)
+}
+
+// RightmostUntrusted walks a list of IPs added by proxies (as the one in X-Forwarded-For)
+// from the right, skipping first the given number of hops and then the IPs in trusted networks.
+// The first IP not skipped is the one of the client. When every IP is skipped, the client is
+// unknown, as the left-most entries can be set by anyone, so an error is returned.
+// Obfuscated nodes of Forwarded headers ('unknown' or '_hidden') are never trusted
+func RightmostUntrusted(list []string, trustedNetworks []*net.IPNet, trustedHops int) (ip net.IP, err error) {
+	for i := len(list) - 1; i >= 0; i-- {
+		hop := len(list) - 1 - i
+
+		if isObfuscated(list[i]) {
+			if hop < trustedHops {
+				continue
+			}
+			return nil, fmt.Errorf("client ip is obfuscated as '%s'", list[i])
+		}
+
+		ip, err = parseIP(list[i])
+		if err != nil {
+			return nil, err
+		}
+
+		if ip == nil {
+			return nil, fmt.Errorf("empty ip in list")
+		}
+
+		if hop < trustedHops {
+			continue
+		}
+
+		if !IsTrusted(ip, trustedNetworks) {
+			return ip, nil
+		}
+	}
+
+	if len(list) <= trustedHops {
+		return nil, fmt.Errorf("only %d ips in list, expected more than %d trusted hops", len(list), trustedHops)
+	}
+
+	return nil, fmt.Errorf("every ip in list is trusted")
+}
+
+// IsTrusted returns whether the IP is inside any of the networks
+func IsTrusted(ip net.IP, trustedNetworks []*net.IPNet) bool {
+	for _, tnv := range trustedNetworks {
+		if tnv.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isObfuscated returns whether the node is an unknown or obfuscated identifier
+// of the Forwarded header (RFC 7239, section 6), instead of an IP
+func isObfuscated(node string) bool {
+	node = strings.TrimSpace(node)
+	return strings.EqualFold(node, "unknown") || strings.HasPrefix(node, "_")
+}
+
+// parseIP parses an IP, accepting ports and IPv6 brackets (e.g. '[2001:db8::1]:8080').
+// Empty values return nil without error
+func parseIP(value string) (ip net.IP, err error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	if host, _, splitErr := net.SplitHostPort(value); splitErr == nil {
+		value = host
+	}
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+
+	ip = net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid ip '%s'", value)
+	}
+
+	return ip, nil
+}
+
+// splitHeaderList joins the values of a header that can be repeated and splits them by commas
+func splitHeaderList(values []string) (list []string) {
+	for _, valuev := range values {
+		for _, itemv := range strings.Split(valuev, ",") {
+			if itemv = strings.TrimSpace(itemv); itemv != "" {
+				list = append(list, itemv)
+			}
+		}
+	}
+	return list
+}
+
+// parseForwarded returns the 'for' parameters of the elements in Forwarded headers (RFC 7239),
+// e.g. 'for=192.0.2.60;proto=http, for="[2001:db8:cafe::17]:4711"'
+func parseForwarded(values []string) (list []string) {
+	for _, elementv := range splitHeaderList(values) {
+		for _, pairv := range strings.Split(elementv, ";") {
+			key, value, found := strings.Cut(strings.TrimSpace(pairv), "=")
+			if !found || !strings.EqualFold(key, "for") {
+				continue
+			}
+
+			list = append(list, strings.Trim(value, `"`))
+		}
+	}
+	return list
+}
+
+// NewContext returns a copy of the request with the result of resolving its client IP
+func NewContext(req *http.Request, ip net.IP, err error) *http.Request {
+	ctx := context.WithValue(req.Context(), contextKeyT{}, resultT{ip: ip, err: err})
+	return req.WithContext(ctx)
+}
+
+// FromRequest returns the client IP resolved for the request
+func FromRequest(req *http.Request) (net.IP, error) {
+	result, ok := req.Context().Value(contextKeyT{}).(resultT)
+	if !ok {
+		return nil, fmt.Errorf("client ip not resolved for the request")
+	}
+	return result.ip, result.err
+}
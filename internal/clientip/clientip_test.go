@@ -0,0 +1,266 @@
+package clientip
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"doorkeeper/api/v1alpha2"
+)
+
+func TestResolve(t *testing.T) {
+	trusted := []string{"10.0.0.0/8", "2001:db8:ffff::/48"}
+
+	tests := []struct {
+		name       string
+		cfg        v1alpha2.ClientIpConfigT
+		headers    map[string][]string
+		remoteAddr string
+		want       string
+		wantErr    bool
+	}{
+		// X-Forwarded-For
+		{
+			name:    "xff single ip",
+			headers: map[string][]string{"X-Forwarded-For": {"203.0.113.7"}},
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "xff right-most without trusted networks",
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.1, 203.0.113.7"}},
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "xff skipping trusted networks",
+			cfg:     v1alpha2.ClientIpConfigT{TrustedNetworks: trusted},
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.1, 203.0.113.7, 10.0.0.2, 10.0.0.1"}},
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "xff in repeated headers",
+			cfg:     v1alpha2.ClientIpConfigT{TrustedNetworks: trusted},
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.1, 203.0.113.7", "10.0.0.1"}},
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "xff skipping hops",
+			cfg:     v1alpha2.ClientIpConfigT{TrustedHops: 2},
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.1, 203.0.113.7, 192.0.2.2, 192.0.2.1"}},
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "xff skipping hops and then trusted networks",
+			cfg:     v1alpha2.ClientIpConfigT{TrustedHops: 1, TrustedNetworks: trusted},
+			headers: map[string][]string{"X-Forwarded-For": {"203.0.113.7, 10.0.0.2, 192.0.2.1"}},
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "xff with spoofed left-most entries",
+			cfg:     v1alpha2.ClientIpConfigT{TrustedNetworks: trusted},
+			headers: map[string][]string{"X-Forwarded-For": {"10.9.9.9, 203.0.113.7, 10.0.0.1"}},
+			want:    "203.0.113.7",
+		},
+		{
+			name:       "xff fewer entries than hops",
+			cfg:        v1alpha2.ClientIpConfigT{TrustedHops: 2},
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.7"}},
+			remoteAddr: "192.0.2.1:1234",
+			wantErr:    true,
+		},
+		{
+			name:       "xff with every entry trusted",
+			cfg:        v1alpha2.ClientIpConfigT{TrustedNetworks: trusted},
+			headers:    map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}},
+			remoteAddr: "10.0.0.1:1234",
+			wantErr:    true,
+		},
+		{
+			name:    "xff invalid entry",
+			headers: map[string][]string{"X-Forwarded-For": {"203.0.113.7, not-an-ip"}},
+			wantErr: true,
+		},
+		{
+			name:    "xff ipv6 with brackets and port",
+			headers: map[string][]string{"X-Forwarded-For": {"[2001:db8::7]:4711"}},
+			want:    "2001:db8::7",
+		},
+		{
+			name:    "xff ipv6 skipping trusted networks",
+			cfg:     v1alpha2.ClientIpConfigT{TrustedNetworks: trusted},
+			headers: map[string][]string{"X-Forwarded-For": {"2001:db8::7, 2001:db8:ffff::1"}},
+			want:    "2001:db8::7",
+		},
+		{
+			name:    "xff ipv4 with port",
+			headers: map[string][]string{"X-Forwarded-For": {"203.0.113.7:8080"}},
+			want:    "203.0.113.7",
+		},
+
+		// Forwarded
+		{
+			name:    "forwarded",
+			cfg:     v1alpha2.ClientIpConfigT{Sources: []string{SourceFORWARDED}},
+			headers: map[string][]string{"Forwarded": {"for=203.0.113.7;proto=https;by=10.0.0.1"}},
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "forwarded quoted ipv6 with port",
+			cfg:     v1alpha2.ClientIpConfigT{Sources: []string{SourceFORWARDED}},
+			headers: map[string][]string{"Forwarded": {`for="[2001:db8:cafe::17]:4711"`}},
+			want:    "2001:db8:cafe::17",
+		},
+		{
+			name:    "forwarded several elements skipping trusted networks",
+			cfg:     v1alpha2.ClientIpConfigT{Sources: []string{SourceFORWARDED}, TrustedNetworks: trusted},
+			headers: map[string][]string{"Forwarded": {"for=198.51.100.1, for=203.0.113.7", "For=10.0.0.1;proto=http"}},
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "forwarded obfuscated trusted hop",
+			cfg:     v1alpha2.ClientIpConfigT{Sources: []string{SourceFORWARDED}, TrustedHops: 1},
+			headers: map[string][]string{"Forwarded": {"for=203.0.113.7, for=_proxy1"}},
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "forwarded unknown trusted hop",
+			cfg:     v1alpha2.ClientIpConfigT{Sources: []string{SourceFORWARDED}, TrustedHops: 1},
+			headers: map[string][]string{"Forwarded": {"for=203.0.113.7, for=unknown"}},
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "forwarded obfuscated client",
+			cfg:     v1alpha2.ClientIpConfigT{Sources: []string{SourceFORWARDED}, TrustedNetworks: trusted},
+			headers: map[string][]string{"Forwarded": {"for=198.51.100.1, for=_hidden, for=10.0.0.1"}},
+			wantErr: true,
+		},
+		{
+			name:    "forwarded unknown client",
+			cfg:     v1alpha2.ClientIpConfigT{Sources: []string{SourceFORWARDED}},
+			headers: map[string][]string{"Forwarded": {"for=unknown"}},
+			wantErr: true,
+		},
+		{
+			name:       "forwarded without for",
+			cfg:        v1alpha2.ClientIpConfigT{Sources: []string{SourceFORWARDED, SourceREMOTEADDR}},
+			headers:    map[string][]string{"Forwarded": {"proto=https;by=10.0.0.1"}},
+			remoteAddr: "192.0.2.1:1234",
+			want:       "192.0.2.1",
+		},
+
+		// single ip headers
+		{
+			name:    "x-real-ip",
+			cfg:     v1alpha2.ClientIpConfigT{Sources: []string{SourceXREALIP}},
+			headers: map[string][]string{"X-Real-Ip": {"203.0.113.7"}},
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "x-real-ip ipv6 in brackets",
+			cfg:     v1alpha2.ClientIpConfigT{Sources: []string{SourceXREALIP}},
+			headers: map[string][]string{"X-Real-Ip": {"[2001:db8::7]"}},
+			want:    "2001:db8::7",
+		},
+		{
+			name:    "x-real-ip invalid",
+			cfg:     v1alpha2.ClientIpConfigT{Sources: []string{SourceXREALIP}},
+			headers: map[string][]string{"X-Real-Ip": {"203.0.113"}},
+			wantErr: true,
+		},
+		{
+			name:    "x-envoy-external-address",
+			cfg:     v1alpha2.ClientIpConfigT{Sources: []string{SourceXENVOYEXTERNALADDRESS}},
+			headers: map[string][]string{"X-Envoy-External-Address": {"203.0.113.7"}},
+			want:    "203.0.113.7",
+		},
+
+		// remote address
+		{
+			name:       "remote addr fallback without xff",
+			remoteAddr: "192.0.2.1:1234",
+			want:       "192.0.2.1",
+		},
+		{
+			name:       "remote addr ipv6 fallback",
+			remoteAddr: "[2001:db8::1]:1234",
+			want:       "2001:db8::1",
+		},
+		{
+			name:       "sources in order",
+			cfg:        v1alpha2.ClientIpConfigT{Sources: []string{SourceXREALIP, SourceXFF, SourceREMOTEADDR}},
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.8"}},
+			remoteAddr: "192.0.2.1:1234",
+			want:       "203.0.113.8",
+		},
+		{
+			name:    "no source providing it",
+			cfg:     v1alpha2.ClientIpConfigT{Sources: []string{SourceXREALIP}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver, err := NewResolver(tt.cfg)
+			if err != nil {
+				t.Fatalf("unexpected error creating resolver: %v", err)
+			}
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for hk, hv := range tt.headers {
+				for _, valuev := range hv {
+					r.Header.Add(hk, valuev)
+				}
+			}
+
+			ip, err := resolver.Resolve(r)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Resolve() = %v, want error", ip)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Resolve() error = %v", err)
+			}
+			if ip.String() != tt.want {
+				t.Errorf("Resolve() = %v, want %s", ip, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	tests := []struct {
+		name    string
+		cidrs   []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "cidrs", cidrs: []string{"10.0.0.0/8", " 2001:db8::/32 "}, want: []string{"10.0.0.0/8", "2001:db8::/32"}},
+		{name: "single ips", cidrs: []string{"192.0.2.1", "2001:db8::1"}, want: []string{"192.0.2.1/32", "2001:db8::1/128"}},
+		{name: "host bits cleared", cidrs: []string{"10.1.2.3/8"}, want: []string{"10.0.0.0/8"}},
+		{name: "invalid", cidrs: []string{"10.0.0.0/33"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			networks, err := ParseNetworks(tt.cidrs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseNetworks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if len(networks) != len(tt.want) {
+				t.Fatalf("ParseNetworks() = %v, want %v", networks, tt.want)
+			}
+			for i, networkv := range networks {
+				if networkv.String() != tt.want[i] {
+					t.Errorf("network %d = %s, want %s", i, networkv, tt.want[i])
+				}
+			}
+		})
+	}
+}
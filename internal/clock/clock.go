@@ -0,0 +1,27 @@
+package clock
+
+import (
+	"time"
+)
+
+var (
+	now = time.Now
+)
+
+// Now returns the current time according to the configured clock
+func Now() time.Time {
+	return now()
+}
+
+// SetFixed freezes the clock at the given time. Useful to evaluate
+// time dependant authorizations (e.g. expiration of signs) deterministically
+func SetFixed(t time.Time) {
+	now = func() time.Time {
+		return t
+	}
+}
+
+// Reset restores the clock to the system time
+func Reset() {
+	now = time.Now
+}
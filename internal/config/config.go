@@ -1,12 +1,31 @@
 package config
 
 import (
+	"bytes"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"regexp"
 	"slices"
+	"strings"
+	"time"
 
 	"doorkeeper/api/v1alpha2"
+	"doorkeeper/internal/audit"
+	"doorkeeper/internal/clientip"
+	"doorkeeper/internal/geoip"
+	"doorkeeper/internal/hmac"
+	"doorkeeper/internal/iplist"
+	"doorkeeper/internal/logger"
+	"doorkeeper/internal/redaction"
+	"doorkeeper/internal/requestid"
+	"doorkeeper/internal/schedule"
+	"doorkeeper/internal/servertls"
+	"doorkeeper/internal/signedcookie"
+	"doorkeeper/internal/utils"
 
 	"gopkg.in/yaml.v3"
 )
@@ -22,10 +41,25 @@ const (
 	ConfigAuthTypeHMAC   = "HMAC"
 	ConfigAuthTypeIPLIST = "IPLIST"
 	ConfigAuthTypeMATCH  = "MATCH"
+	ConfigAuthTypeGEOIP  = "GEOIP"
+
+	ConfigAuthTypeSCHEDULE     = "SCHEDULE"
+	ConfigAuthTypeSIGNEDCOOKIE = "SIGNED_COOKIE"
 
 	ConfigAuthParamTypeHEADER = "HEADER"
 	ConfigAuthParamTypeQUERY  = "QUERY"
 
+	ConfigAuthParamTypeCLIENTIP = "CLIENT_IP"
+
+	ConfigAuthParamTypeMETHOD      = "METHOD"
+	ConfigAuthParamTypeHOST        = "HOST"
+	ConfigAuthParamTypePATH        = "PATH"
+	ConfigAuthParamTypeQUERYSTRING = "QUERY_STRING"
+	ConfigAuthParamTypeCOOKIE      = "COOKIE"
+
+	ConfigAuthParamTypePATHSEGMENT = "PATH_SEGMENT"
+	ConfigAuthParamTypePATHREGEX   = "PATH_REGEX"
+
 	ConfigAuthHmacTypeURL = "URL"
 
 	ConfigAuthHmacUrlFromPATH   = "PATH"
@@ -36,35 +70,464 @@ const (
 	ConfigAuthHmacAlgorithmSHA256 = "sha256"
 	ConfigAuthHmacAlgorithmSHA512 = "sha512"
 
+	ConfigAuthMatchOperatorREGEX   = "REGEX"
+	ConfigAuthMatchOperatorEXACT   = "EXACT"
+	ConfigAuthMatchOperatorPREFIX  = "PREFIX"
+	ConfigAuthMatchOperatorSUFFIX  = "SUFFIX"
+	ConfigAuthMatchOperatorONEOF   = "ONE_OF"
+	ConfigAuthMatchOperatorRANGE   = "RANGE"
+	ConfigAuthMatchOperatorPRESENT = "PRESENT"
+	ConfigAuthMatchOperatorABSENT  = "ABSENT"
+
 	// Requirements types
 
 	ConfigTypeValueRequirementALL = "all"
 	ConfigTypeValueRequirementANY = "any"
 )
 
-func expandEnv(input []byte) []byte {
-	re := regexp.MustCompile(`\${ENV:([A-Za-z_][A-Za-z0-9_]*)}\$`)
-	result := re.ReplaceAllFunc(input, func(match []byte) []byte {
-		key := re.FindSubmatch(match)[1]
-		if value, exists := os.LookupEnv(string(key)); exists {
-			return []byte(value)
+var (
+	// Allowed values for enum fields. They are matched case-insensitively
+	// and normalized to these values while checking the config
+
+	modifierTypes = []string{ConfigModifierTypePATH, ConfigModifierTypeHEADER}
+
+	authTypes = []string{
+		ConfigAuthTypeHMAC,
+		ConfigAuthTypeIPLIST,
+		ConfigAuthTypeMATCH,
+		ConfigAuthTypeGEOIP,
+		ConfigAuthTypeSCHEDULE,
+		ConfigAuthTypeSIGNEDCOOKIE,
+	}
+	authParamTypes = []string{
+		ConfigAuthParamTypeHEADER,
+		ConfigAuthParamTypeQUERY,
+		ConfigAuthParamTypeCLIENTIP,
+		ConfigAuthParamTypeCOOKIE,
+		ConfigAuthParamTypePATHSEGMENT,
+		ConfigAuthParamTypePATHREGEX,
+		ConfigAuthParamTypeQUERYSTRING,
+	}
+	authHmacTypes    = []string{ConfigAuthHmacTypeURL}
+	authHmacUrlFroms = []string{
+		ConfigAuthHmacUrlFromPATH,
+		ConfigAuthHmacUrlFromHEADER,
+	}
+	authHmacAlgorithms = []string{
+		ConfigAuthHmacAlgorithmMD5,
+		ConfigAuthHmacAlgorithmSHA1,
+		ConfigAuthHmacAlgorithmSHA256,
+		ConfigAuthHmacAlgorithmSHA512,
+	}
+
+	authMatchSources = []string{
+		ConfigAuthParamTypeMETHOD,
+		ConfigAuthParamTypeHOST,
+		ConfigAuthParamTypePATH,
+		ConfigAuthParamTypeQUERYSTRING,
+		ConfigAuthParamTypeHEADER,
+		ConfigAuthParamTypeQUERY,
+		ConfigAuthParamTypeCOOKIE,
+		ConfigAuthParamTypeCLIENTIP,
+	}
+	authMatchOperators = []string{
+		ConfigAuthMatchOperatorREGEX,
+		ConfigAuthMatchOperatorEXACT,
+		ConfigAuthMatchOperatorPREFIX,
+		ConfigAuthMatchOperatorSUFFIX,
+		ConfigAuthMatchOperatorONEOF,
+		ConfigAuthMatchOperatorRANGE,
+		ConfigAuthMatchOperatorPRESENT,
+		ConfigAuthMatchOperatorABSENT,
+	}
+
+	requirementTypes = []string{ConfigTypeValueRequirementALL, ConfigTypeValueRequirementANY}
+)
+
+var (
+	// referenceRegex matches the references to environment variables and files: ${ENV:NAME}$, ${FILE:/path}$ and ${FILE_B64:/path}$
+	referenceRegex = regexp.MustCompile(`\${(ENV|FILE|FILE_B64):([^}]+)}\$`)
+	envNameRegex   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	countryCodeRegex = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+// expandReferences replaces the references in the values of the YAML (or JSON) content:
+// ${ENV:NAME}$ with the environment variable, kept as it is when not set,
+// ${FILE:/path}$ with the content of the file without trailing line breaks,
+// and ${FILE_B64:/path}$ with the whole content of the file encoded in base64.
+// Values are expanded once the content is parsed, in a single pass, so they can not change
+// the structure of the document, whatever characters or lines they have, and references
+// inside the values inserted are not expanded again
+func expandReferences(content []byte) (result []byte, err error) {
+	if !referenceRegex.Match(content) {
+		return content, nil
+	}
+
+	// invalid documents are returned as they are, so decoding them reports the error in its line
+	var document yaml.Node
+	if yaml.Unmarshal(content, &document) != nil || document.Kind == 0 {
+		return content, nil
+	}
+
+	err = expandNodeReferences(&document)
+	if err != nil {
+		return content, err
+	}
+
+	return yaml.Marshal(&document)
+}
+
+// expandNodeReferences expands the references in the scalars of the node and its children
+func expandNodeReferences(node *yaml.Node) (err error) {
+	for _, childv := range node.Content {
+		err = expandNodeReferences(childv)
+		if err != nil {
+			return err
 		}
+	}
+
+	if node.Kind != yaml.ScalarNode || !strings.Contains(node.Value, "${") {
+		return nil
+	}
+
+	expanded := referenceRegex.ReplaceAllStringFunc(node.Value, func(match string) string {
+		if err != nil {
+			return match
+		}
+
+		submatch := referenceRegex.FindStringSubmatch(match)
+		kind, name := submatch[1], submatch[2]
+
+		switch kind {
+		case "ENV":
+			{
+				if !envNameRegex.MatchString(name) {
+					return match
+				}
+
+				if value, exists := os.LookupEnv(name); exists {
+					return value
+				}
+				return match
+			}
+		case "FILE", "FILE_B64":
+			{
+				var fileContent []byte
+				fileContent, err = os.ReadFile(name)
+				if err != nil {
+					err = fmt.Errorf("unable to read referenced file: %s", err.Error())
+					return match
+				}
+
+				if kind == "FILE_B64" {
+					return base64.StdEncoding.EncodeToString(fileContent)
+				}
+				return strings.TrimRight(string(fileContent), "\r\n")
+			}
+		}
+
 		return match
 	})
 
-	return result
+	if expanded == node.Value {
+		return err
+	}
+	node.Value = expanded
+
+	// unquoted values take the type of their content, as numbers or durations do,
+	// while quoted ones stay as strings
+	if node.Style == 0 {
+		node.Tag = ""
+	}
+
+	return err
+}
+
+// normalizeAuthorization changes the enum fields of an authorization to their canonical case
+func normalizeAuthorization(auth *v1alpha2.AuthorizationConfigT) {
+	auth.Type = strings.ToUpper(auth.Type)
+	auth.Param.Type = strings.ToUpper(auth.Param.Type)
+	auth.Hmac.Type = strings.ToUpper(auth.Hmac.Type)
+	auth.Hmac.Url.From = strings.ToUpper(auth.Hmac.Url.From)
+	auth.Hmac.EncryptionAlgorithm = strings.ToLower(auth.Hmac.EncryptionAlgorithm)
+	auth.Hmac.Compatibility = strings.ToUpper(auth.Hmac.Compatibility)
+	auth.Hmac.KeyEncoding = strings.ToLower(auth.Hmac.KeyEncoding)
+	auth.Hmac.Token.DigestEncoding = strings.ToLower(auth.Hmac.Token.DigestEncoding)
+	auth.SignedCookie.Algorithm = strings.ToUpper(auth.SignedCookie.Algorithm)
+	auth.SignedCookie.KeyEncoding = strings.ToLower(auth.SignedCookie.KeyEncoding)
+
+	for countryi, countryv := range auth.GeoIp.Countries {
+		auth.GeoIp.Countries[countryi] = strings.ToUpper(countryv)
+	}
+
+	for continenti, continentv := range auth.GeoIp.Continents {
+		auth.GeoIp.Continents[continenti] = strings.ToUpper(continentv)
+	}
+}
+
+// checkAuthParam checks the fields needed by each type of param
+func checkAuthParam(param v1alpha2.AuthParamConfigT) error {
+	switch param.Type {
+	case ConfigAuthParamTypeCLIENTIP, ConfigAuthParamTypePATHSEGMENT, ConfigAuthParamTypeQUERYSTRING:
+		return nil
+	case ConfigAuthParamTypePATHREGEX:
+		{
+			compiledRegex, err := regexp.Compile(param.Pattern)
+			if err != nil {
+				return fmt.Errorf("invalid pattern: %s", err.Error())
+			}
+
+			if compiledRegex.NumSubexp() == 0 {
+				return fmt.Errorf("pattern must have a capture group for %s params", param.Type)
+			}
+		}
+	default:
+		{
+			if param.Name == "" {
+				return fmt.Errorf("param name must be set for %s params", param.Type)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkMatchCondition checks a condition of a match authorization, normalizing its enum fields
+func checkMatchCondition(condition *v1alpha2.MatchConditionConfigT) error {
+	condition.Source = strings.ToUpper(condition.Source)
+	condition.Operator = strings.ToUpper(condition.Operator)
+
+	if !slices.Contains(authMatchSources, condition.Source) {
+		return fmt.Errorf("source must be one of %v", authMatchSources)
+	}
+
+	if !slices.Contains(authMatchOperators, condition.Operator) {
+		return fmt.Errorf("operator must be one of %v", authMatchOperators)
+	}
+
+	switch condition.Source {
+	case ConfigAuthParamTypeHEADER, ConfigAuthParamTypeQUERY, ConfigAuthParamTypeCOOKIE:
+		if condition.Name == "" {
+			return fmt.Errorf("name must be set for %s source", condition.Source)
+		}
+	}
+
+	switch condition.Operator {
+	case ConfigAuthMatchOperatorREGEX:
+		if _, err := regexp.Compile(condition.Value); err != nil {
+			return fmt.Errorf("invalid regex: %s", err.Error())
+		}
+	case ConfigAuthMatchOperatorEXACT, ConfigAuthMatchOperatorPREFIX, ConfigAuthMatchOperatorSUFFIX:
+		if condition.Value == "" {
+			return fmt.Errorf("value must be set for %s operator", condition.Operator)
+		}
+	case ConfigAuthMatchOperatorONEOF:
+		if len(condition.Values) == 0 {
+			return fmt.Errorf("values must be set for %s operator", condition.Operator)
+		}
+	case ConfigAuthMatchOperatorRANGE:
+		if condition.Min == nil && condition.Max == nil {
+			return fmt.Errorf("min or max must be set for %s operator", condition.Operator)
+		}
+		if condition.Min != nil && condition.Max != nil && *condition.Min > *condition.Max {
+			return fmt.Errorf("min must not be greater than max")
+		}
+	}
+
+	return nil
+}
+
+// checkHmacToken checks the grammar of the tokens, where separators must be distinct
+// so fields can be told apart
+func checkHmacToken(cfg v1alpha2.HmacConfigT) error {
+	format := hmac.TokenFormatT{
+		FieldSeparator: cfg.Token.FieldSeparator,
+		ValueSeparator: cfg.Token.ValueSeparator,
+		AclSeparator:   cfg.Token.AclSeparator,
+	}.WithDefaults()
+
+	if format.FieldSeparator == format.ValueSeparator || format.FieldSeparator == format.AclSeparator ||
+		format.ValueSeparator == format.AclSeparator {
+		return fmt.Errorf("field, value and acl separators must be different")
+	}
+
+	if cfg.Token.DigestEncoding != "" && !slices.Contains(hmac.DigestEncodings, cfg.Token.DigestEncoding) {
+		return fmt.Errorf("digest encoding must be one of %v", hmac.DigestEncodings)
+	}
+
+	if cfg.Token.DigestLength < 0 {
+		return fmt.Errorf("digest length must be positive")
+	}
+
+	return hmac.CheckDigestLength(cfg.Token.DigestLength, cfg.EncryptionAlgorithm)
+}
+
+// checkIpListUrl checks a remote source of networks, normalizing its format
+func checkIpListUrl(cidrUrl *v1alpha2.IpListUrlConfigT) error {
+	parsedUrl, err := url.Parse(cidrUrl.Url)
+	if err != nil || (parsedUrl.Scheme != "http" && parsedUrl.Scheme != "https") {
+		return fmt.Errorf("url must be a valid http or https url")
+	}
+
+	cidrUrl.Format = strings.ToUpper(cidrUrl.Format)
+	if cidrUrl.Format == "" {
+		cidrUrl.Format = iplist.FormatTEXT
+	}
+
+	if !slices.Contains(iplist.Formats, cidrUrl.Format) {
+		return fmt.Errorf("format must be one of %v", iplist.Formats)
+	}
+
+	if cidrUrl.Format == iplist.FormatJSON && len(cidrUrl.Selectors) == 0 {
+		return fmt.Errorf("selectors must be set for JSON format")
+	}
+
+	for _, selectorv := range cidrUrl.Selectors {
+		if _, err := iplist.NewSelector(selectorv); err != nil {
+			return err
+		}
+	}
+
+	if cidrUrl.RefreshInterval < 0 || cidrUrl.Timeout < 0 || cidrUrl.MaxSize < 0 {
+		return fmt.Errorf("refresh interval, timeout and max size must be positive")
+	}
+
+	return nil
+}
+
+// decodeStrict decodes YAML (or JSON) content into the given struct,
+// failing on fields not defined in it
+func decodeStrict(content []byte, out any) (err error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+
+	err = decoder.Decode(out)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+
+	return err
 }
 
 // checkConfig TODO
 func checkConfig(config *v1alpha2.DoorkeeperConfigT) error {
+	if len(config.Auths) <= 0 {
+		return fmt.Errorf("no authorizations defined")
+	}
+
+	if len(config.RequestAuthReq) <= 0 {
+		return fmt.Errorf("no request auth requirements defined")
+	}
+
+	return checkPartialConfig(config)
+}
+
+// checkPartialConfig checks the config without requiring authorizations nor requirements,
+// as the base config does not need them when policies come from Kubernetes
+func checkPartialConfig(config *v1alpha2.DoorkeeperConfigT) error {
+	if config.ReloadInterval < 0 {
+		return fmt.Errorf("reload interval must be a positive duration")
+	}
+
+	//------------------------------
+	// Client IP
+	//------------------------------
+
+	for srci, srcv := range config.ClientIp.Sources {
+		srcv = strings.ToUpper(srcv)
+		config.ClientIp.Sources[srci] = srcv
+
+		if !slices.Contains(clientip.Sources, srcv) {
+			return fmt.Errorf("client ip sources must be some of %v", clientip.Sources)
+		}
+	}
+
+	if config.ClientIp.TrustedHops < 0 {
+		return fmt.Errorf("client ip trusted hops must be a positive number")
+	}
+
+	if _, err := clientip.ParseNetworks(config.ClientIp.TrustedNetworks); err != nil {
+		return fmt.Errorf("invalid client ip trusted networks: %s", err.Error())
+	}
+
+	//------------------------------
+	// Server
+	//------------------------------
+
+	for _, timeoutv := range []time.Duration{config.Server.ReadTimeout, config.Server.ReadHeaderTimeout,
+		config.Server.WriteTimeout, config.Server.IdleTimeout, config.Server.Shutdown.Delay, config.Server.Shutdown.DrainTimeout} {
+		if timeoutv < 0 {
+			return fmt.Errorf("server timeouts must be positive durations")
+		}
+	}
+
+	if config.Server.MaxHeaderBytes < 0 || config.Server.MaxConcurrentRequests < 0 {
+		return fmt.Errorf("server max header bytes and max concurrent requests must be positive numbers")
+	}
+
+	//------------------------------
+	// TLS
+	//------------------------------
+
+	if err := servertls.Check(config.Tls); err != nil {
+		return fmt.Errorf("invalid tls: %s", err.Error())
+	}
+
+	//------------------------------
+	// Audit
+	//------------------------------
+
+	config.Audit.Sink = strings.ToUpper(config.Audit.Sink)
+	if err := audit.Check(config.Audit); err != nil {
+		return fmt.Errorf("invalid audit: %s", err.Error())
+	}
+
+	//------------------------------
+	// Request ID
+	//------------------------------
+
+	config.RequestId.Generator = strings.ToUpper(config.RequestId.Generator)
+	if _, err := requestid.NewResolver(config.RequestId); err != nil {
+		return fmt.Errorf("invalid request id: %s", err.Error())
+	}
+
+	//------------------------------
+	// Logs
+	//------------------------------
+
+	config.Logs.Format = strings.ToUpper(config.Logs.Format)
+	if config.Logs.Format != "" && !slices.Contains(logger.Formats, config.Logs.Format) {
+		return fmt.Errorf("logs format must be one of %v", logger.Formats)
+	}
+
+	for _, fieldv := range config.Logs.Decision.Fields {
+		if !slices.Contains(utils.DecisionLogFields, fieldv) {
+			return fmt.Errorf("decision logs fields must be some of %v", utils.DecisionLogFields)
+		}
+	}
+
+	for _, ratev := range []*float64{config.Logs.Decision.Sampling.Allowed, config.Logs.Decision.Sampling.Denied} {
+		if ratev != nil && (*ratev < 0 || *ratev > 1) {
+			return fmt.Errorf("decision logs sampling must be between 0 and 1")
+		}
+	}
+
+	config.Logs.Redaction.Mode = strings.ToUpper(config.Logs.Redaction.Mode)
+	if _, err := redaction.NewRedactor(config.Logs.Redaction, nil); err != nil {
+		return fmt.Errorf("invalid logs redaction: %s", err.Error())
+	}
+
 	//------------------------------
 	// Modifiers
 	//------------------------------
 
-	modTypes := []string{ConfigModifierTypePATH, ConfigModifierTypeHEADER}
-	for _, modv := range config.Modifiers {
-		if !slices.Contains(modTypes, modv.Type) {
-			return fmt.Errorf("modifier type must be one of %v", modTypes)
+	for modi, modv := range config.Modifiers {
+		modv.Type = strings.ToUpper(modv.Type)
+		config.Modifiers[modi].Type = modv.Type
+
+		if !slices.Contains(modifierTypes, modv.Type) {
+			return fmt.Errorf("modifier type must be one of %v", modifierTypes)
 		}
 
 		switch modv.Type {
@@ -94,20 +557,10 @@ func checkConfig(config *v1alpha2.DoorkeeperConfigT) error {
 	// Authorizations
 	//------------------------------
 
-	if len(config.Auths) <= 0 {
-		return fmt.Errorf("no authorizations defined")
-	}
-
-	authTypes := []string{
-		ConfigAuthTypeHMAC,
-		ConfigAuthTypeIPLIST,
-		ConfigAuthTypeMATCH,
-	}
-	authParamTypes := []string{
-		ConfigAuthParamTypeHEADER,
-		ConfigAuthParamTypeQUERY,
-	}
 	for authi, authv := range config.Auths {
+		normalizeAuthorization(&authv)
+		config.Auths[authi] = authv
+
 		// check auth basic fields
 		if authv.Name == "" {
 			return fmt.Errorf("authorization name must be set")
@@ -117,35 +570,38 @@ func checkConfig(config *v1alpha2.DoorkeeperConfigT) error {
 			return fmt.Errorf("authorization type must be one of %v", authTypes)
 		}
 
-		// check auth param fields
-		if authv.Param.Name == "" {
-			return fmt.Errorf("param name in authorization must be set")
-		}
+		// check auth param fields, not used by schedules as they only depend on time,
+		// by signed cookies as they define their own cookies,
+		// nor by matches with only conditions, as they define their own sources
+		usesParam := authv.Type != ConfigAuthTypeSCHEDULE && authv.Type != ConfigAuthTypeSIGNEDCOOKIE &&
+			!(authv.Type == ConfigAuthTypeMATCH && authv.Match.Pattern == "")
+		if usesParam {
+			if !slices.Contains(authParamTypes, authv.Param.Type) {
+				return fmt.Errorf("param type in authorizations must be one of %v", authParamTypes)
+			}
 
-		if !slices.Contains(authParamTypes, authv.Param.Type) {
-			return fmt.Errorf("param type in authorizations must be one of %v", authParamTypes)
+			err := checkAuthParam(authv.Param)
+			if err != nil {
+				return fmt.Errorf("invalid param in authorization '%s': %s", authv.Name, err.Error())
+			}
 		}
 
 		// check specific types param fields
 		switch authv.Type {
 		case ConfigAuthTypeHMAC:
 			{
-				authHmacTypes := []string{ConfigAuthHmacTypeURL}
 				if !slices.Contains(authHmacTypes, authv.Hmac.Type) {
 					return fmt.Errorf("hmac type in authorizations must be one of %v", authHmacTypes)
 				}
 
 				if authv.Hmac.Type == ConfigAuthHmacTypeURL {
 					if authv.Hmac.Url.From == "" {
-						config.Auths[authi].Hmac.Url.From = ConfigAuthHmacUrlFromPATH
+						authv.Hmac.Url.From = ConfigAuthHmacUrlFromPATH
+						config.Auths[authi].Hmac.Url.From = authv.Hmac.Url.From
 					}
 
-					urlFroms := []string{
-						ConfigAuthHmacUrlFromPATH,
-						ConfigAuthHmacUrlFromHEADER,
-					}
-					if !slices.Contains(urlFroms, authv.Hmac.Url.From) {
-						return fmt.Errorf("hmac url from in authorizations must be one of %v", urlFroms)
+					if !slices.Contains(authHmacUrlFroms, authv.Hmac.Url.From) {
+						return fmt.Errorf("hmac url from in authorizations must be one of %v", authHmacUrlFroms)
 					}
 
 					if authv.Hmac.Url.From == ConfigAuthHmacUrlFromHEADER && authv.Hmac.Url.Name == "" {
@@ -153,30 +609,132 @@ func checkConfig(config *v1alpha2.DoorkeeperConfigT) error {
 					}
 				}
 
-				encryptionAlgorithms := []string{
-					ConfigAuthHmacAlgorithmMD5,
-					ConfigAuthHmacAlgorithmSHA1,
-					ConfigAuthHmacAlgorithmSHA256,
-					ConfigAuthHmacAlgorithmSHA512,
-				}
-				if !slices.Contains(encryptionAlgorithms, authv.Hmac.EncryptionAlgorithm) {
-					return fmt.Errorf("hmac encryption algorithm in authorizations must be one of %v", encryptionAlgorithms)
+				if !slices.Contains(authHmacAlgorithms, authv.Hmac.EncryptionAlgorithm) {
+					return fmt.Errorf("hmac encryption algorithm in authorizations must be one of %v", authHmacAlgorithms)
 				}
 
 				if authv.Hmac.EncryptionKey == "" {
 					return fmt.Errorf("encription key in hmac authorizations must be set")
 				}
+
+				if config.Security.DisallowWeakHmacAlgorithms && slices.Contains(hmac.WeakAlgorithms, authv.Hmac.EncryptionAlgorithm) {
+					return fmt.Errorf("hmac encryption algorithm '%s' in authorization '%s' is disallowed by the security policy",
+						authv.Hmac.EncryptionAlgorithm, authv.Name)
+				}
+
+				if authv.Hmac.KeyEncoding != "" && !slices.Contains(hmac.KeyEncodings, authv.Hmac.KeyEncoding) {
+					return fmt.Errorf("hmac key encoding in authorizations must be one of %v", hmac.KeyEncodings)
+				}
+
+				if _, err := hmac.DecodeKey(authv.Hmac.EncryptionKey, authv.Hmac.KeyEncoding, authv.Hmac.EncryptionAlgorithm); err != nil {
+					return fmt.Errorf("invalid encryption key in hmac authorization '%s': %s", authv.Name, err.Error())
+				}
+
+				if authv.Hmac.Compatibility != "" && !slices.Contains(hmac.Compatibilities, authv.Hmac.Compatibility) {
+					return fmt.Errorf("hmac compatibility in authorizations must be one of %v", hmac.Compatibilities)
+				}
+
+				if authv.Hmac.Salt != "" && authv.Hmac.Compatibility != hmac.CompatibilityAKAMAI {
+					return fmt.Errorf("hmac salt is only supported with %s compatibility", hmac.CompatibilityAKAMAI)
+				}
+
+				if err := checkHmacToken(authv.Hmac); err != nil {
+					return fmt.Errorf("invalid token format in hmac authorization '%s': %s", authv.Name, err.Error())
+				}
 			}
 		case ConfigAuthTypeIPLIST:
 			{
-				if authv.IpList.Cidr == "" {
-					return fmt.Errorf("cidr field in ip list authorizations must be set")
+				if authv.IpList.Cidr == "" && len(authv.IpList.Cidrs) == 0 &&
+					len(authv.IpList.CidrFiles) == 0 && len(authv.IpList.CidrUrls) == 0 {
+					return fmt.Errorf("cidr, cidrs, cidrFiles or cidrUrls fields in ip list authorizations must be set")
+				}
+
+				for urli, urlv := range authv.IpList.CidrUrls {
+					err := checkIpListUrl(&config.Auths[authi].IpList.CidrUrls[urli])
+					if err != nil {
+						return fmt.Errorf("invalid cidr url '%s' in ip list authorizations: %s", urlv.Url, err.Error())
+					}
 				}
 			}
 		case ConfigAuthTypeMATCH:
 			{
-				if authv.Match.Pattern == "" {
-					return fmt.Errorf("pattern field in match authorizations must be set")
+				if authv.Match.Pattern == "" && len(authv.Match.Conditions) == 0 {
+					return fmt.Errorf("pattern or conditions fields in match authorizations must be set")
+				}
+
+				if _, err := regexp.Compile(authv.Match.Pattern); err != nil {
+					return fmt.Errorf("invalid pattern in match authorization '%s': %s", authv.Name, err.Error())
+				}
+
+				for condi := range authv.Match.Conditions {
+					err := checkMatchCondition(&config.Auths[authi].Match.Conditions[condi])
+					if err != nil {
+						return fmt.Errorf("invalid condition in match authorization '%s': %s", authv.Name, err.Error())
+					}
+				}
+			}
+		case ConfigAuthTypeGEOIP:
+			{
+				if len(authv.GeoIp.Databases) == 0 {
+					return fmt.Errorf("databases field in geoip authorizations must be set")
+				}
+
+				if len(authv.GeoIp.Countries) == 0 && len(authv.GeoIp.Continents) == 0 && len(authv.GeoIp.Asns) == 0 {
+					return fmt.Errorf("countries, continents or asns fields in geoip authorizations must be set")
+				}
+
+				for _, countryv := range authv.GeoIp.Countries {
+					if !countryCodeRegex.MatchString(countryv) {
+						return fmt.Errorf("countries in geoip authorizations must be ISO 3166-1 alpha-2 codes, got '%s'", countryv)
+					}
+				}
+
+				for _, continentv := range authv.GeoIp.Continents {
+					if !slices.Contains(geoip.Continents, continentv) {
+						return fmt.Errorf("continents in geoip authorizations must be some of %v", geoip.Continents)
+					}
+				}
+
+				// databases are opened to report missing or invalid files with the config errors
+				if _, err := geoip.Open(authv.GeoIp.Databases); err != nil {
+					return fmt.Errorf("unable to open databases in geoip authorization '%s': %s", authv.Name, err.Error())
+				}
+			}
+		case ConfigAuthTypeSIGNEDCOOKIE:
+			{
+				if !slices.Contains(signedcookie.Algorithms, authv.SignedCookie.Algorithm) {
+					return fmt.Errorf("algorithm in signed cookie authorizations must be one of %v", signedcookie.Algorithms)
+				}
+
+				if config.Security.DisallowWeakHmacAlgorithms && slices.Contains(signedcookie.WeakAlgorithms, authv.SignedCookie.Algorithm) {
+					return fmt.Errorf("algorithm '%s' in signed cookie authorization '%s' is disallowed by the security policy",
+						authv.SignedCookie.Algorithm, authv.Name)
+				}
+
+				if authv.SignedCookie.KeyEncoding != "" && !slices.Contains(hmac.KeyEncodings, authv.SignedCookie.KeyEncoding) {
+					return fmt.Errorf("key encoding in signed cookie authorizations must be one of %v", hmac.KeyEncodings)
+				}
+
+				ids := map[string]bool{}
+				for _, keyv := range authv.SignedCookie.Keys {
+					if keyv.Id == "" && len(authv.SignedCookie.Keys) > 1 {
+						return fmt.Errorf("key ids in signed cookie authorization '%s' must be set when there are several keys", authv.Name)
+					}
+
+					if ids[keyv.Id] {
+						return fmt.Errorf("key id '%s' duplicated in signed cookie authorization '%s'", keyv.Id, authv.Name)
+					}
+					ids[keyv.Id] = true
+				}
+
+				if _, err := signedcookie.NewVerifier(authv.SignedCookie); err != nil {
+					return fmt.Errorf("invalid signed cookie authorization '%s': %s", authv.Name, err.Error())
+				}
+			}
+		case ConfigAuthTypeSCHEDULE:
+			{
+				if _, err := schedule.NewSchedule(authv.Schedule); err != nil {
+					return fmt.Errorf("invalid schedule in authorization '%s': %s", authv.Name, err.Error())
 				}
 			}
 		}
@@ -186,14 +744,12 @@ func checkConfig(config *v1alpha2.DoorkeeperConfigT) error {
 	// RequestAuthRequirements
 	//------------------------------
 
-	if len(config.RequestAuthReq) <= 0 {
-		return fmt.Errorf("no request auth requirements defined")
-	}
+	for reqi, reqv := range config.RequestAuthReq {
+		reqv.Type = strings.ToLower(reqv.Type)
+		config.RequestAuthReq[reqi].Type = reqv.Type
 
-	reqTypes := []string{ConfigTypeValueRequirementALL, ConfigTypeValueRequirementANY}
-	for _, reqv := range config.RequestAuthReq {
-		if !slices.Contains(reqTypes, reqv.Type) {
-			return fmt.Errorf("request auth requirement type must be one of %v", reqTypes)
+		if !slices.Contains(requirementTypes, reqv.Type) {
+			return fmt.Errorf("request auth requirement type must be one of %v", requirementTypes)
 		}
 
 		if len(reqv.Authorizations) <= 0 {
@@ -227,23 +783,28 @@ func checkConfig(config *v1alpha2.DoorkeeperConfigT) error {
 	return nil
 }
 
-// ParseConfigFile TODO
-func ParseConfigFile(filepath string) (config v1alpha2.DoorkeeperConfigT, err error) {
-	var fileBytes []byte
-	fileBytes, err = os.ReadFile(filepath)
+// ReadConfigFile returns the content of a config file with
+// the references to environment variables and files expanded
+func ReadConfigFile(filepath string) (content []byte, err error) {
+	content, err = os.ReadFile(filepath)
 	if err != nil {
-		return config, err
+		return content, err
+	}
+
+	content, err = expandReferences(content)
+	if err != nil {
+		return content, fmt.Errorf("unable to expand config file '%s': %s", filepath, err.Error())
 	}
 
-	fileBytes = expandEnv(fileBytes)
+	return content, err
+}
 
-	// config, err = Unmarshal(fileBytes)
-	err = yaml.Unmarshal(fileBytes, &config)
+// ParseConfigFile TODO
+func ParseConfigFile(filepath string) (config v1alpha2.DoorkeeperConfigT, err error) {
+	sources, err := ReadConfigSources([]string{filepath})
 	if err != nil {
 		return config, err
 	}
 
-	err = checkConfig(&config)
-
-	return config, err
+	return ParseConfigSources(sources)
 }
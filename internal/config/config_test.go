@@ -0,0 +1,593 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"doorkeeper/api/v1alpha2"
+)
+
+func TestExpandReferences(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("unable to write file: %v", err)
+		}
+		return path
+	}
+
+	pem := "-----BEGIN PUBLIC KEY-----\nMFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAE\n-----END PUBLIC KEY-----\n"
+	pemFile := writeFile("key.pem", pem)
+	injectionFile := writeFile("injection", "secret\nlogLevel: debug")
+	colonFile := writeFile("colon", "a: b")
+	nestedFile := writeFile("nested", "${ENV:DOORKEEPER_TEST_SECRET}$")
+	keyFile := writeFile("key", "00112233445566778899aabbccddeeff\n")
+
+	t.Setenv("DOORKEEPER_TEST_SECRET", "from-env")
+	t.Setenv("DOORKEEPER_TEST_INTERVAL", "45s")
+	t.Setenv("DOORKEEPER_TEST_SIZE", "25")
+
+	tests := []struct {
+		name    string
+		content string
+		check   func(t *testing.T, cfg v1alpha2.DoorkeeperConfigT)
+		wantErr bool
+	}{
+		{
+			name:    "multi-line file",
+			content: "response:\n  denied:\n    headers:\n      key: ${FILE:" + pemFile + "}$\n",
+			check: func(t *testing.T, cfg v1alpha2.DoorkeeperConfigT) {
+				if got := cfg.Response.Denied.Headers["key"]; got != pem[:len(pem)-1] {
+					t.Errorf("value = %q, want %q", got, pem[:len(pem)-1])
+				}
+			},
+		},
+		{
+			name:    "file with lines of yaml is a single value",
+			content: "logLevel: info\nresponse:\n  denied:\n    body: ${FILE:" + injectionFile + "}$\n",
+			check: func(t *testing.T, cfg v1alpha2.DoorkeeperConfigT) {
+				if cfg.LogLevel != "info" || cfg.Response.Denied.Body != "secret\nlogLevel: debug" {
+					t.Errorf("logLevel = %q, body = %q", cfg.LogLevel, cfg.Response.Denied.Body)
+				}
+			},
+		},
+		{
+			name:    "file with colon is a single value",
+			content: "response:\n  denied:\n    body: ${FILE:" + colonFile + "}$\n",
+			check: func(t *testing.T, cfg v1alpha2.DoorkeeperConfigT) {
+				if cfg.Response.Denied.Body != "a: b" {
+					t.Errorf("body = %q", cfg.Response.Denied.Body)
+				}
+			},
+		},
+		{
+			name:    "references in contents are not expanded",
+			content: "response:\n  denied:\n    body: ${FILE:" + nestedFile + "}$\n",
+			check: func(t *testing.T, cfg v1alpha2.DoorkeeperConfigT) {
+				if cfg.Response.Denied.Body != "${ENV:DOORKEEPER_TEST_SECRET}$" {
+					t.Errorf("body = %q", cfg.Response.Denied.Body)
+				}
+			},
+		},
+		{
+			name:    "file in base64",
+			content: "response:\n  denied:\n    body: ${FILE_B64:" + colonFile + "}$\n",
+			check: func(t *testing.T, cfg v1alpha2.DoorkeeperConfigT) {
+				if cfg.Response.Denied.Body != "YTogYg==" {
+					t.Errorf("body = %q", cfg.Response.Denied.Body)
+				}
+			},
+		},
+		{
+			name:    "references inside values",
+			content: "response:\n  denied:\n    headers:\n      authorization: \"Bearer ${ENV:DOORKEEPER_TEST_SECRET}$ ${FILE:" + keyFile + "}$\"\n",
+			check: func(t *testing.T, cfg v1alpha2.DoorkeeperConfigT) {
+				want := "Bearer from-env 00112233445566778899aabbccddeeff"
+				if got := cfg.Response.Denied.Headers["authorization"]; got != want {
+					t.Errorf("value = %q, want %q", got, want)
+				}
+			},
+		},
+		{
+			name:    "unquoted values take their type",
+			content: "reloadInterval: ${ENV:DOORKEEPER_TEST_INTERVAL}$\nresponse:\n  denied:\n    statusCode: ${ENV:DOORKEEPER_TEST_SIZE}$\n",
+			check: func(t *testing.T, cfg v1alpha2.DoorkeeperConfigT) {
+				if cfg.ReloadInterval != 45*time.Second || cfg.Response.Denied.StatusCode != 25 {
+					t.Errorf("reloadInterval = %v, statusCode = %d", cfg.ReloadInterval, cfg.Response.Denied.StatusCode)
+				}
+			},
+		},
+		{
+			name:    "quoted values stay strings",
+			content: "port: \"${ENV:DOORKEEPER_TEST_SIZE}$\"\n",
+			check: func(t *testing.T, cfg v1alpha2.DoorkeeperConfigT) {
+				if cfg.Port != "25" {
+					t.Errorf("port = %q", cfg.Port)
+				}
+			},
+		},
+		{
+			name:    "unset variables are kept",
+			content: "logLevel: ${ENV:DOORKEEPER_TEST_UNSET}$\n",
+			check: func(t *testing.T, cfg v1alpha2.DoorkeeperConfigT) {
+				if cfg.LogLevel != "${ENV:DOORKEEPER_TEST_UNSET}$" {
+					t.Errorf("logLevel = %q", cfg.LogLevel)
+				}
+			},
+		},
+		{
+			name:    "references in comments are ignored",
+			content: "# key: ${FILE:/not/found}$\nlogLevel: info\n",
+			check: func(t *testing.T, cfg v1alpha2.DoorkeeperConfigT) {
+				if cfg.LogLevel != "info" {
+					t.Errorf("logLevel = %q", cfg.LogLevel)
+				}
+			},
+		},
+		{
+			name:    "missing file",
+			content: "logLevel: ${FILE:/not/found}$\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, err := expandReferences([]byte(tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expandReferences() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			var cfg v1alpha2.DoorkeeperConfigT
+			if err := decodeStrict(content, &cfg); err != nil {
+				t.Fatalf("unable to decode expanded content %q: %v", content, err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
+
+// testBaseConfig is a minimal valid config, extended by the test cases
+const testBaseConfig = `
+authorizations:
+  - name: host
+    type: MATCH
+    param:
+      type: HEADER
+      name: host
+    match:
+      pattern: "^example\\.com$"
+requestAuthRequirements:
+  - name: host
+    type: all
+    authorizations: [host]
+response:
+  denied:
+    statusCode: 403
+  allowed:
+    statusCode: 200
+`
+
+func TestParseConfigStrict(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		check   func(t *testing.T, cfg v1alpha2.DoorkeeperConfigT)
+		wantErr string
+	}{
+		{
+			name:    "unknown top level field",
+			content: testBaseConfig + "logLevl: debug\n",
+			wantErr: "field logLevl not found",
+		},
+		{
+			name: "unknown nested field",
+			content: testBaseConfig + `
+modifiers:
+  - type: PATH
+    path:
+      patern: "^/v1"
+`,
+			wantErr: "field patern not found",
+		},
+		{
+			name:    "wrong type",
+			content: testBaseConfig + "port: [8000]\n",
+			wantErr: "cannot unmarshal",
+		},
+		{
+			name: "enums in any case",
+			content: `
+authorizations:
+  - name: token
+    type: hmac
+    param:
+      type: query
+      name: token
+    hmac:
+      type: Url
+      encryptionKey: "00112233445566778899aabbccddeeff"
+      encryptionAlgorithm: SHA256
+      url:
+        from: path
+requestAuthRequirements:
+  - name: token
+    type: ANY
+    authorizations: [token]
+modifiers:
+  - type: path
+    path:
+      pattern: "^/v1"
+response:
+  denied:
+    statusCode: 403
+  allowed:
+    statusCode: 200
+`,
+			check: func(t *testing.T, cfg v1alpha2.DoorkeeperConfigT) {
+				hmacAuth := cfg.Auths[0]
+				if hmacAuth.Type != ConfigAuthTypeHMAC || hmacAuth.Param.Type != ConfigAuthParamTypeQUERY ||
+					hmacAuth.Hmac.Type != ConfigAuthHmacTypeURL || hmacAuth.Hmac.EncryptionAlgorithm != "sha256" ||
+					hmacAuth.Hmac.Url.From != ConfigAuthHmacUrlFromPATH {
+					t.Errorf("hmac authorization not normalized: %+v", hmacAuth)
+				}
+
+				if cfg.RequestAuthReq[0].Type != "any" || cfg.Modifiers[0].Type != ConfigModifierTypePATH {
+					t.Errorf("config not normalized: requirement %s, modifier %s", cfg.RequestAuthReq[0].Type, cfg.Modifiers[0].Type)
+				}
+			},
+		},
+		{
+			name:    "unknown enum value",
+			content: strings.Replace(testBaseConfig, "type: all", "type: most", 1),
+			wantErr: "request auth requirement type must be one of",
+		},
+		{
+			name:    "json content",
+			content: `{"authorizations": [{"name": "host", "type": "match", "param": {"type": "header", "name": "host"}, "match": {"pattern": "^a$"}}], "requestAuthRequirements": [{"name": "host", "type": "all", "authorizations": ["host"]}], "response": {"denied": {"statusCode": 403}, "allowed": {"statusCode": 200}}}`,
+			check: func(t *testing.T, cfg v1alpha2.DoorkeeperConfigT) {
+				if len(cfg.Auths) != 1 || cfg.Auths[0].Type != ConfigAuthTypeMATCH {
+					t.Errorf("authorizations = %+v", cfg.Auths)
+				}
+			},
+		},
+		{
+			name: "missing geoip database",
+			content: strings.Replace(testBaseConfig, "requestAuthRequirements:", `  - name: geo
+    type: GEOIP
+    param:
+      type: CLIENT_IP
+    geoIp:
+      databases: [/nonexistent/GeoLite2-Country.mmdb]
+      countries: [es]
+requestAuthRequirements:`, 1),
+			wantErr: "unable to open databases in geoip authorization 'geo'",
+		},
+		{
+			name:    "unknown field in json",
+			content: `{"authorizations": [], "extra": true}`,
+			wantErr: "field extra not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatalf("unable to write file: %v", err)
+			}
+
+			cfg, err := ParseConfigFile(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseConfigFile() error = %v, want it containing '%s'", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestGenerateJSONSchema(t *testing.T) {
+	generated, err := GenerateJSONSchema()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	committed, err := os.ReadFile("../../docs/schemas/doorkeeper.v1alpha2.schema.json")
+	if err != nil {
+		t.Fatalf("unable to read the committed schema: %v", err)
+	}
+
+	if strings.TrimSpace(string(committed)) != strings.TrimSpace(string(generated)) {
+		t.Errorf("committed schema is outdated, run 'make schema'")
+	}
+
+	var schema jsonSchemaT
+	if err = json.Unmarshal(generated, &schema); err != nil {
+		t.Fatalf("invalid schema: %v", err)
+	}
+
+	// every enum must point to an existing field, or it would be silently ignored
+	for fieldv, valuesv := range schemaEnums {
+		typeName, fieldName, _ := strings.Cut(fieldv, ".")
+		def, ok := schema.Defs[typeName]
+		if !ok {
+			t.Errorf("enum '%s' refers to an unknown type", fieldv)
+			continue
+		}
+
+		found := false
+		for _, propv := range def.Properties {
+			if propv.Items != nil {
+				propv = propv.Items
+			}
+			if propv.Description == "One of "+strings.Join(valuesv, ", ")+" (case insensitive)" {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("enum '%s' refers to an unknown field %s", fieldv, fieldName)
+		}
+	}
+
+	tests := []struct {
+		def   string
+		prop  string
+		value string
+		want  bool
+	}{
+		{def: "AuthorizationConfigT", prop: "type", value: "HMAC", want: true},
+		{def: "AuthorizationConfigT", prop: "type", value: "hmac", want: true},
+		{def: "AuthorizationConfigT", prop: "type", value: "IpList", want: true},
+		{def: "AuthorizationConfigT", prop: "type", value: "hmacs", want: false},
+		{def: "AuthorizationConfigT", prop: "type", value: "", want: false},
+		{def: "HmacConfigT", prop: "encryptionAlgorithm", value: "SHA512", want: true},
+		{def: "DoorkeeperConfigT", prop: "reloadInterval", value: "1m30s", want: true},
+		{def: "DoorkeeperConfigT", prop: "reloadInterval", value: "90", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.def+"."+tt.prop+"="+tt.value, func(t *testing.T) {
+			prop := schema.Defs[tt.def].Properties[tt.prop]
+			if prop == nil {
+				t.Fatalf("property not found")
+			}
+			if prop.Items != nil {
+				prop = prop.Items
+			}
+
+			if got := regexp.MustCompile(prop.Pattern).MatchString(tt.value); got != tt.want {
+				t.Errorf("pattern %s matching '%s' = %v, want %v", prop.Pattern, tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadConfigSources(t *testing.T) {
+	dir := t.TempDir()
+	for _, namev := range []string{"20-auths.yaml", "10-base.yml", "30-extra.json", "notes.txt", ".hidden.yaml"} {
+		if err := os.WriteFile(filepath.Join(dir, namev), []byte("{}\n"), 0o600); err != nil {
+			t.Fatalf("unable to write file: %v", err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "..data"), 0o700); err != nil {
+		t.Fatalf("unable to create dir: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "nested.yaml"), 0o700); err != nil {
+		t.Fatalf("unable to create dir: %v", err)
+	}
+	single := filepath.Join(t.TempDir(), "single.conf")
+	if err := os.WriteFile(single, []byte("{}\n"), 0o600); err != nil {
+		t.Fatalf("unable to write file: %v", err)
+	}
+
+	sources, err := ReadConfigSources([]string{single, dir})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := []string{}
+	for _, sourcev := range sources {
+		got = append(got, filepath.Base(sourcev.Path))
+	}
+
+	// files are taken as given, whatever their extension, and directories sorted by name
+	want := "single.conf,10-base.yml,20-auths.yaml,30-extra.json"
+	if strings.Join(got, ",") != want {
+		t.Errorf("ReadConfigSources() = %v, want %s", got, want)
+	}
+
+	if _, err = ReadConfigSources([]string{filepath.Join(dir, "missing")}); err == nil {
+		t.Errorf("ReadConfigSources() error = nil, want it for missing paths")
+	}
+}
+
+func TestParseConfigSources(t *testing.T) {
+	source := func(path, content string) SourceT {
+		return SourceT{Path: path, Content: []byte(content)}
+	}
+
+	auths := source("auths.yaml", `
+authorizations:
+  - name: token
+    type: MATCH
+    param:
+      type: QUERY
+      name: token
+    match:
+      pattern: ".+"
+`)
+	requirements := source("requirements.yaml", `
+requestAuthRequirements:
+  - name: token
+    type: all
+    authorizations: [token]
+`)
+	base := source("base.yaml", `
+logLevel: info
+port: "8000"
+reloadInterval: 30s
+modifiers:
+  - type: PATH
+    path:
+      pattern: "^/v1"
+response:
+  denied:
+    statusCode: 403
+    headers:
+      cache-control: no-store
+  allowed:
+    statusCode: 200
+`)
+
+	tests := []struct {
+		name    string
+		sources []SourceT
+		check   func(t *testing.T, cfg v1alpha2.DoorkeeperConfigT)
+		wantErr string
+	}{
+		{
+			name:    "no sources",
+			wantErr: "no config files found",
+		},
+		{
+			name:    "config split across sources",
+			sources: []SourceT{base, auths, requirements},
+			check: func(t *testing.T, cfg v1alpha2.DoorkeeperConfigT) {
+				if len(cfg.Auths) != 1 || len(cfg.RequestAuthReq) != 1 || cfg.Port != "8000" || cfg.Response.Denied.StatusCode != 403 {
+					t.Errorf("config not merged: %+v", cfg)
+				}
+			},
+		},
+		{
+			name: "later sources override scalars",
+			sources: []SourceT{base, auths, requirements, source("override.yaml", `
+logLevel: debug
+reloadInterval: 1m
+response:
+  denied:
+    statusCode: 401
+    headers:
+      www-authenticate: Token
+`)},
+			check: func(t *testing.T, cfg v1alpha2.DoorkeeperConfigT) {
+				if cfg.LogLevel != "debug" || cfg.Port != "8000" {
+					t.Errorf("logLevel = %s, port = %s, want debug and the base port", cfg.LogLevel, cfg.Port)
+				}
+				if cfg.ReloadInterval != time.Minute {
+					t.Errorf("reload interval = %v, want the later one", cfg.ReloadInterval)
+				}
+				denied := cfg.Response.Denied
+				if denied.StatusCode != 401 || denied.Headers["cache-control"] != "no-store" || denied.Headers["www-authenticate"] != "Token" {
+					t.Errorf("denied response = %+v, want the status overridden and the headers of both", denied)
+				}
+				if cfg.Response.Allowed.StatusCode != 200 {
+					t.Errorf("allowed status code = %d, want the base one", cfg.Response.Allowed.StatusCode)
+				}
+			},
+		},
+		{
+			name: "lists are concatenated",
+			sources: []SourceT{base, auths, requirements, source("more.yaml", `
+authorizations:
+  - name: host
+    type: MATCH
+    param:
+      type: HEADER
+      name: host
+    match:
+      pattern: "^example\\.com$"
+requestAuthRequirements:
+  - name: host
+    type: all
+    authorizations: [host, token]
+modifiers:
+  - type: PATH
+    path:
+      pattern: "^/v2"
+`)},
+			check: func(t *testing.T, cfg v1alpha2.DoorkeeperConfigT) {
+				if len(cfg.Auths) != 2 || cfg.Auths[0].Name != "token" || cfg.Auths[1].Name != "host" {
+					t.Errorf("authorizations = %+v, want both in order", cfg.Auths)
+				}
+				if len(cfg.RequestAuthReq) != 2 {
+					t.Errorf("requirements = %+v, want both", cfg.RequestAuthReq)
+				}
+				if len(cfg.Modifiers) != 2 || cfg.Modifiers[0].Path.Pattern != "^/v1" || cfg.Modifiers[1].Path.Pattern != "^/v2" {
+					t.Errorf("modifiers = %+v, want both in order", cfg.Modifiers)
+				}
+			},
+		},
+		{
+			name:    "duplicated authorization",
+			sources: []SourceT{base, auths, requirements, source("copy.yaml", string(auths.Content))},
+			wantErr: "authorization 'token' in 'copy.yaml' already defined in 'auths.yaml'",
+		},
+		{
+			name:    "duplicated requirement",
+			sources: []SourceT{base, auths, requirements, source("copy.yaml", string(requirements.Content))},
+			wantErr: "request auth requirement 'token' in 'copy.yaml' already defined in 'requirements.yaml'",
+		},
+		{
+			name:    "requirements without authorizations",
+			sources: []SourceT{base, requirements},
+			wantErr: "no authorizations defined",
+		},
+		{
+			name:    "unknown field reports the source",
+			sources: []SourceT{base, source("typo.yaml", "authorizatons: []\n")},
+			wantErr: "unable to decode 'typo.yaml'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := ParseConfigSources(tt.sources)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseConfigSources() error = %v, want it containing '%s'", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestParsePartialConfigSources(t *testing.T) {
+	cfg, err := ParsePartialConfigSources([]SourceT{{Path: "base.yaml", Content: []byte(`
+kubernetes:
+  enabled: true
+response:
+  denied:
+    statusCode: 403
+  allowed:
+    statusCode: 200
+`)}})
+	if err != nil {
+		t.Fatalf("ParsePartialConfigSources() error = %v, want none without authorizations", err)
+	}
+
+	if !cfg.Kubernetes.Enabled {
+		t.Errorf("kubernetes config not merged")
+	}
+}
@@ -0,0 +1,197 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"doorkeeper/api/v1alpha2"
+	"doorkeeper/internal/audit"
+	"doorkeeper/internal/clientip"
+	"doorkeeper/internal/geoip"
+	"doorkeeper/internal/hmac"
+	"doorkeeper/internal/iplist"
+	"doorkeeper/internal/logger"
+	"doorkeeper/internal/redaction"
+	"doorkeeper/internal/requestid"
+	"doorkeeper/internal/schedule"
+	"doorkeeper/internal/servertls"
+	"doorkeeper/internal/signedcookie"
+	"doorkeeper/internal/utils"
+)
+
+const (
+	jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+	durationPattern = `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+)
+
+var (
+	// schemaEnums relates the config fields, identified by '<struct-type>.<field>',
+	// with the values allowed for them
+	schemaEnums = map[string][]string{
+		"ModifierConfigT.Type":            modifierTypes,
+		"AuthorizationConfigT.Type":       authTypes,
+		"AuthParamConfigT.Type":           authParamTypes,
+		"HmacConfigT.Type":                authHmacTypes,
+		"HmacConfigT.EncryptionAlgorithm": authHmacAlgorithms,
+		"HmacUrlConfigT.From":             authHmacUrlFroms,
+		"HmacConfigT.Compatibility":       hmac.Compatibilities,
+		"HmacConfigT.KeyEncoding":         hmac.KeyEncodings,
+		"HmacTokenConfigT.DigestEncoding": hmac.DigestEncodings,
+		"RedactionConfigT.Mode":           redaction.Modes,
+		"RequestIdConfigT.Generator":      requestid.Generators,
+		"LogsConfigT.Format":              logger.Formats,
+		"AuditConfigT.Sink":               audit.Sinks,
+		"TlsConfigT.MinVersion":           servertls.Versions,
+		"DecisionLogConfigT.Fields":       utils.DecisionLogFields,
+		"RequestAuthReqT.Type":            requirementTypes,
+		"ClientIpConfigT.Sources":         clientip.Sources,
+		"IpListUrlConfigT.Format":         iplist.Formats,
+		"GeoIpConfigT.Continents":         geoip.Continents,
+		"ScheduleWindowConfigT.Days":      schedule.Days,
+		"MatchConditionConfigT.Source":    authMatchSources,
+		"MatchConditionConfigT.Operator":  authMatchOperators,
+		"SignedCookieConfigT.Algorithm":   signedcookie.Algorithms,
+		"SignedCookieConfigT.KeyEncoding": hmac.KeyEncodings,
+	}
+)
+
+type jsonSchemaT struct {
+	Schema               string                  `json:"$schema,omitempty"`
+	Title                string                  `json:"title,omitempty"`
+	Description          string                  `json:"description,omitempty"`
+	Ref                  string                  `json:"$ref,omitempty"`
+	Type                 string                  `json:"type,omitempty"`
+	Pattern              string                  `json:"pattern,omitempty"`
+	Properties           map[string]*jsonSchemaT `json:"properties,omitempty"`
+	AdditionalProperties any                     `json:"additionalProperties,omitempty"`
+	Items                *jsonSchemaT            `json:"items,omitempty"`
+	Defs                 map[string]*jsonSchemaT `json:"$defs,omitempty"`
+}
+
+// GenerateJSONSchema returns the JSON Schema of the config file, generated
+// from the v1alpha2.DoorkeeperConfigT type
+func GenerateJSONSchema() ([]byte, error) {
+	defs := map[string]*jsonSchemaT{}
+	configType := reflect.TypeOf(v1alpha2.DoorkeeperConfigT{})
+
+	schema := &jsonSchemaT{
+		Schema: jsonSchemaDraft,
+		Title:  "Doorkeeper config (v1alpha2)",
+		Ref:    "#/$defs/" + configType.Name(),
+		Defs:   defs,
+	}
+
+	err := schemaForStruct(configType, defs)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+// schemaForStruct adds the definition of a struct type (and the ones of its nested structs) to defs
+func schemaForStruct(t reflect.Type, defs map[string]*jsonSchemaT) error {
+	if _, ok := defs[t.Name()]; ok {
+		return nil
+	}
+
+	def := &jsonSchemaT{
+		Type:                 "object",
+		Properties:           map[string]*jsonSchemaT{},
+		AdditionalProperties: false,
+	}
+	defs[t.Name()] = def
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		prop, err := schemaForType(field.Type, defs)
+		if err != nil {
+			return fmt.Errorf("field '%s.%s': %s", t.Name(), field.Name, err.Error())
+		}
+
+		if enum, ok := schemaEnums[t.Name()+"."+field.Name]; ok {
+			// in lists, the values of the items are the restricted ones
+			enumProp := prop
+			if prop.Items != nil {
+				enumProp = prop.Items
+			}
+			enumProp.Pattern = caseInsensitivePattern(enum)
+			enumProp.Description = fmt.Sprintf("One of %s (case insensitive)", strings.Join(enum, ", "))
+		}
+
+		def.Properties[name] = prop
+	}
+
+	return nil
+}
+
+func schemaForType(t reflect.Type, defs map[string]*jsonSchemaT) (*jsonSchemaT, error) {
+	// durations are written as strings like '30s' or '1m30s'
+	if t == reflect.TypeOf(time.Duration(0)) {
+		return &jsonSchemaT{Type: "string", Pattern: durationPattern}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &jsonSchemaT{Type: "string"}, nil
+	case reflect.Bool:
+		return &jsonSchemaT{Type: "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &jsonSchemaT{Type: "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchemaT{Type: "number"}, nil
+	case reflect.Slice:
+		items, err := schemaForType(t.Elem(), defs)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonSchemaT{Type: "array", Items: items}, nil
+	case reflect.Map:
+		values, err := schemaForType(t.Elem(), defs)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonSchemaT{Type: "object", AdditionalProperties: values}, nil
+	case reflect.Pointer:
+		return schemaForType(t.Elem(), defs)
+	case reflect.Struct:
+		err := schemaForStruct(t, defs)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonSchemaT{Ref: "#/$defs/" + t.Name()}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported type '%s' in schema", t.Kind())
+}
+
+// caseInsensitivePattern builds a regular expression matching any of the values ignoring its case.
+// JSON Schema patterns do not support flags, so every letter is expanded to a class like [Aa]
+func caseInsensitivePattern(values []string) string {
+	alternatives := []string{}
+	for _, value := range values {
+		var alternative strings.Builder
+		for _, char := range value {
+			if unicode.IsLetter(char) {
+				alternative.WriteString(fmt.Sprintf("[%c%c]", unicode.ToUpper(char), unicode.ToLower(char)))
+				continue
+			}
+			alternative.WriteString(regexp.QuoteMeta(string(char)))
+		}
+		alternatives = append(alternatives, alternative.String())
+	}
+
+	return fmt.Sprintf("^(%s)$", strings.Join(alternatives, "|"))
+}
@@ -0,0 +1,314 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"doorkeeper/api/v1alpha2"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	configFileExtensions = []string{".yaml", ".yml", ".json"}
+)
+
+// SourceT is the expanded content of one of the files the config is split across
+type SourceT struct {
+	Path    string
+	Content []byte
+}
+
+// listConfigFiles expands the directories in paths to the config files inside them,
+// sorted by name. Hidden entries are ignored, as the ones created by Kubernetes
+// when mounting ConfigMaps (e.g. '..data')
+func listConfigFiles(paths []string) (files []string, err error) {
+	for _, pathv := range paths {
+		var info os.FileInfo
+		info, err = os.Stat(pathv)
+		if err != nil {
+			return files, err
+		}
+
+		if !info.IsDir() {
+			files = append(files, pathv)
+			continue
+		}
+
+		var entries []os.DirEntry
+		entries, err = os.ReadDir(pathv)
+		if err != nil {
+			return files, err
+		}
+
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), ".") ||
+				!slices.Contains(configFileExtensions, strings.ToLower(filepath.Ext(entry.Name()))) {
+				continue
+			}
+
+			// stat the entry to follow symlinks
+			entryPath := filepath.Join(pathv, entry.Name())
+			info, err = os.Stat(entryPath)
+			if err != nil {
+				return files, err
+			}
+
+			if !info.IsDir() {
+				files = append(files, entryPath)
+			}
+		}
+	}
+
+	return files, err
+}
+
+// ReadConfigSources reads the config files in the given paths, in order.
+// Directories are expanded to the YAML and JSON files inside them
+func ReadConfigSources(paths []string) (sources []SourceT, err error) {
+	files, err := listConfigFiles(paths)
+	if err != nil {
+		return sources, err
+	}
+
+	for _, filev := range files {
+		var content []byte
+		content, err = ReadConfigFile(filev)
+		if err != nil {
+			return sources, err
+		}
+
+		sources = append(sources, SourceT{Path: filev, Content: content})
+	}
+
+	return sources, err
+}
+
+// MergeConfigSources decodes the sources and merges them into a single config, without checking it.
+// Lists are concatenated in order, failing on duplicated names, and the scalar fields
+// set in a source override the ones set in previous sources
+func MergeConfigSources(sources []SourceT) (config v1alpha2.DoorkeeperConfigT, err error) {
+	authSources := map[string]string{}
+	reqSources := map[string]string{}
+	for _, sourcev := range sources {
+		var partial v1alpha2.DoorkeeperConfigT
+		err = decodeStrict(sourcev.Content, &partial)
+		if err != nil {
+			return config, fmt.Errorf("unable to decode '%s': %s", sourcev.Path, err.Error())
+		}
+
+		for _, authv := range partial.Auths {
+			if previous, ok := authSources[authv.Name]; ok {
+				return config, fmt.Errorf("authorization '%s' in '%s' already defined in '%s'", authv.Name, sourcev.Path, previous)
+			}
+			authSources[authv.Name] = sourcev.Path
+		}
+
+		for _, reqv := range partial.RequestAuthReq {
+			if previous, ok := reqSources[reqv.Name]; ok {
+				return config, fmt.Errorf("request auth requirement '%s' in '%s' already defined in '%s'", reqv.Name, sourcev.Path, previous)
+			}
+			reqSources[reqv.Name] = sourcev.Path
+		}
+
+		mergeConfig(&config, partial)
+	}
+
+	return config, err
+}
+
+// ParseConfigSources merges the sources into a single config and checks it
+func ParseConfigSources(sources []SourceT) (config v1alpha2.DoorkeeperConfigT, err error) {
+	if len(sources) <= 0 {
+		return config, fmt.Errorf("no config files found")
+	}
+
+	config, err = MergeConfigSources(sources)
+	if err != nil {
+		return config, err
+	}
+
+	err = checkConfig(&config)
+
+	return config, err
+}
+
+// ParsePartialConfigSources merges the sources into a single config and checks it,
+// without requiring authorizations nor requirements
+func ParsePartialConfigSources(sources []SourceT) (config v1alpha2.DoorkeeperConfigT, err error) {
+	config, err = MergeConfigSources(sources)
+	if err != nil {
+		return config, err
+	}
+
+	err = checkPartialConfig(&config)
+
+	return config, err
+}
+
+func mergeConfig(dst *v1alpha2.DoorkeeperConfigT, src v1alpha2.DoorkeeperConfigT) {
+	if src.LogLevel != "" {
+		dst.LogLevel = src.LogLevel
+	}
+
+	if src.Address != "" {
+		dst.Address = src.Address
+	}
+
+	if src.Port != "" {
+		dst.Port = src.Port
+	}
+
+	if src.ReloadInterval != 0 {
+		dst.ReloadInterval = src.ReloadInterval
+	}
+
+	if len(src.ClientIp.Sources) > 0 {
+		dst.ClientIp.Sources = src.ClientIp.Sources
+	}
+
+	if len(src.ClientIp.TrustedNetworks) > 0 {
+		dst.ClientIp.TrustedNetworks = src.ClientIp.TrustedNetworks
+	}
+
+	if src.ClientIp.TrustedHops != 0 {
+		dst.ClientIp.TrustedHops = src.ClientIp.TrustedHops
+	}
+
+	if src.Kubernetes.Enabled {
+		dst.Kubernetes = src.Kubernetes
+	}
+
+	if src.Security.DisallowWeakHmacAlgorithms {
+		dst.Security.DisallowWeakHmacAlgorithms = true
+	}
+
+	if src.Logs.Format != "" {
+		dst.Logs.Format = src.Logs.Format
+	}
+
+	if len(src.Logs.Decision.Fields) > 0 {
+		dst.Logs.Decision.Fields = src.Logs.Decision.Fields
+	}
+
+	if src.Logs.Decision.Sampling.Allowed != nil {
+		dst.Logs.Decision.Sampling.Allowed = src.Logs.Decision.Sampling.Allowed
+	}
+
+	if src.Logs.Decision.Sampling.Denied != nil {
+		dst.Logs.Decision.Sampling.Denied = src.Logs.Decision.Sampling.Denied
+	}
+
+	if src.Logs.Redaction.Mode != "" {
+		dst.Logs.Redaction.Mode = src.Logs.Redaction.Mode
+	}
+
+	if src.Audit.Sink != "" {
+		dst.Audit = src.Audit
+	}
+
+	if src.Tls.CertFile != "" {
+		dst.Tls = src.Tls
+	}
+
+	if src.Server.ReadTimeout != 0 {
+		dst.Server.ReadTimeout = src.Server.ReadTimeout
+	}
+
+	if src.Server.ReadHeaderTimeout != 0 {
+		dst.Server.ReadHeaderTimeout = src.Server.ReadHeaderTimeout
+	}
+
+	if src.Server.WriteTimeout != 0 {
+		dst.Server.WriteTimeout = src.Server.WriteTimeout
+	}
+
+	if src.Server.IdleTimeout != 0 {
+		dst.Server.IdleTimeout = src.Server.IdleTimeout
+	}
+
+	if src.Server.MaxHeaderBytes != 0 {
+		dst.Server.MaxHeaderBytes = src.Server.MaxHeaderBytes
+	}
+
+	if src.Server.MaxConcurrentRequests != 0 {
+		dst.Server.MaxConcurrentRequests = src.Server.MaxConcurrentRequests
+	}
+
+	if src.Server.Shutdown.Delay != 0 {
+		dst.Server.Shutdown.Delay = src.Server.Shutdown.Delay
+	}
+
+	if src.Server.Shutdown.DrainTimeout != 0 {
+		dst.Server.Shutdown.DrainTimeout = src.Server.Shutdown.DrainTimeout
+	}
+
+	if len(src.RequestId.Headers) > 0 {
+		dst.RequestId.Headers = src.RequestId.Headers
+	}
+
+	if src.RequestId.ResponseHeader != "" {
+		dst.RequestId.ResponseHeader = src.RequestId.ResponseHeader
+	}
+
+	if src.RequestId.Generator != "" {
+		dst.RequestId.Generator = src.RequestId.Generator
+	}
+
+	dst.Logs.Redaction.Headers = append(dst.Logs.Redaction.Headers, src.Logs.Redaction.Headers...)
+	dst.Logs.Redaction.QueryParams = append(dst.Logs.Redaction.QueryParams, src.Logs.Redaction.QueryParams...)
+	dst.Logs.Redaction.HeaderPatterns = append(dst.Logs.Redaction.HeaderPatterns, src.Logs.Redaction.HeaderPatterns...)
+	dst.Logs.Redaction.QueryParamPatterns = append(dst.Logs.Redaction.QueryParamPatterns, src.Logs.Redaction.QueryParamPatterns...)
+
+	dst.Modifiers = append(dst.Modifiers, src.Modifiers...)
+	dst.Auths = append(dst.Auths, src.Auths...)
+	dst.RequestAuthReq = append(dst.RequestAuthReq, src.RequestAuthReq...)
+
+	mergeResponse(&dst.Response.Denied, src.Response.Denied)
+	mergeResponse(&dst.Response.Allowed, src.Response.Allowed)
+}
+
+func mergeResponse(dst *v1alpha2.ResponseT, src v1alpha2.ResponseT) {
+	if src.StatusCode != 0 {
+		dst.StatusCode = src.StatusCode
+	}
+
+	if src.Body != "" {
+		dst.Body = src.Body
+	}
+
+	for hk, hv := range src.Headers {
+		if dst.Headers == nil {
+			dst.Headers = map[string]string{}
+		}
+		dst.Headers[hk] = hv
+	}
+}
+
+// PolicySource decodes the spec of a DoorkeeperPolicy resource, failing on unknown fields,
+// and returns it as one more source to merge into the config.
+// Modifiers are rejected unless allowed, as they change every request
+func PolicySource(name string, spec []byte, allowModifiers bool) (source SourceT, err error) {
+	source.Path = name
+
+	var policy v1alpha2.DoorkeeperPolicySpecT
+	err = decodeStrict(spec, &policy)
+	if err != nil {
+		return source, fmt.Errorf("unable to decode '%s': %s", name, err.Error())
+	}
+
+	if len(policy.Modifiers) > 0 && !allowModifiers {
+		return source, fmt.Errorf("modifiers are not allowed in '%s', unless kubernetes.allowModifiers is set", name)
+	}
+
+	source.Content, err = yaml.Marshal(v1alpha2.DoorkeeperConfigT{
+		Modifiers:      policy.Modifiers,
+		Auths:          policy.Auths,
+		RequestAuthReq: policy.RequestAuthReq,
+	})
+
+	return source, err
+}
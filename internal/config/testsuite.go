@@ -0,0 +1,109 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"doorkeeper/api/v1alpha2"
+	"doorkeeper/internal/reasons"
+)
+
+const (
+	// Test suites decisions
+
+	TestDecisionALLOW = "allow"
+	TestDecisionDENY  = "deny"
+)
+
+var (
+	testDecisions = []string{TestDecisionALLOW, TestDecisionDENY}
+)
+
+// checkTestSuite validates the test cases and fills the defaults of the requests
+func checkTestSuite(suite *v1alpha2.TestSuiteT) error {
+	if suite.Now != "" {
+		if _, err := ParseTestSuiteTime(suite.Now); err != nil {
+			return err
+		}
+	}
+
+	if len(suite.Tests) <= 0 {
+		return fmt.Errorf("no tests defined")
+	}
+
+	for testi, testv := range suite.Tests {
+		if testv.Name == "" {
+			return fmt.Errorf("test name must be set")
+		}
+
+		testv.Expect.Decision = strings.ToLower(testv.Expect.Decision)
+		suite.Tests[testi].Expect.Decision = testv.Expect.Decision
+
+		if !slices.Contains(testDecisions, testv.Expect.Decision) {
+			return fmt.Errorf("expected decision in test '%s' must be one of %v", testv.Name, testDecisions)
+		}
+
+		testv.Expect.Reason = strings.ToLower(testv.Expect.Reason)
+		suite.Tests[testi].Expect.Reason = testv.Expect.Reason
+
+		if testv.Expect.Reason != "" && !slices.Contains(reasons.Reasons, testv.Expect.Reason) {
+			return fmt.Errorf("expected reason in test '%s' must be one of %v", testv.Name, reasons.Reasons)
+		}
+
+		if testv.Expect.StatusCode != 0 && (testv.Expect.StatusCode < 100 || testv.Expect.StatusCode > 599) {
+			return fmt.Errorf("expected status code in test '%s' must be a valid status code (from 100 to 599)", testv.Name)
+		}
+
+		if testv.Request.Method == "" {
+			suite.Tests[testi].Request.Method = "GET"
+		}
+
+		if testv.Request.Path == "" {
+			suite.Tests[testi].Request.Path = "/"
+		}
+	}
+
+	return nil
+}
+
+// ParseTestSuiteTime parses the fixed time used as clock for a test suite.
+// Both RFC3339 dates and unix timestamps are accepted
+func ParseTestSuiteTime(value string) (t time.Time, err error) {
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+
+	t, err = time.Parse(time.RFC3339, value)
+	if err != nil {
+		err = fmt.Errorf("invalid time '%s', it must be a RFC3339 date or a unix timestamp", value)
+	}
+
+	return t, err
+}
+
+// ParseTestSuiteFile reads a YAML (or JSON) file with test cases to run against a config
+func ParseTestSuiteFile(filepath string) (suite v1alpha2.TestSuiteT, err error) {
+	var fileBytes []byte
+	fileBytes, err = os.ReadFile(filepath)
+	if err != nil {
+		return suite, err
+	}
+
+	fileBytes, err = expandReferences(fileBytes)
+	if err != nil {
+		return suite, fmt.Errorf("unable to expand test suite file '%s': %s", filepath, err.Error())
+	}
+
+	err = decodeStrict(fileBytes, &suite)
+	if err != nil {
+		return suite, fmt.Errorf("unable to decode test suite file '%s': %s", filepath, err.Error())
+	}
+
+	err = checkTestSuite(&suite)
+
+	return suite, err
+}
@@ -0,0 +1,125 @@
+package doorkeeper
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"time"
+
+	"doorkeeper/api/v1alpha2"
+	"doorkeeper/internal/clientip"
+	"doorkeeper/internal/logger"
+	"doorkeeper/internal/redaction"
+	"doorkeeper/internal/utils"
+)
+
+// decisionLogT sets the line logged once per request with its decision
+type decisionLogT struct {
+	fields []string
+
+	sampleAllowed float64
+	sampleDenied  float64
+}
+
+// decisionEntryT is the data of a request logged in its decision line
+type decisionEntryT struct {
+	start time.Time
+	extra logger.ExtraFieldsT
+}
+
+func newDecisionLog(cfg v1alpha2.DecisionLogConfigT) (l decisionLogT) {
+	l = decisionLogT{
+		fields:        cfg.Fields,
+		sampleAllowed: 1,
+		sampleDenied:  1,
+	}
+
+	if len(l.fields) == 0 {
+		l.fields = utils.DefaultDecisionLogFields
+	}
+
+	if cfg.Sampling.Allowed != nil {
+		l.sampleAllowed = *cfg.Sampling.Allowed
+	}
+
+	if cfg.Sampling.Denied != nil {
+		l.sampleDenied = *cfg.Sampling.Denied
+	}
+
+	return l
+}
+
+func (l *decisionLogT) has(field string) bool {
+	return slices.Contains(l.fields, field)
+}
+
+// newEntry takes the fields of the request before modifying it.
+// Headers and query are only copied when they are logged
+func (l *decisionLogT) newEntry(r *http.Request, requestID string, redactor *redaction.RedactorT) (e decisionEntryT) {
+	e = decisionEntryT{
+		start: time.Now(),
+		extra: utils.GetDefaultLogFields(),
+	}
+
+	values := map[string]any{
+		utils.LogFieldKeyRequestID: requestID,
+		utils.LogFieldKeyMethod:    r.Method,
+		utils.LogFieldKeyHost:      r.Host,
+		utils.LogFieldKeyPath:      redactor.RedactPath(r.URL.Path),
+		utils.LogFieldKeyUserAgent: r.UserAgent(),
+	}
+
+	if l.has(utils.LogFieldKeyQuery) || l.has(utils.LogFieldKeyHeaders) {
+		reqLog := redactor.RequestLogStruct(r)
+		values[utils.LogFieldKeyQuery] = reqLog.QueryParams
+		values[utils.LogFieldKeyHeaders] = reqLog.Headers
+	}
+
+	for key, value := range values {
+		if l.has(key) {
+			e.extra.Set(key, value)
+		}
+	}
+
+	return e
+}
+
+// setModified takes the fields of the request once modified and checked
+func (l *decisionLogT) setModified(e *decisionEntryT, r *http.Request, redactor *redaction.RedactorT) {
+	if l.has(utils.LogFieldKeyRequestMod) {
+		e.extra.Set(utils.LogFieldKeyRequestMod, redactor.RequestLogStruct(r))
+	}
+
+	if l.has(utils.LogFieldKeyClientIp) {
+		if ip, err := clientip.FromRequest(r); err == nil {
+			e.extra.Set(utils.LogFieldKeyClientIp, ip.String())
+		}
+	}
+}
+
+// log writes the decision line, unless it is left out by the sampling
+func (l *decisionLogT) log(log logger.LoggerT, e decisionEntryT, decision, reason string, response responseT) {
+	rate := l.sampleAllowed
+	if decision == decisionDENY {
+		rate = l.sampleDenied
+	}
+
+	if rate < 1 && rand.Float64() >= rate {
+		return
+	}
+
+	values := map[string]any{
+		utils.LogFieldKeyDecision:   decision,
+		utils.LogFieldKeyReason:     reason,
+		utils.LogFieldKeyStatusCode: response.Code,
+		utils.LogFieldKeyDurationMs: float64(time.Since(e.start).Microseconds()) / 1000,
+	}
+
+	for key, value := range values {
+		if l.has(key) && value != "" {
+			e.extra.Set(key, value)
+		}
+	}
+
+	log.Info("request decided", e.extra)
+}
@@ -0,0 +1,141 @@
+package doorkeeper
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"doorkeeper/api/v1alpha2"
+	"doorkeeper/internal/logger"
+	"doorkeeper/internal/redaction"
+	"doorkeeper/internal/utils"
+)
+
+// decideLine logs the decision over the request and returns the fields of the line, or nil when not logged
+func decideLine(t *testing.T, cfg v1alpha2.DecisionLogConfigT, target, decision string) map[string]any {
+	t.Helper()
+
+	redactor, err := redaction.NewRedactor(v1alpha2.RedactionConfigT{}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	output := &bytes.Buffer{}
+	log := logger.NewWriterLogger(output, logger.INFO, logger.FormatJSON)
+
+	l := newDecisionLog(cfg)
+	r := httptest.NewRequest("GET", target, nil)
+	r.Header.Set("User-Agent", "player/1.0")
+	r.Header.Set("Authorization", "Bearer secret")
+
+	entry := l.newEntry(r, "req-1", redactor)
+	l.setModified(&entry, r, redactor)
+	l.log(log, entry, decision, "", responseT{Code: 200})
+
+	if output.Len() == 0 {
+		return nil
+	}
+
+	line := struct {
+		Extra map[string]any `json:"extra"`
+	}{}
+	if err = json.Unmarshal(output.Bytes(), &line); err != nil {
+		t.Fatalf("invalid line %q: %v", output.String(), err)
+	}
+
+	return line.Extra
+}
+
+func TestDecisionLogFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  []string
+		want    []string
+		missing []string
+	}{
+		{
+			name:    "default fields",
+			want:    []string{utils.LogFieldKeyRequestID, utils.LogFieldKeyDecision, utils.LogFieldKeyPath, utils.LogFieldKeyDurationMs},
+			missing: []string{utils.LogFieldKeyQuery, utils.LogFieldKeyHeaders, utils.LogFieldKeyUserAgent, utils.LogFieldKeyRequestMod},
+		},
+		{
+			name:    "chosen fields",
+			fields:  []string{utils.LogFieldKeyDecision, utils.LogFieldKeyQuery, utils.LogFieldKeyHeaders, utils.LogFieldKeyUserAgent},
+			want:    []string{utils.LogFieldKeyDecision, utils.LogFieldKeyQuery, utils.LogFieldKeyHeaders, utils.LogFieldKeyUserAgent},
+			missing: []string{utils.LogFieldKeyRequestID, utils.LogFieldKeyPath, utils.LogFieldKeyDurationMs, utils.LogFieldKeyReason},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			extra := decideLine(t, v1alpha2.DecisionLogConfigT{Fields: tt.fields}, "/video.mp4?token=secret&a=1", decisionALLOW)
+			if extra == nil {
+				t.Fatalf("decision not logged")
+			}
+
+			for _, fieldv := range tt.want {
+				if _, ok := extra[fieldv]; !ok {
+					t.Errorf("field '%s' not logged in %v", fieldv, extra)
+				}
+			}
+
+			for _, fieldv := range tt.missing {
+				if _, ok := extra[fieldv]; ok {
+					t.Errorf("field '%s' logged in %v", fieldv, extra)
+				}
+			}
+
+			// sensitive values are redacted in the fields chosen
+			if strings.Contains(toString(extra), "secret") {
+				t.Errorf("sensitive values logged in %v", extra)
+			}
+		})
+	}
+}
+
+func TestDecisionLogSampling(t *testing.T) {
+	none, all := 0.0, 1.0
+
+	tests := []struct {
+		name     string
+		sampling v1alpha2.DecisionLogSamplingConfigT
+		decision string
+		want     bool
+	}{
+		{name: "allowed by default", decision: decisionALLOW, want: true},
+		{name: "denied by default", decision: decisionDENY, want: true},
+		{name: "allowed left out", sampling: v1alpha2.DecisionLogSamplingConfigT{Allowed: &none}, decision: decisionALLOW},
+		{name: "denied kept", sampling: v1alpha2.DecisionLogSamplingConfigT{Allowed: &none, Denied: &all}, decision: decisionDENY, want: true},
+		{name: "denied left out", sampling: v1alpha2.DecisionLogSamplingConfigT{Denied: &none}, decision: decisionDENY},
+		{name: "allowed kept", sampling: v1alpha2.DecisionLogSamplingConfigT{Denied: &none}, decision: decisionALLOW, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			extra := decideLine(t, v1alpha2.DecisionLogConfigT{Sampling: tt.sampling}, "/video.mp4", tt.decision)
+			if logged := extra != nil; logged != tt.want {
+				t.Errorf("decision logged = %v, want %v", logged, tt.want)
+			}
+		})
+	}
+
+	// ratios between 0 and 1 keep part of the lines
+	half := 0.5
+	logged := 0
+	for range 1000 {
+		if decideLine(t, v1alpha2.DecisionLogConfigT{Sampling: v1alpha2.DecisionLogSamplingConfigT{Allowed: &half}}, "/", decisionALLOW) != nil {
+			logged++
+		}
+	}
+
+	if logged < 350 || logged > 650 {
+		t.Errorf("lines logged with 0.5 sampling = %d of 1000", logged)
+	}
+}
+
+func toString(value any) string {
+	content, _ := json.Marshal(value)
+	return string(content)
+}
@@ -1,102 +1,197 @@
 package doorkeeper
 
 import (
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"net/http"
-	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	//
 
-	"doorkeeper/internal/authorizations"
+	"doorkeeper/api/v1alpha2"
+	"doorkeeper/internal/audit"
+	"doorkeeper/internal/clientip"
+	"doorkeeper/internal/clock"
 	"doorkeeper/internal/config"
+	"doorkeeper/internal/identity"
+	"doorkeeper/internal/kubernetes"
 	"doorkeeper/internal/logger"
-	"doorkeeper/internal/modifiers"
+	"doorkeeper/internal/metrics"
+	"doorkeeper/internal/servertls"
 	"doorkeeper/internal/utils"
 )
 
+const (
+	decisionALLOW = "allow"
+	decisionDENY  = "deny"
+
+	defaultReadTimeout  = 10 * time.Second
+	defaultWriteTimeout = 10 * time.Second
+	defaultIdleTimeout  = 30 * time.Second
+	defaultDrainTimeout = 20 * time.Second
+)
+
+var (
+	requestsDecided = metrics.NewCounterVec("doorkeeper_requests_total",
+		"Requests decided, by decision and reason of the denials", "decision", "reason")
+	requestsRejected = metrics.NewCounterVec("doorkeeper_requests_rejected_total",
+		"Requests rejected without deciding them, as the max concurrent requests were in flight")
+)
+
 type DoorkeeperT struct {
 	log logger.LoggerT
 
 	server *http.Server
+	tls    *servertls.ReloaderT
 
-	mods         []modifiers.ModifierI
-	auths        map[string]authorizations.AuthI
-	requirements []requirementT
+	// draining is set on shutdown, failing the readiness checks
+	draining atomic.Bool
+	// policiesLoaded is set once the policies are synced and merged, as every
+	// request would be denied before. Readiness checks fail meanwhile
+	policiesLoaded atomic.Bool
 
-	allowed       responseT
-	denied        responseT
-	internalError responseT
-}
+	shutdown v1alpha2.ServerShutdownConfigT
 
-type requirementT struct {
-	Name           string
-	Type           string
-	Authorizations []string
+	configPaths    []string
+	configHash     [sha256.Size]byte
+	reloadInterval time.Duration
+	stop           chan struct{}
+	stopOnce       sync.Once
+
+	// rebuildMutex serializes the rebuilds of the pipeline from baseSources and policies
+	rebuildMutex sync.Mutex
+	baseSources  []config.SourceT
+	policies     *kubernetes.PolicyWatcherT
+
+	// statusMutex serializes the updates of the status of the policies, done out of the rebuilds
+	statusMutex sync.Mutex
+
+	logFormat string
+	audit     audit.SinkI
+
+	pipeline      atomic.Pointer[pipelineT]
+	internalError responseT
 }
 
 type responseT struct {
 	Code    int         `json:"code"`
 	Headers http.Header `json:"headers"`
 	Body    []byte      `json:"body"`
+
+	// hasReason is set when the reason placeholder is present in the headers or the body
+	hasReason bool
 }
 
-func NewDoorkeeper(filepath string) (d *DoorkeeperT, err error) {
-	d = &DoorkeeperT{}
-	cfg, err := config.ParseConfigFile(filepath)
+// NewDoorkeeper creates the server from the config in the given paths.
+// The config can be split across several files and directories
+func NewDoorkeeper(paths []string) (d *DoorkeeperT, err error) {
+	d = &DoorkeeperT{
+		configPaths: paths,
+		stop:        make(chan struct{}),
+	}
+
+	sources, err := config.ReadConfigSources(paths)
 	if err != nil {
 		return d, err
 	}
 
-	for _, modv := range cfg.Modifiers {
-		mod, err := modifiers.GetModifier(modv)
+	cfg, err := config.MergeConfigSources(sources)
+	if err != nil {
+		return d, err
+	}
+
+	// Policies are merged into the config once they are synced, meanwhile
+	// the pipeline is built only from the config files
+	if cfg.Kubernetes.Enabled {
+		d.policies, err = kubernetes.NewPolicyWatcher(cfg.Kubernetes)
 		if err != nil {
 			return d, err
 		}
+	}
 
-		d.mods = append(d.mods, mod)
+	cfg, err = d.parseSources(sources)
+	if err != nil {
+		return d, err
 	}
 
-	// Set responses
-	d.allowed = newResponse(cfg.Response.Allowed.StatusCode, cfg.Response.Allowed.Headers, []byte(cfg.Response.Allowed.Body))
-	d.denied = newResponse(cfg.Response.Denied.StatusCode, cfg.Response.Denied.Headers, []byte(cfg.Response.Denied.Body))
-	d.internalError = newResponse(
-		http.StatusInternalServerError,
-		map[string]string{},
-		[]byte(fmt.Sprintf("%d %s", http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))),
-	)
+	p, err := newPipeline(cfg)
+	if err != nil {
+		return d, err
+	}
+	d.pipeline.Store(p)
+	d.baseSources = sources
+	d.configHash = hashSources(sources)
+	d.reloadInterval = cfg.ReloadInterval
 
-	// Set auth
-	d.auths = make(map[string]authorizations.AuthI)
-	for _, authv := range cfg.Auths {
-		d.auths[authv.Name], err = authorizations.GetAuthorization(authv)
-		if err != nil {
-			return d, err
-		}
+	d.internalError = newInternalErrorResponse()
+
+	// like the address, TLS settings need a restart, but the certificates are reloaded on changes
+	d.tls, err = servertls.NewReloader(cfg.Tls)
+	if err != nil {
+		return d, err
 	}
 
-	for _, rv := range cfg.RequestAuthReq {
-		req := requirementT{
-			Name: rv.Name,
-			Type: rv.Type,
-		}
-		req.Authorizations = append(req.Authorizations, rv.Authorizations...)
+	// client certificates are checked before taking a slot, so clients without them can not exhaust the slots
+	handler := d.handleRequest
+	if cfg.Server.MaxConcurrentRequests > 0 {
+		handler = limitConcurrency(handler, cfg.Server.MaxConcurrentRequests)
+	}
 
-		d.requirements = append(d.requirements, req)
+	// client certificates are only required by the auth endpoint, so probes keep working.
+	// Scrapers can be required to have them too
+	metricsHandler := metrics.Handler
+	if d.tls != nil {
+		handler = d.tls.RequireClientCert(handler)
+		if cfg.Tls.MetricsClientCert {
+			metricsHandler = d.tls.RequireClientCert(metricsHandler)
+		}
 	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", d.handleRequest)
+	mux.HandleFunc("/", handler)
 	mux.HandleFunc("/healthz", getHealthz)
+	mux.HandleFunc("/readyz", d.getReadyz)
+	mux.HandleFunc("/metrics", metricsHandler)
 	d.server = &http.Server{
-		Addr:         fmt.Sprintf("%s:%s", cfg.Address, cfg.Port),
-		Handler:      mux,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  30 * time.Second,
+		Addr:              fmt.Sprintf("%s:%s", cfg.Address, cfg.Port),
+		Handler:           mux,
+		ReadTimeout:       defaultReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      defaultWriteTimeout,
+		IdleTimeout:       defaultIdleTimeout,
+		MaxHeaderBytes:    cfg.Server.MaxHeaderBytes,
 	}
 
-	d.log = logger.NewLogger(logger.GetLevel(cfg.LogLevel))
+	if cfg.Server.ReadTimeout > 0 {
+		d.server.ReadTimeout = cfg.Server.ReadTimeout
+	}
+
+	if cfg.Server.WriteTimeout > 0 {
+		d.server.WriteTimeout = cfg.Server.WriteTimeout
+	}
+
+	if cfg.Server.IdleTimeout > 0 {
+		d.server.IdleTimeout = cfg.Server.IdleTimeout
+	}
+
+	d.shutdown = cfg.Server.Shutdown
+	if d.shutdown.DrainTimeout == 0 {
+		d.shutdown.DrainTimeout = defaultDrainTimeout
+	}
+
+	if d.tls != nil {
+		d.server.TLSConfig = d.tls.Config()
+	}
+
+	d.logFormat = cfg.Logs.Format
+	d.log = logger.NewLogger(logger.GetLevel(cfg.LogLevel), d.logFormat)
+
+	// the sink is kept across reloads, so changes in it need a restart
+	d.audit, err = audit.NewSink(cfg.Audit)
 	return d, err
 }
 
@@ -109,13 +204,69 @@ func getHealthz(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(http.StatusText(http.StatusOK)))
 }
 
+// getReadyz fails until the Kubernetes policies are loaded, when enabled, and once the
+// server is shutting down, so Kubernetes only routes requests to it meanwhile
+func (d *DoorkeeperT) getReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if d.draining.Load() || (d.policies != nil && !d.policiesLoaded.Load()) {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(http.StatusText(http.StatusOK)))
+}
+
+// limitConcurrency wraps the handler to reject the requests over the max in flight,
+// so the decisions keep being fast under load instead of queueing
+func limitConcurrency(next http.HandlerFunc, max int) http.HandlerFunc {
+	slots := make(chan struct{}, max)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case slots <- struct{}{}:
+			{
+				defer func() { <-slots }()
+				next(w, r)
+			}
+		default:
+			{
+				requestsRejected.Inc()
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			}
+		}
+	}
+}
+
 func (d *DoorkeeperT) handleRequest(w http.ResponseWriter, r *http.Request) {
+	p := d.pipeline.Load()
+
+	// the id is sent back, so the logs of the proxy in front can be joined with ours
+	requestID := p.requestIDs.FromRequest(r)
+	w.Header().Set(p.requestIDs.ResponseHeader(), requestID)
+
 	logFields := utils.GetDefaultLogFields()
-	logFields.Set(utils.LogFieldKeyRequestID, utils.RequestID(r))
+	logFields.Set(utils.LogFieldKeyRequestID, requestID)
+
+	// requests are logged once decided, in a single line
+	entry := p.decisionLog.newEntry(r, requestID, p.redactor)
+
+	// the audit trail keeps the request as received, before modifying it
+	record := audit.RecordT{
+		RequestID: requestID,
+		Method:    r.Method,
+		Host:      r.Host,
+		Path:      p.redactor.RedactPath(r.URL.Path),
+	}
 
 	// Set default denied response values
 	var err error = nil
-	var response responseT = d.denied
+	var response responseT = p.denied
+	decision, reason := decisionDENY, ""
 
 	defer func() {
 		if err != nil {
@@ -123,90 +274,147 @@ func (d *DoorkeeperT) handleRequest(w http.ResponseWriter, r *http.Request) {
 			response = d.internalError
 		}
 
-		logFields.Set(utils.LogFieldKeyResponse, response)
+		p.decisionLog.log(d.log, entry, decision, reason, response)
+		d.writeAudit(record, decision, reason, logFields)
+		reportOutcome(r, decision, reason)
 
 		n, err := sendResponse(w, response)
 		if err != nil {
+			logFields.Set(utils.LogFieldKeyResponse, response)
 			logFields.Set(utils.LogFieldKeyError, fmt.Sprintf("only %d bytes were delivered: %s", n, err.Error()))
 			d.log.Error("error in send response", logFields)
 			return
 		}
 	}()
 
-	logFields.Set(utils.LogFieldKeyRequest, utils.RequestLogStruct(r))
+	// whole requests are only logged for debugging, as they are expensive
+	debug := d.log.Enabled(logger.DEBUG)
+	if debug {
+		logFields.Set(utils.LogFieldKeyRequest, p.redactor.RequestLogStruct(r))
+	}
 
 	// Apply modifiers to the request
-	for modi := range d.mods {
-		d.mods[modi].Apply(r)
+	p.applyModifiers(r)
+	r = p.resolveClientIP(r)
+	r = identity.NewContext(r)
+
+	if debug {
+		logFields.Set(utils.LogFieldKeyRequestMod, p.redactor.RequestLogStruct(r))
+		d.log.Debug("handle request", logFields)
+		logFields.Del(utils.LogFieldKeyRequest)
+		logFields.Del(utils.LogFieldKeyRequestMod)
 	}
 
-	logFields.Set(utils.LogFieldKeyRequestMod, utils.RequestLogStruct(r))
-	d.log.Info("handle request", logFields)
-	logFields.Del(utils.LogFieldKeyRequest)
+	allowed, reason, requirements := p.checkRequirements(r, d.log, logFields)
+	p.decisionLog.setModified(&entry, r, p.redactor)
 
-	for _, reqv := range d.requirements {
-		logFields.Set(utils.LogFieldKeyRequirement, reqv.Name)
-
-		reqResults := []bool{}
-		for _, authn := range reqv.Authorizations {
-			logFields.Set(utils.LogFieldKeyAuthorization, authn)
+	record.Requirements = requirements
+	record.Identities = identity.FromRequest(r)
+	if ip, err := clientip.FromRequest(r); err == nil {
+		record.ClientIp = ip.String()
+	}
+	if !allowed {
+		requestsDecided.Inc(decisionDENY, reason)
 
-			err = d.auths[authn].Check(r)
-			if err != nil {
-				logFields.Set(utils.LogFieldKeyError, err.Error())
-				d.log.Debug("error in authorization check", logFields)
-				logFields.Del(utils.LogFieldKeyError)
+		response = response.withReason(reason)
+		return
+	}
+	requestsDecided.Inc(decisionALLOW, "")
 
-				reqResults = append(reqResults, false)
-				err = nil
-				continue
-			}
+	// Set allowed response values
+	response = p.allowed
+	decision, reason = decisionALLOW, ""
+}
 
-			d.log.Debug("success in authorization check", logFields)
-			reqResults = append(reqResults, true)
-		}
-		logFields.Del(utils.LogFieldKeyAuthorization)
-		logFields.Del(utils.LogFieldKeyRequirement)
+// writeAudit sends the record of the decision to the audit sink, when it is enabled
+func (d *DoorkeeperT) writeAudit(record audit.RecordT, decision, reason string, logFields logger.ExtraFieldsT) {
+	if d.audit == nil {
+		return
+	}
 
-		invalid := slices.Contains(reqResults, false) // result with ConfigTypeValueRequirementALL type by default
-		if reqv.Type == config.ConfigTypeValueRequirementANY {
-			invalid = !slices.Contains(reqResults, true)
-		}
+	record.Time = clock.Now().UTC()
+	record.Decision = decision
+	record.Reason = reason
 
-		if invalid {
-			logFields.Set(utils.LogFieldKeyResponse, response)
-			d.log.Info("denied request", logFields)
-			return
-		}
+	err := d.audit.Write(record)
+	if err != nil {
+		logFields.Set(utils.LogFieldKeyError, err.Error())
+		d.log.Error("error in audit sink", logFields)
+		logFields.Del(utils.LogFieldKeyError)
 	}
-	logFields.Del(utils.LogFieldKeyRequirement)
-
-	// Set allowed response values
-	response = d.allowed
+}
 
-	logFields.Set(utils.LogFieldKeyResponse, response)
-	d.log.Info("allowed request", logFields)
+// SetLogLevel changes the verbosity of the logs emitted by Doorkeeper
+func (d *DoorkeeperT) SetLogLevel(level logger.LevelT) {
+	d.log.SetLevel(level)
 }
 
 func (d *DoorkeeperT) Run() {
 	logFields := utils.GetDefaultLogFields()
 
-	d.log.Info("starting HTTP server", logFields)
-	err := d.server.ListenAndServe()
-	if err != nil {
+	if d.reloadInterval > 0 {
+		go d.watchConfig()
+	}
+
+	go d.watchResources()
+
+	if d.policies != nil {
+		go d.watchPolicies()
+	}
+
+	var err error
+	if d.tls != nil {
+		d.log.Info("starting HTTPS server", logFields)
+		err = d.server.ListenAndServeTLS("", "")
+	} else {
+		d.log.Info("starting HTTP server", logFields)
+		err = d.server.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		logFields.Set(utils.LogFieldKeyError, err.Error())
 		d.log.Error("server failed", logFields)
 	}
 }
 
+// Stop drains the server and closes the audit sink. Further calls do nothing
 func (d *DoorkeeperT) Stop() {
+	d.stopOnce.Do(d.stopServer)
+}
+
+func (d *DoorkeeperT) stopServer() {
 	logFields := utils.GetDefaultLogFields()
 
-	err := d.server.Close()
+	close(d.stop)
+
+	// readiness fails first, giving the proxies time to stop sending requests before draining
+	d.draining.Store(true)
+	if d.shutdown.Delay > 0 {
+		d.log.Info("HTTP server not ready, waiting before draining", logFields)
+		time.Sleep(d.shutdown.Delay)
+	}
+
+	d.log.Info("draining HTTP server", logFields)
+	ctx, cancel := context.WithTimeout(context.Background(), d.shutdown.DrainTimeout)
+	defer cancel()
+
+	err := d.server.Shutdown(ctx)
 	if err != nil {
 		logFields.Set(utils.LogFieldKeyError, err.Error())
-		d.log.Error("HTTP server close with error", logFields)
-		return
+		d.log.Error("HTTP server drain with error, closing in-flight requests", logFields)
+		logFields.Del(utils.LogFieldKeyError)
+
+		d.server.Close()
+	}
+
+	// closed once the server is, so the pending records are written
+	// within what is left of the drain timeout
+	if d.audit != nil {
+		err = d.audit.Close(ctx)
+		if err != nil {
+			logFields.Set(utils.LogFieldKeyError, err.Error())
+			d.log.Error("audit sink close with error", logFields)
+			logFields.Del(utils.LogFieldKeyError)
+		}
 	}
 
 	d.log.Info("HTTP server close", logFields)
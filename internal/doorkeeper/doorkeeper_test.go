@@ -0,0 +1,336 @@
+package doorkeeper
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"doorkeeper/internal/audit"
+	"doorkeeper/internal/clock"
+	"doorkeeper/internal/config"
+	"doorkeeper/internal/kubernetes"
+	"doorkeeper/internal/logger"
+	"doorkeeper/internal/utils"
+)
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name           string
+		policies       bool
+		policiesLoaded bool
+		draining       bool
+		want           int
+	}{
+		{name: "ready", want: http.StatusOK},
+		{name: "draining", draining: true, want: http.StatusServiceUnavailable},
+		{name: "policies not loaded", policies: true, want: http.StatusServiceUnavailable},
+		{name: "policies loaded", policies: true, policiesLoaded: true, want: http.StatusOK},
+		{name: "policies loaded while draining", policies: true, policiesLoaded: true, draining: true, want: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDoorkeeper(t, testSuiteConfig)
+			if tt.policies {
+				d.policies = &kubernetes.PolicyWatcherT{}
+			}
+			d.policiesLoaded.Store(tt.policiesLoaded)
+			d.draining.Store(tt.draining)
+
+			recorder := httptest.NewRecorder()
+			d.server.Handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+			if recorder.Code != tt.want {
+				t.Errorf("readyz status = %d, want %d", recorder.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckPolicy(t *testing.T) {
+	base := []config.SourceT{{Path: "doorkeeper.yaml", Content: []byte(testSuiteConfig)}}
+
+	tests := []struct {
+		name    string
+		spec    string
+		wantErr string
+	}{
+		{
+			name: "valid policy",
+			spec: `{"authorizations": [{"name": "audio", "type": "MATCH", "match": {"conditions": [{"source": "PATH", "operator": "PREFIX", "value": "/audio/"}]}}],
+				"requestAuthRequirements": [{"name": "audio", "type": "all", "authorizations": ["audio"]}]}`,
+		},
+		{
+			name:    "unknown authorization",
+			spec:    `{"requestAuthRequirements": [{"name": "audio", "type": "all", "authorizations": ["audio"]}]}`,
+			wantErr: "not found",
+		},
+		{
+			name: "invalid cidr only found building it",
+			spec: `{"authorizations": [{"name": "office", "type": "IPLIST", "param": {"type": "CLIENT_IP"}, "ipList": {"cidrs": ["not-a-cidr"]}}],
+				"requestAuthRequirements": [{"name": "office", "type": "all", "authorizations": ["office"]}]}`,
+			wantErr: "unable to build the authorizations",
+		},
+		{
+			name: "missing cidr file",
+			spec: `{"authorizations": [{"name": "office", "type": "IPLIST", "param": {"type": "CLIENT_IP"}, "ipList": {"cidrFiles": ["/nonexistent/cidrs.txt"]}}],
+				"requestAuthRequirements": [{"name": "office", "type": "all", "authorizations": ["office"]}]}`,
+			wantErr: "unable to build the authorizations",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := config.PolicySource("DoorkeeperPolicy default/test", []byte(tt.spec), false)
+			if err != nil {
+				t.Fatalf("invalid policy spec: %v", err)
+			}
+
+			p, err := checkPolicy(base, policy)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("checkPolicy() error = %v, want none", err)
+					return
+				}
+				p.close()
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("checkPolicy() error = %v, want it containing '%s'", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuditTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	d := newTestDoorkeeper(t, testSuiteConfig+`
+audit:
+  sink: FILE
+  file:
+    path: `+path+`
+`)
+
+	fixedTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	clock.SetFixed(fixedTime)
+	defer clock.Reset()
+
+	d.server.Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/videos/1.ts", nil))
+	if err := d.audit.Close(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var record audit.RecordT
+	if err = json.Unmarshal(content, &record); err != nil {
+		t.Fatalf("invalid record %q: %v", content, err)
+	}
+
+	if !record.Time.Equal(fixedTime) || record.Decision != decisionALLOW {
+		t.Errorf("record = %+v, want the decision at the time of the clock", record)
+	}
+}
+
+func TestClientCertificateEndpoints(t *testing.T) {
+	certFile, keyFile, caFile := writeTestCertificates(t, t.TempDir())
+	tlsConfig := func(metricsClientCert bool) string {
+		return fmt.Sprintf(`
+tls:
+  certFile: %s
+  keyFile: %s
+  clientCaFile: %s
+  metricsClientCert: %v
+server:
+  maxConcurrentRequests: 1
+`, certFile, keyFile, caFile, metricsClientCert)
+	}
+
+	tests := []struct {
+		name              string
+		metricsClientCert bool
+		path              string
+		clientCert        bool
+		want              int
+	}{
+		{name: "auth without certificate", path: "/videos/1.ts", want: http.StatusUnauthorized},
+		{name: "auth with certificate", path: "/videos/1.ts", clientCert: true, want: http.StatusOK},
+		{name: "probes without certificate", path: "/healthz", metricsClientCert: true, want: http.StatusOK},
+		{name: "metrics without certificate", path: "/metrics", want: http.StatusOK},
+		{name: "metrics requiring certificate", path: "/metrics", metricsClientCert: true, want: http.StatusUnauthorized},
+		{name: "metrics with certificate", path: "/metrics", metricsClientCert: true, clientCert: true, want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDoorkeeper(t, testSuiteConfig+tlsConfig(tt.metricsClientCert))
+
+			r := httptest.NewRequest("GET", tt.path, nil)
+			if tt.clientCert {
+				r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{}}}
+			}
+
+			recorder := httptest.NewRecorder()
+			d.server.Handler.ServeHTTP(recorder, r)
+			if recorder.Code != tt.want {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.want)
+			}
+		})
+	}
+}
+
+// blockingWriterT holds the request writing the response until released
+type blockingWriterT struct {
+	*httptest.ResponseRecorder
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (w *blockingWriterT) Write(content []byte) (int, error) {
+	close(w.writing)
+	<-w.release
+	return w.ResponseRecorder.Write(content)
+}
+
+func TestClientCertificateBeforeConcurrencyLimit(t *testing.T) {
+	certFile, keyFile, caFile := writeTestCertificates(t, t.TempDir())
+	d := newTestDoorkeeper(t, testSuiteConfig+`
+tls:
+  certFile: `+certFile+`
+  keyFile: `+keyFile+`
+  clientCaFile: `+caFile+`
+server:
+  maxConcurrentRequests: 1
+`)
+
+	// a request with certificate takes the only slot
+	blocking := &blockingWriterT{ResponseRecorder: httptest.NewRecorder(), writing: make(chan struct{}), release: make(chan struct{})}
+	withCert := httptest.NewRequest("GET", "/videos/1.ts", nil)
+	withCert.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{}}}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.server.Handler.ServeHTTP(blocking, withCert)
+	}()
+	<-blocking.writing
+
+	recorder := httptest.NewRecorder()
+	d.server.Handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/videos/1.ts", nil))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("status without certificate = %d, want %d before checking the slots", recorder.Code, http.StatusUnauthorized)
+	}
+
+	recorder = httptest.NewRecorder()
+	d.server.Handler.ServeHTTP(recorder, withCert.Clone(withCert.Context()))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("status with certificate = %d, want %d with the slots taken", recorder.Code, http.StatusServiceUnavailable)
+	}
+
+	close(blocking.release)
+	<-done
+}
+
+func TestStopTwice(t *testing.T) {
+	d := newTestDoorkeeper(t, testSuiteConfig)
+
+	d.Stop()
+	d.Stop()
+
+	select {
+	case <-d.stop:
+	default:
+		t.Errorf("stop channel not closed")
+	}
+
+	if !d.draining.Load() {
+		t.Errorf("server not draining once stopped")
+	}
+}
+
+func TestReloadLogs(t *testing.T) {
+	d := newTestDoorkeeper(t, testSuiteConfig)
+	if d.log.Enabled(logger.WARN) {
+		t.Fatalf("warn lines enabled with error level")
+	}
+
+	err := os.WriteFile(d.configPaths[0], []byte(strings.Replace(testSuiteConfig, "logLevel: error", "logLevel: debug", 1)+`
+logs:
+  format: TEXT
+  decision:
+    fields: [decision]
+`), 0o600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	d.reloadConfig()
+
+	// the level and the decision logs are applied, while the format needs a restart
+	if !d.log.Enabled(logger.DEBUG) {
+		t.Errorf("log level not changed on reload")
+	}
+
+	if fields := d.pipeline.Load().decisionLog.fields; len(fields) != 1 || fields[0] != utils.LogFieldKeyDecision {
+		t.Errorf("decision log fields = %v, want the reloaded ones", fields)
+	}
+
+	if d.logFormat != "" {
+		t.Errorf("log format changed to '%s' without a restart", d.logFormat)
+	}
+}
+
+func TestRequestIdResponseHeader(t *testing.T) {
+	d := newTestDoorkeeper(t, testSuiteConfig+`
+requestId:
+  headers: [x-correlation-id]
+  responseHeader: x-trace
+`)
+
+	tests := []struct {
+		name    string
+		path    string
+		headers map[string]string
+		want    string
+	}{
+		{name: "allowed echoing the id", path: "/videos/1.ts", headers: map[string]string{"x-correlation-id": "abc"}, want: "abc"},
+		{name: "denied echoing the id", path: "/private", headers: map[string]string{"x-correlation-id": "abc"}, want: "abc"},
+		{name: "invalid id replaced", path: "/videos/1.ts", headers: map[string]string{"x-correlation-id": "abc\tdef"}},
+		{name: "generated id", path: "/videos/1.ts"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			for hk, hv := range tt.headers {
+				r.Header.Set(hk, hv)
+			}
+
+			recorder := httptest.NewRecorder()
+			d.server.Handler.ServeHTTP(recorder, r)
+
+			got := recorder.Header().Get("x-trace")
+			if tt.want != "" && got != tt.want {
+				t.Errorf("response header = %q, want %q", got, tt.want)
+			}
+			if tt.want == "" && (got == "" || got == tt.headers["x-correlation-id"]) {
+				t.Errorf("response header = %q, want a generated id", got)
+			}
+
+			if recorder.Header().Get("x-request-id") != "" {
+				t.Errorf("id sent in the default header too")
+			}
+		})
+	}
+}
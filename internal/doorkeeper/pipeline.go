@@ -0,0 +1,192 @@
+package doorkeeper
+
+import (
+	"net/http"
+	"slices"
+
+	"doorkeeper/api/v1alpha2"
+	"doorkeeper/internal/authorizations"
+	"doorkeeper/internal/clientip"
+	"doorkeeper/internal/config"
+	"doorkeeper/internal/logger"
+	"doorkeeper/internal/metrics"
+	"doorkeeper/internal/modifiers"
+	"doorkeeper/internal/reasons"
+	"doorkeeper/internal/redaction"
+	"doorkeeper/internal/requestid"
+	"doorkeeper/internal/utils"
+)
+
+var (
+	authorizationDenials = metrics.NewCounterVec("doorkeeper_authorization_denials_total",
+		"Authorization checks failed, by authorization and reason", "authorization", "reason")
+)
+
+// pipelineT holds everything built from a config to decide over the requests.
+// It is replaced as a whole when the config is reloaded
+type pipelineT struct {
+	clientIP *clientip.ResolverT
+	redactor *redaction.RedactorT
+
+	requestIDs  *requestid.ResolverT
+	decisionLog decisionLogT
+
+	mods         []modifiers.ModifierI
+	auths        map[string]authorizations.AuthI
+	requirements []requirementT
+
+	allowed responseT
+	denied  responseT
+}
+
+type requirementT struct {
+	Name           string
+	Type           string
+	Authorizations []string
+}
+
+func newPipeline(cfg v1alpha2.DoorkeeperConfigT) (p *pipelineT, err error) {
+	p = &pipelineT{}
+
+	p.clientIP, err = clientip.NewResolver(cfg.ClientIp)
+	if err != nil {
+		return p, err
+	}
+
+	p.requestIDs, err = requestid.NewResolver(cfg.RequestId)
+	if err != nil {
+		return p, err
+	}
+
+	p.decisionLog = newDecisionLog(cfg.Logs.Decision)
+
+	for _, modv := range cfg.Modifiers {
+		mod, err := modifiers.GetModifier(modv)
+		if err != nil {
+			return p, err
+		}
+
+		p.mods = append(p.mods, mod)
+	}
+
+	// Set responses
+	p.allowed = newResponse(cfg.Response.Allowed.StatusCode, cfg.Response.Allowed.Headers, []byte(cfg.Response.Allowed.Body))
+	p.denied = newResponse(cfg.Response.Denied.StatusCode, cfg.Response.Denied.Headers, []byte(cfg.Response.Denied.Body))
+
+	// Set auth
+	p.auths = make(map[string]authorizations.AuthI)
+	for _, authv := range cfg.Auths {
+		p.auths[authv.Name], err = authorizations.GetAuthorization(authv)
+		if err != nil {
+			p.close()
+			return p, err
+		}
+	}
+
+	// tokens taken from the path by the authorizations are redacted from the logged paths
+	pathTokens := []redaction.PathTokenI{}
+	for _, authv := range p.auths {
+		if pathToken, ok := authv.(redaction.PathTokenI); ok {
+			pathTokens = append(pathTokens, pathToken)
+		}
+	}
+
+	p.redactor, err = redaction.NewRedactor(cfg.Logs.Redaction, pathTokens)
+	if err != nil {
+		p.close()
+		return p, err
+	}
+
+	for _, rv := range cfg.RequestAuthReq {
+		req := requirementT{
+			Name: rv.Name,
+			Type: rv.Type,
+		}
+		req.Authorizations = append(req.Authorizations, rv.Authorizations...)
+
+		p.requirements = append(p.requirements, req)
+	}
+
+	return p, err
+}
+
+// close releases the resources shared by the authorizations, once the pipeline is replaced
+// or discarded. Requests still using it keep working with the resources loaded
+func (p *pipelineT) close() {
+	for _, authv := range p.auths {
+		if closer, ok := authv.(authorizations.CloserI); ok {
+			closer.Close()
+		}
+	}
+}
+
+func (p *pipelineT) applyModifiers(r *http.Request) {
+	for modi := range p.mods {
+		p.mods[modi].Apply(r)
+	}
+}
+
+// resolveClientIP returns the request with the ip of the client resolved,
+// once, to be used by any authorization and the logs
+func (p *pipelineT) resolveClientIP(r *http.Request) *http.Request {
+	ip, err := p.clientIP.Resolve(r)
+	return clientip.NewContext(r, ip, err)
+}
+
+// checkRequirements evaluates the request against all the request auth requirements
+// and returns whether the request is allowed. Denied requests get the reason of the
+// first authorization failed in the requirement not satisfied.
+// The requirements returned are the ones satisfied, or the one not satisfied when denied
+func (p *pipelineT) checkRequirements(r *http.Request, log logger.LoggerT, logFields logger.ExtraFieldsT) (allowed bool, reason string, requirements []string) {
+	// nothing can be authorized without requirements. It happens in Kubernetes mode
+	// while no policy is defined, and the config files do not define requirements
+	if len(p.requirements) == 0 {
+		return false, reasons.NoRequirements, nil
+	}
+
+	for _, reqv := range p.requirements {
+		logFields.Set(utils.LogFieldKeyRequirement, reqv.Name)
+
+		reqResults := []bool{}
+		reqReason := ""
+		for _, authn := range reqv.Authorizations {
+			logFields.Set(utils.LogFieldKeyAuthorization, authn)
+
+			err := p.auths[authn].Check(r)
+			if err != nil {
+				authReason := reasons.Get(err)
+				authorizationDenials.Inc(authn, authReason)
+				if reqReason == "" {
+					reqReason = authReason
+				}
+
+				logFields.Set(utils.LogFieldKeyReason, authReason)
+				logFields.Set(utils.LogFieldKeyError, err.Error())
+				log.Debug("error in authorization check", logFields)
+				logFields.Del(utils.LogFieldKeyError)
+				logFields.Del(utils.LogFieldKeyReason)
+
+				reqResults = append(reqResults, false)
+				continue
+			}
+
+			log.Debug("success in authorization check", logFields)
+			reqResults = append(reqResults, true)
+		}
+		logFields.Del(utils.LogFieldKeyAuthorization)
+		logFields.Del(utils.LogFieldKeyRequirement)
+
+		invalid := slices.Contains(reqResults, false) // result with ConfigTypeValueRequirementALL type by default
+		if reqv.Type == config.ConfigTypeValueRequirementANY {
+			invalid = !slices.Contains(reqResults, true)
+		}
+
+		if invalid {
+			return false, reqReason, []string{reqv.Name}
+		}
+
+		requirements = append(requirements, reqv.Name)
+	}
+
+	return true, reason, requirements
+}
@@ -0,0 +1,126 @@
+package doorkeeper
+
+import (
+	"fmt"
+
+	"doorkeeper/internal/config"
+	"doorkeeper/internal/kubernetes"
+	"doorkeeper/internal/utils"
+)
+
+// watchPolicies rebuilds the pipeline every time the DoorkeeperPolicy resources change
+func (d *DoorkeeperT) watchPolicies() {
+	logFields := utils.GetDefaultLogFields()
+	logFields.Set(utils.LogFieldKeyNamespace, d.policies.Namespace())
+
+	d.log.Info("waiting for kubernetes policies to be synced", logFields)
+	err := d.policies.Start(d.stop)
+	if err != nil {
+		logFields.Set(utils.LogFieldKeyError, err.Error())
+		d.log.Error("unable to sync kubernetes policies", logFields)
+		return
+	}
+
+	for {
+		_, statuses, err := d.rebuildPipeline()
+		d.reportPolicies(statuses)
+		if err != nil {
+			logFields.Set(utils.LogFieldKeyError, err.Error())
+			d.log.Error("invalid config with kubernetes policies, keeping the current one", logFields)
+			logFields.Del(utils.LogFieldKeyError)
+		} else {
+			// readiness waits for the policies to be merged, as every request would be denied before
+			d.policiesLoaded.Store(true)
+			d.log.Info("kubernetes policies loaded", logFields)
+		}
+
+		select {
+		case <-d.stop:
+			return
+		case <-d.policies.Changes():
+		}
+	}
+}
+
+// policyStatusT is the result of validating a policy, reported in its status
+type policyStatusT struct {
+	policy kubernetes.PolicyT
+	err    error
+}
+
+// mergePolicies appends to the sources the policies that are valid with them, in order,
+// returning the result of the validation of every policy to report it in their status.
+// Policies must be valid by themselves, referencing only authorizations defined
+// in the config files or in the same policy.
+// The pipeline built to validate the last policy accepted is returned too, holding the shared
+// resources of the merged authorizations until the caller builds its own and closes it
+func (d *DoorkeeperT) mergePolicies(sources []config.SourceT) ([]config.SourceT, []policyStatusT, *pipelineT) {
+	logFields := utils.GetDefaultLogFields()
+
+	var candidate *pipelineT
+	statuses := []policyStatusT{}
+	for _, policy := range d.policies.Policies() {
+		err := policy.Err
+		if err == nil {
+			var p *pipelineT
+			p, err = checkPolicy(sources, policy.Source)
+			if err == nil {
+				if candidate != nil {
+					candidate.close()
+				}
+				candidate = p
+			}
+		}
+
+		if err == nil {
+			sources = append(sources, policy.Source)
+		}
+
+		if err != nil {
+			logFields.Set(utils.LogFieldKeyPolicy, policy.Namespace+"/"+policy.Name)
+			logFields.Set(utils.LogFieldKeyError, err.Error())
+			d.log.Warn("policy rejected", logFields)
+			logFields.Del(utils.LogFieldKeyError)
+		}
+
+		statuses = append(statuses, policyStatusT{policy: policy, err: err})
+	}
+
+	return sources, statuses, candidate
+}
+
+// checkPolicy returns whether the policy is valid with the sources, building its authorizations
+// too, as some errors are only found then (e.g. unreadable CIDR files or GeoIP databases).
+// The pipeline built must be closed by the caller
+func checkPolicy(sources []config.SourceT, policy config.SourceT) (p *pipelineT, err error) {
+	candidate := append(append([]config.SourceT{}, sources...), policy)
+	cfg, err := config.ParseConfigSources(candidate)
+	if err != nil {
+		return p, err
+	}
+
+	p, err = newPipeline(cfg)
+	if err != nil {
+		return p, fmt.Errorf("unable to build the authorizations: %s", err.Error())
+	}
+
+	return p, nil
+}
+
+// reportPolicies updates the status of the policies with the result of their validation.
+// It is called out of the rebuilds, as every update can take seconds
+func (d *DoorkeeperT) reportPolicies(statuses []policyStatusT) {
+	d.statusMutex.Lock()
+	defer d.statusMutex.Unlock()
+
+	logFields := utils.GetDefaultLogFields()
+
+	for _, statusv := range statuses {
+		err := d.policies.SetStatus(statusv.policy, statusv.err)
+		if err != nil {
+			logFields.Set(utils.LogFieldKeyPolicy, statusv.policy.Namespace+"/"+statusv.policy.Name)
+			logFields.Set(utils.LogFieldKeyError, err.Error())
+			d.log.Error("unable to update policy status", logFields)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package doorkeeper
+
+import (
+	"crypto/sha256"
+	"time"
+
+	"doorkeeper/api/v1alpha2"
+	"doorkeeper/internal/authorizations"
+	"doorkeeper/internal/config"
+	"doorkeeper/internal/logger"
+	"doorkeeper/internal/utils"
+)
+
+const (
+	resourcesReloadInterval = 10 * time.Second
+)
+
+// watchConfig reloads the pipeline periodically when the content of the config changes.
+// Content is compared after expanding the references, so changes in referenced
+// files (e.g. Kubernetes Secrets mounted as files) trigger a reload too
+func (d *DoorkeeperT) watchConfig() {
+	ticker := time.NewTicker(d.reloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			d.reloadConfig()
+		}
+	}
+}
+
+func (d *DoorkeeperT) reloadConfig() {
+	logFields := utils.GetDefaultLogFields()
+
+	sources, err := config.ReadConfigSources(d.configPaths)
+	if err != nil {
+		logFields.Set(utils.LogFieldKeyError, err.Error())
+		d.log.Error("unable to read config, keeping the current one", logFields)
+		return
+	}
+
+	// failing contents are remembered too, to report them only once
+	hash := hashSources(sources)
+	if hash == d.configHash {
+		return
+	}
+	d.configHash = hash
+
+	d.rebuildMutex.Lock()
+	d.baseSources = sources
+	d.rebuildMutex.Unlock()
+
+	cfg, statuses, err := d.rebuildPipeline()
+	if d.policies != nil {
+		d.reportPolicies(statuses)
+	}
+	if err != nil {
+		logFields.Set(utils.LogFieldKeyError, err.Error())
+		d.log.Error("invalid config, keeping the current one", logFields)
+		return
+	}
+
+	// the level is changed in place, while the handler writing the lines is kept
+	d.log.SetLevel(logger.GetLevel(cfg.LogLevel))
+	if logger.ParseFormat(cfg.Logs.Format) != logger.ParseFormat(d.logFormat) {
+		d.log.Warn("logs format can not be changed without a restart, keeping the current one", logFields)
+	}
+
+	d.log.Info("config reloaded", logFields)
+}
+
+// parseSources merges and checks the config sources. Authorizations and requirements
+// are not required when policies come from Kubernetes, as they can be defined there
+func (d *DoorkeeperT) parseSources(sources []config.SourceT) (cfg v1alpha2.DoorkeeperConfigT, err error) {
+	if d.policies != nil {
+		return config.ParsePartialConfigSources(sources)
+	}
+
+	return config.ParseConfigSources(sources)
+}
+
+// rebuildPipeline builds the pipeline again from the config files and the
+// policies, replacing the current one only when the result is valid.
+// The validation of the policies is returned to report it out of the lock
+func (d *DoorkeeperT) rebuildPipeline() (cfg v1alpha2.DoorkeeperConfigT, statuses []policyStatusT, err error) {
+	d.rebuildMutex.Lock()
+	defer d.rebuildMutex.Unlock()
+
+	sources := append([]config.SourceT{}, d.baseSources...)
+	if d.policies != nil {
+		var candidate *pipelineT
+		sources, statuses, candidate = d.mergePolicies(sources)
+		if candidate != nil {
+			defer candidate.close()
+		}
+	}
+
+	cfg, err = d.parseSources(sources)
+	if err != nil {
+		return cfg, statuses, err
+	}
+
+	p, err := newPipeline(cfg)
+	if err != nil {
+		return cfg, statuses, err
+	}
+
+	// the old pipeline is released after the new one took its shared resources
+	if old := d.pipeline.Swap(p); old != nil {
+		old.close()
+	}
+	return cfg, statuses, nil
+}
+
+// hashSources returns a digest of the config sources, including their paths,
+// to detect changes in any of them
+func hashSources(sources []config.SourceT) (hash [sha256.Size]byte) {
+	hasher := sha256.New()
+	for _, sourcev := range sources {
+		hasher.Write([]byte(sourcev.Path))
+		hasher.Write([]byte{0})
+		hasher.Write(sourcev.Content)
+		hasher.Write([]byte{0})
+	}
+	copy(hash[:], hasher.Sum(nil))
+
+	return hash
+}
+
+// watchResources reloads periodically the external resources used by the
+// authorizations of the current pipeline, when they change
+func (d *DoorkeeperT) watchResources() {
+	ticker := time.NewTicker(resourcesReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			d.reloadResources()
+			d.reloadTls()
+		}
+	}
+}
+
+func (d *DoorkeeperT) reloadResources() {
+	logFields := utils.GetDefaultLogFields()
+
+	p := d.pipeline.Load()
+	for authn, authv := range p.auths {
+		reloader, ok := authv.(authorizations.ReloaderI)
+		if !ok {
+			continue
+		}
+
+		logFields.Set(utils.LogFieldKeyAuthorization, authn)
+
+		reloaded, err := reloader.Reload()
+		if err != nil {
+			logFields.Set(utils.LogFieldKeyError, err.Error())
+			d.log.Error("unable to reload authorization resources, keeping the current ones", logFields)
+			logFields.Del(utils.LogFieldKeyError)
+			continue
+		}
+
+		if reloaded {
+			d.log.Info("authorization resources reloaded", logFields)
+		}
+	}
+}
+
+// reloadTls loads the certificates of the listener again when their files change
+func (d *DoorkeeperT) reloadTls() {
+	if d.tls == nil {
+		return
+	}
+
+	logFields := utils.GetDefaultLogFields()
+
+	reloaded, err := d.tls.Reload()
+	if err != nil {
+		logFields.Set(utils.LogFieldKeyError, err.Error())
+		d.log.Error("unable to reload tls certificates, keeping the current ones", logFields)
+		return
+	}
+
+	if reloaded {
+		d.log.Info("tls certificates reloaded", logFields)
+	}
+}
@@ -0,0 +1,175 @@
+package doorkeeper
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"doorkeeper/api/v1alpha2"
+	"doorkeeper/internal/config"
+	"doorkeeper/internal/logger"
+	"doorkeeper/internal/servertls"
+)
+
+type TestResultT struct {
+	Name     string
+	Passed   bool
+	Failures []string
+}
+
+// NewTestDoorkeeper creates from the config in the given paths only what test suites need: the pipeline
+// and the handler. The audit sink, the TLS files and the Kubernetes policies are not loaded, so only the
+// config files are tested, and listeners with mTLS just require test requests to set a client certificate
+func NewTestDoorkeeper(paths []string) (d *DoorkeeperT, err error) {
+	d = &DoorkeeperT{
+		configPaths: paths,
+		stop:        make(chan struct{}),
+	}
+
+	sources, err := config.ReadConfigSources(paths)
+	if err != nil {
+		return d, err
+	}
+
+	cfg, err := config.MergeConfigSources(sources)
+	if err != nil {
+		return d, err
+	}
+
+	// authorizations can be defined only in the policies, which are not loaded
+	if cfg.Kubernetes.Enabled {
+		cfg, err = config.ParsePartialConfigSources(sources)
+	} else {
+		cfg, err = config.ParseConfigSources(sources)
+	}
+	if err != nil {
+		return d, err
+	}
+
+	p, err := newPipeline(cfg)
+	if err != nil {
+		return d, err
+	}
+	d.pipeline.Store(p)
+	d.internalError = newInternalErrorResponse()
+
+	handler := d.handleRequest
+	if cfg.Tls.CertFile != "" && cfg.Tls.ClientCaFile != "" {
+		handler = servertls.RequireVerifiedCert(handler)
+	}
+	d.server = &http.Server{Handler: http.HandlerFunc(handler)}
+
+	d.logFormat = cfg.Logs.Format
+	d.log = logger.NewLogger(logger.GetLevel(cfg.LogLevel), d.logFormat)
+
+	return d, nil
+}
+
+// RunTestSuite sends every test case request through the handler of the server, as received
+// from the proxies, and compares the result with the expected decision and response
+func (d *DoorkeeperT) RunTestSuite(suite v1alpha2.TestSuiteT) (results []TestResultT) {
+	for _, testv := range suite.Tests {
+		results = append(results, d.runTestCase(testv))
+	}
+
+	return results
+}
+
+func (d *DoorkeeperT) runTestCase(test v1alpha2.TestCaseT) (result TestResultT) {
+	result.Name = test.Name
+
+	r, err := newTestRequest(test.Request)
+	if err != nil {
+		result.Failures = append(result.Failures, fmt.Sprintf("invalid request: %s", err.Error()))
+		return result
+	}
+
+	r, outcome := withOutcome(r)
+	recorder := httptest.NewRecorder()
+	d.server.Handler.ServeHTTP(recorder, r)
+
+	// requests rejected before deciding them, e.g. without client certificate, are denied without reason
+	decision, reason := config.TestDecisionDENY, ""
+	if outcome.decided {
+		decision, reason = outcome.decision, outcome.reason
+	}
+
+	if decision != test.Expect.Decision {
+		result.Failures = append(result.Failures,
+			fmt.Sprintf("expected decision '%s', got '%s'", test.Expect.Decision, decision))
+	}
+
+	if test.Expect.Reason != "" && test.Expect.Reason != reason {
+		result.Failures = append(result.Failures,
+			fmt.Sprintf("expected reason '%s', got '%s'", test.Expect.Reason, reason))
+	}
+
+	if test.Expect.StatusCode != 0 && test.Expect.StatusCode != recorder.Code {
+		result.Failures = append(result.Failures,
+			fmt.Sprintf("expected status code %d, got %d", test.Expect.StatusCode, recorder.Code))
+	}
+
+	for hk, hv := range test.Expect.Headers {
+		if got := recorder.Header().Get(hk); got != hv {
+			result.Failures = append(result.Failures,
+				fmt.Sprintf("expected response header '%s' to be '%s', got '%s'", hk, hv, got))
+		}
+	}
+
+	result.Passed = len(result.Failures) == 0
+	return result
+}
+
+type outcomeKeyT struct{}
+
+// outcomeT is the decision taken on a request, reported by the handler to the test suites
+type outcomeT struct {
+	decided  bool
+	decision string
+	reason   string
+}
+
+// withOutcome returns a copy of the request where the handler reports its decision
+func withOutcome(r *http.Request) (*http.Request, *outcomeT) {
+	outcome := &outcomeT{}
+	return r.WithContext(context.WithValue(r.Context(), outcomeKeyT{}, outcome)), outcome
+}
+
+// reportOutcome sets the decision of the request, when it was sent by a test suite
+func reportOutcome(r *http.Request, decision, reason string) {
+	outcome, ok := r.Context().Value(outcomeKeyT{}).(*outcomeT)
+	if !ok {
+		return
+	}
+
+	outcome.decided = true
+	outcome.decision = decision
+	outcome.reason = reason
+}
+
+func newTestRequest(req v1alpha2.TestRequestT) (r *http.Request, err error) {
+	r, err = http.NewRequest(req.Method, req.Path, nil)
+	if err != nil {
+		return r, err
+	}
+	r.RequestURI = req.Path
+	r.Host = req.Host
+	r.RemoteAddr = req.RemoteAddr
+
+	for hk, hv := range req.Headers {
+		r.Header.Set(hk, hv)
+	}
+
+	// the certificate is only checked to be verified, as the TLS handshake already validated it
+	if req.ClientCertificate {
+		r.TLS = &tls.ConnectionState{
+			HandshakeComplete: true,
+			VerifiedChains:    [][]*x509.Certificate{{{}}},
+		}
+	}
+
+	return r, err
+}
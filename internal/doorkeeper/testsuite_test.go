@@ -0,0 +1,219 @@
+package doorkeeper
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"doorkeeper/api/v1alpha2"
+)
+
+// newTestDoorkeeper creates a server from the config content, without running it
+func newTestDoorkeeper(t *testing.T, content string) *DoorkeeperT {
+	t.Helper()
+
+	d, err := NewDoorkeeper([]string{writeTestConfig(t, content)})
+	if err != nil {
+		t.Fatalf("unable to create doorkeeper: %v", err)
+	}
+
+	return d
+}
+
+// newTestSuiteDoorkeeper creates a server from the config content only to run test suites
+func newTestSuiteDoorkeeper(t *testing.T, content string) *DoorkeeperT {
+	t.Helper()
+
+	d, err := NewTestDoorkeeper([]string{writeTestConfig(t, content)})
+	if err != nil {
+		t.Fatalf("unable to create doorkeeper for tests: %v", err)
+	}
+
+	return d
+}
+
+func writeTestConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "doorkeeper.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("unable to write config: %v", err)
+	}
+
+	return path
+}
+
+const testSuiteConfig = `
+logLevel: error
+address: 127.0.0.1
+port: "0"
+authorizations:
+- name: videos
+  type: MATCH
+  match:
+    conditions:
+    - {source: PATH, operator: PREFIX, value: /videos/}
+    - {source: HEADER, name: x-debug, operator: ABSENT}
+requestAuthRequirements:
+- {name: videos, type: all, authorizations: [videos]}
+response:
+  denied: {statusCode: 403, headers: {x-deny-reason: "{{reason}}"}}
+  allowed: {statusCode: 200, headers: {x-allowed: "yes"}}
+`
+
+func TestRunTestSuite(t *testing.T) {
+	d := newTestSuiteDoorkeeper(t, testSuiteConfig)
+
+	tests := []v1alpha2.TestCaseT{
+		{
+			Name:    "allowed",
+			Request: v1alpha2.TestRequestT{Method: "GET", Host: "cdn", Path: "/videos/1.ts"},
+			Expect:  v1alpha2.TestExpectT{Decision: "allow", StatusCode: 200, Headers: map[string]string{"x-allowed": "yes"}},
+		},
+		{
+			Name:    "denied with reason",
+			Request: v1alpha2.TestRequestT{Method: "GET", Host: "cdn", Path: "/audio/1.mp3"},
+			Expect:  v1alpha2.TestExpectT{Decision: "deny", Reason: "no_match", StatusCode: 403, Headers: map[string]string{"x-deny-reason": "no_match"}},
+		},
+		{
+			Name:    "request id sent back",
+			Request: v1alpha2.TestRequestT{Method: "GET", Host: "cdn", Path: "/videos/1.ts", Headers: map[string]string{"x-request-id": "abc"}},
+			Expect:  v1alpha2.TestExpectT{Decision: "allow", Headers: map[string]string{"x-request-id": "abc"}},
+		},
+	}
+
+	for _, resultv := range d.RunTestSuite(v1alpha2.TestSuiteT{Tests: tests}) {
+		if !resultv.Passed {
+			t.Errorf("test case '%s' failed: %v", resultv.Name, resultv.Failures)
+		}
+	}
+
+	// failures are reported, not hidden
+	results := d.RunTestSuite(v1alpha2.TestSuiteT{Tests: []v1alpha2.TestCaseT{{
+		Name:    "wrong expectation",
+		Request: v1alpha2.TestRequestT{Method: "GET", Host: "cdn", Path: "/videos/1.ts", Headers: map[string]string{"x-debug": "1"}},
+		Expect:  v1alpha2.TestExpectT{Decision: "allow"},
+	}}})
+	if len(results) != 1 || results[0].Passed || len(results[0].Failures) != 1 {
+		t.Errorf("expected one failure, got %+v", results)
+	}
+}
+
+func TestRunTestSuiteClientCertificate(t *testing.T) {
+	// certificate files are not loaded to run test suites
+	d := newTestSuiteDoorkeeper(t, testSuiteConfig+`
+tls:
+  certFile: /nonexistent/tls.crt
+  keyFile: /nonexistent/tls.key
+  clientCaFile: /nonexistent/ca.crt
+`)
+
+	tests := []v1alpha2.TestCaseT{
+		{
+			Name:    "without client certificate",
+			Request: v1alpha2.TestRequestT{Method: "GET", Host: "cdn", Path: "/videos/1.ts"},
+			Expect:  v1alpha2.TestExpectT{Decision: "deny", StatusCode: 401},
+		},
+		{
+			Name:    "with client certificate",
+			Request: v1alpha2.TestRequestT{Method: "GET", Host: "cdn", Path: "/videos/1.ts", ClientCertificate: true},
+			Expect:  v1alpha2.TestExpectT{Decision: "allow", StatusCode: 200},
+		},
+	}
+
+	for _, resultv := range d.RunTestSuite(v1alpha2.TestSuiteT{Tests: tests}) {
+		if !resultv.Passed {
+			t.Errorf("test case '%s' failed: %v", resultv.Name, resultv.Failures)
+		}
+	}
+}
+
+func TestNewTestDoorkeeper(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{
+			name:    "audit sink not opened",
+			content: testSuiteConfig + "audit:\n  sink: FILE\n  file:\n    path: /nonexistent/audit.log\n",
+		},
+		{
+			name:    "kubernetes policies not loaded",
+			content: testSuiteConfig + "kubernetes:\n  enabled: true\n  namespace: default\n",
+		},
+		{
+			name:    "invalid config",
+			content: testSuiteConfig + "unknownField: true\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := NewTestDoorkeeper([]string{writeTestConfig(t, tt.content)})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewTestDoorkeeper() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if d.audit != nil || d.policies != nil || d.tls != nil {
+				t.Errorf("audit, policies or tls loaded running test suites")
+			}
+		})
+	}
+}
+
+// writeTestCertificates writes a self-signed certificate, used as server certificate and as client CA
+func writeTestCertificates(t *testing.T, dir string) (certFile, keyFile, caFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unable to create certificate: %v", err)
+	}
+
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("unable to marshal key: %v", err)
+	}
+
+	certFile = filepath.Join(dir, "tls.crt")
+	keyFile = filepath.Join(dir, "tls.key")
+	writePEM := func(path, blockType string, content []byte) {
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: content}), 0o600); err != nil {
+			t.Fatalf("unable to write %s: %v", path, err)
+		}
+	}
+	writePEM(certFile, "CERTIFICATE", cert)
+	writePEM(keyFile, "EC PRIVATE KEY", keyBytes)
+
+	return certFile, keyFile, certFile
+}
@@ -1,8 +1,15 @@
 package doorkeeper
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+)
+
+const (
+	// responseReasonPlaceholder is replaced with the reason of the denial in the bodies and headers of the responses
+	responseReasonPlaceholder = "{{reason}}"
 )
 
 func newResponse(code int, headers map[string]string, body []byte) (resp responseT) {
@@ -11,6 +18,7 @@ func newResponse(code int, headers map[string]string, body []byte) (resp respons
 	resp.Headers = make(http.Header)
 	for k, v := range headers {
 		resp.Headers.Set(k, v)
+		resp.hasReason = resp.hasReason || strings.Contains(v, responseReasonPlaceholder)
 	}
 
 	if body != nil {
@@ -18,10 +26,17 @@ func newResponse(code int, headers map[string]string, body []byte) (resp respons
 		resp.Headers.Set("Content-Length", strconv.Itoa(len(body)))
 
 		resp.Body = body
+		resp.hasReason = resp.hasReason || strings.Contains(string(body), responseReasonPlaceholder)
 	}
 	return resp
 }
 
+// newInternalErrorResponse returns the response sent when the request can not be decided
+func newInternalErrorResponse() responseT {
+	body := fmt.Sprintf("%d %s", http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+	return newResponse(http.StatusInternalServerError, map[string]string{}, []byte(body))
+}
+
 func sendResponse(w http.ResponseWriter, resp responseT) (n int, err error) {
 	for hk, hvs := range resp.Headers {
 		for _, hv := range hvs {
@@ -37,3 +52,26 @@ func sendResponse(w http.ResponseWriter, resp responseT) (n int, err error) {
 
 	return n, err
 }
+
+// withReason returns the response with the reason placeholder replaced.
+// Responses without placeholders are returned as they are
+func (resp responseT) withReason(reason string) responseT {
+	if !resp.hasReason {
+		return resp
+	}
+
+	replaced := resp
+	replaced.Headers = make(http.Header)
+	for hk, hvs := range resp.Headers {
+		for _, hv := range hvs {
+			replaced.Headers.Add(hk, strings.ReplaceAll(hv, responseReasonPlaceholder, reason))
+		}
+	}
+
+	if resp.Body != nil {
+		replaced.Body = []byte(strings.ReplaceAll(string(resp.Body), responseReasonPlaceholder, reason))
+		replaced.Headers.Set("Content-Length", strconv.Itoa(len(replaced.Body)))
+	}
+
+	return replaced
+}
@@ -0,0 +1,85 @@
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+var (
+	// Continents are the codes used by MaxMind databases
+	Continents = []string{"AF", "AN", "AS", "EU", "NA", "OC", "SA"}
+)
+
+// RecordT is the information about an IP merged from all the databases.
+// Country databases provide the country and continent, and ASN databases the ASN
+type RecordT struct {
+	Country   string
+	Continent string
+	Asn       uint
+}
+
+// recordT is decoded from any database, so it contains the fields of all of them
+type recordT struct {
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Continent struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"continent"`
+	AutonomousSystemNumber uint `maxminddb:"autonomous_system_number"`
+}
+
+// DatabasesT is a set of MaxMind databases looked up together
+type DatabasesT struct {
+	readers []*maxminddb.Reader
+}
+
+// Open loads the databases in memory. They are not memory-mapped,
+// so replacing them on disk does not affect the ones in use
+func Open(paths []string) (d *DatabasesT, err error) {
+	d = &DatabasesT{}
+	for _, pathv := range paths {
+		var content []byte
+		content, err = os.ReadFile(pathv)
+		if err != nil {
+			return d, err
+		}
+
+		var reader *maxminddb.Reader
+		reader, err = maxminddb.FromBytes(content)
+		if err != nil {
+			return d, fmt.Errorf("invalid geoip database '%s': %s", pathv, err.Error())
+		}
+		d.readers = append(d.readers, reader)
+	}
+
+	return d, err
+}
+
+// Lookup returns the information about the IP. Fields not found in any database are left empty
+func (d *DatabasesT) Lookup(ip net.IP) (record RecordT, err error) {
+	for _, readerv := range d.readers {
+		var result recordT
+		err = readerv.Lookup(ip, &result)
+		if err != nil {
+			return record, err
+		}
+
+		if result.Country.IsoCode != "" {
+			record.Country = result.Country.IsoCode
+		}
+
+		if result.Continent.Code != "" {
+			record.Continent = result.Continent.Code
+		}
+
+		if result.AutonomousSystemNumber != 0 {
+			record.Asn = result.AutonomousSystemNumber
+		}
+	}
+
+	return record, err
+}
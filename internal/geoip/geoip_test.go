@@ -0,0 +1,149 @@
+package geoip
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// writeDatabase writes a database with the records given by network, of the type of the MaxMind ones
+func writeDatabase(t *testing.T, databaseType string, records map[string]mmdbtype.Map) string {
+	t.Helper()
+
+	tree, err := mmdbwriter.New(mmdbwriter.Options{DatabaseType: databaseType, RecordSize: 24, IncludeReservedNetworks: true})
+	if err != nil {
+		t.Fatalf("unable to create database: %v", err)
+	}
+
+	for cidr, recordv := range records {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("invalid network '%s': %v", cidr, err)
+		}
+
+		if err = tree.Insert(network, recordv); err != nil {
+			t.Fatalf("unable to insert network '%s': %v", cidr, err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), databaseType+".mmdb")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("unable to create database file: %v", err)
+	}
+	defer file.Close()
+
+	if _, err = tree.WriteTo(file); err != nil {
+		t.Fatalf("unable to write database: %v", err)
+	}
+
+	return path
+}
+
+func countryRecord(country, continent string) mmdbtype.Map {
+	return mmdbtype.Map{
+		"country":   mmdbtype.Map{"iso_code": mmdbtype.String(country)},
+		"continent": mmdbtype.Map{"code": mmdbtype.String(continent)},
+	}
+}
+
+func asnRecord(asn uint32) mmdbtype.Map {
+	return mmdbtype.Map{"autonomous_system_number": mmdbtype.Uint32(asn)}
+}
+
+func TestLookup(t *testing.T) {
+	countries := writeDatabase(t, "GeoLite2-Country", map[string]mmdbtype.Map{
+		"192.0.2.0/24":  countryRecord("ES", "EU"),
+		"2001:db8::/32": countryRecord("US", "NA"),
+	})
+	asns := writeDatabase(t, "GeoLite2-ASN", map[string]mmdbtype.Map{
+		"192.0.2.0/25":    asnRecord(64500),
+		"198.51.100.0/24": asnRecord(64501),
+	})
+
+	databases, err := Open([]string{countries, asns})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		ip   string
+		want RecordT
+	}{
+		{name: "merged from both databases", ip: "192.0.2.1", want: RecordT{Country: "ES", Continent: "EU", Asn: 64500}},
+		{name: "only in country database", ip: "192.0.2.200", want: RecordT{Country: "ES", Continent: "EU"}},
+		{name: "only in asn database", ip: "198.51.100.1", want: RecordT{Asn: 64501}},
+		{name: "ipv6", ip: "2001:db8::1", want: RecordT{Country: "US", Continent: "NA"}},
+		{name: "unknown", ip: "203.0.113.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record, err := databases.Lookup(net.ParseIP(tt.ip))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if record != tt.want {
+				t.Errorf("Lookup(%s) = %+v, want %+v", tt.ip, record, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupLaterDatabasesWin(t *testing.T) {
+	first := writeDatabase(t, "First", map[string]mmdbtype.Map{"192.0.2.0/24": countryRecord("ES", "EU")})
+	second := writeDatabase(t, "Second", map[string]mmdbtype.Map{"192.0.2.0/24": countryRecord("PT", "EU")})
+
+	databases, err := Open([]string{first, second})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	record, err := databases.Lookup(net.ParseIP("192.0.2.1"))
+	if err != nil || record.Country != "PT" {
+		t.Errorf("Lookup() = (%+v, %v), want the country of the last database", record, err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	invalid := filepath.Join(t.TempDir(), "invalid.mmdb")
+	if err := os.WriteFile(invalid, []byte("not a database"), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		paths   []string
+		wantErr bool
+	}{
+		{name: "missing file", paths: []string{filepath.Join(t.TempDir(), "missing.mmdb")}, wantErr: true},
+		{name: "invalid file", paths: []string{invalid}, wantErr: true},
+		{name: "valid", paths: []string{writeDatabase(t, "Valid", map[string]mmdbtype.Map{"192.0.2.0/24": asnRecord(1)})}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Open(tt.paths); (err != nil) != tt.wantErr {
+				t.Errorf("Open() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// the database is read in memory, so it keeps working once removed
+	path := writeDatabase(t, "Removed", map[string]mmdbtype.Map{"192.0.2.0/24": asnRecord(64500)})
+	databases, err := Open([]string{path})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	os.Remove(path)
+
+	if record, err := databases.Lookup(net.ParseIP("192.0.2.1")); err != nil || record.Asn != 64500 {
+		t.Errorf("Lookup() = (%+v, %v) once the file is removed", record, err)
+	}
+}
@@ -6,12 +6,36 @@ import (
 	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha512"
+	"encoding/base64"
 	"encoding/hex"
 	"fmt"
 	"hash"
+	"net"
+	"net/url"
 	"strconv"
 	"strings"
-	"time"
+
+	"doorkeeper/internal/clientip"
+	"doorkeeper/internal/clock"
+	"doorkeeper/internal/reasons"
+	"doorkeeper/internal/utils"
+)
+
+const (
+	// CompatibilityAKAMAI validates tokens generated by Akamai EdgeAuth (Token Auth 2.0) tooling
+	CompatibilityAKAMAI = "AKAMAI"
+
+	KeyEncodingHEX       = "hex"
+	KeyEncodingBASE64    = "base64"
+	KeyEncodingBASE64URL = "base64url"
+	KeyEncodingRAW       = "raw"
+
+	DigestEncodingHEX       = "hex"
+	DigestEncodingBASE64    = "base64"
+	DigestEncodingBASE64URL = "base64url"
+
+	// minDigestLength is the shortest a MAC can be truncated to, in bytes (RFC 2104)
+	minDigestLength = 10
 )
 
 var (
@@ -21,16 +45,157 @@ var (
 		"sha256": sha256.New,
 		"sha512": sha512.New,
 	}
+
+	Compatibilities = []string{CompatibilityAKAMAI}
+
+	KeyEncodings = []string{KeyEncodingHEX, KeyEncodingBASE64, KeyEncodingBASE64URL, KeyEncodingRAW}
+
+	DigestEncodings = []string{DigestEncodingHEX, DigestEncodingBASE64, DigestEncodingBASE64URL}
+
+	// DefaultTokenFormat is the grammar of the tokens when it is not configured,
+	// e.g. 'exp=1717300000~acl=/videos/*~hmac=0a1b...'
+	DefaultTokenFormat = TokenFormatT{
+		FieldSeparator: "~",
+		ValueSeparator: "=",
+		AclSeparator:   "!",
+		HmacField:      "hmac",
+		ExpField:       "exp",
+		StField:        "st",
+		IpField:        "ip",
+		AclField:       "acl",
+		UrlField:       "url",
+		IdField:        "id",
+		DigestEncoding: DigestEncodingHEX,
+	}
+
+	// WeakAlgorithms are rejected when the security policy disallows them
+	WeakAlgorithms = []string{"md5", "sha1"}
+
+	// minKeyLengths are the minimum lengths of the keys, in bytes, for each algorithm.
+	// They are half of the digest size, as shorter keys weaken the HMAC below its security strength
+	minKeyLengths = map[string]int{
+		"md5":    8,
+		"sha1":   10,
+		"sha256": 16,
+		"sha512": 32,
+	}
 )
 
-// generateHMAC TODO
-func generateHMAC(tokenDigest, encryptionKey, encryptionAlgorithm string) (token []byte, err error) {
-	// change encryption key to binary
-	binaryEncryptionKey, err := hex.DecodeString(strings.TrimSpace(encryptionKey))
+// TokenConfigT is how tokens are signed and which fields they must have
+type TokenConfigT struct {
+	EncryptionKey       []byte
+	EncryptionAlgorithm string
+	MandatoryFields     []string
+
+	Compatibility string
+	Salt          string
+
+	Format TokenFormatT
+}
+
+// TokenFormatT is the grammar of the tokens. Empty fields take the values of DefaultTokenFormat
+type TokenFormatT struct {
+	FieldSeparator string
+	ValueSeparator string
+	AclSeparator   string
+
+	HmacField string
+	ExpField  string
+	StField   string
+	IpField   string
+	AclField  string
+	UrlField  string
+	IdField   string
+
+	DigestEncoding string
+
+	// DigestLength is the number of bytes the MAC is truncated to. Zero means not truncated
+	DigestLength int
+}
+
+// WithDefaults returns the format with the empty fields set to the default values
+func (f TokenFormatT) WithDefaults() TokenFormatT {
+	setDefault := func(value *string, defaultValue string) {
+		if *value == "" {
+			*value = defaultValue
+		}
+	}
+
+	setDefault(&f.FieldSeparator, DefaultTokenFormat.FieldSeparator)
+	setDefault(&f.ValueSeparator, DefaultTokenFormat.ValueSeparator)
+	setDefault(&f.AclSeparator, DefaultTokenFormat.AclSeparator)
+	setDefault(&f.HmacField, DefaultTokenFormat.HmacField)
+	setDefault(&f.ExpField, DefaultTokenFormat.ExpField)
+	setDefault(&f.StField, DefaultTokenFormat.StField)
+	setDefault(&f.IpField, DefaultTokenFormat.IpField)
+	setDefault(&f.AclField, DefaultTokenFormat.AclField)
+	setDefault(&f.UrlField, DefaultTokenFormat.UrlField)
+	setDefault(&f.IdField, DefaultTokenFormat.IdField)
+	setDefault(&f.DigestEncoding, DefaultTokenFormat.DigestEncoding)
+
+	return f
+}
+
+// CheckDigestLength returns an error when MACs of the algorithm can not be truncated to the length
+func CheckDigestLength(length int, algorithm string) error {
+	hashFunc, ok := encryptionAlgorithmMap[algorithm]
+	if !ok {
+		return fmt.Errorf("invalid encryption algorithm '%s'", algorithm)
+	}
+
+	if size := hashFunc().Size(); length != 0 && (length < minDigestLength || length > size) {
+		return fmt.Errorf("digest length must be between %d and %d bytes for %s", minDigestLength, size, algorithm)
+	}
+
+	return nil
+}
+
+// TokenRequestT is the request a token is checked against
+type TokenRequestT struct {
+	// Url is the value signed when the token has no acl, already encoded as needed
+	Url string
+
+	// Path is checked against the acl of the token
+	Path string
+
+	ClientIp net.IP
+}
+
+// DecodeKey returns the binary key from its encoded form, checking
+// it is long enough for the algorithm
+func DecodeKey(key, encoding, algorithm string) (binaryKey []byte, err error) {
+	switch encoding {
+	case KeyEncodingHEX, "":
+		binaryKey, err = hex.DecodeString(strings.TrimSpace(key))
+	case KeyEncodingBASE64:
+		binaryKey, err = base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	case KeyEncodingBASE64URL:
+		// padding is optional in base64url
+		binaryKey, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(strings.TrimSpace(key), "="))
+	case KeyEncodingRAW:
+		binaryKey = []byte(key)
+	default:
+		err = fmt.Errorf("invalid key encoding '%s'", encoding)
+	}
+
 	if err != nil {
-		return token, err
+		return binaryKey, fmt.Errorf("unable to decode %s key: %s", encoding, err.Error())
 	}
 
+	if len(binaryKey) == 0 {
+		return binaryKey, fmt.Errorf("key must not be empty")
+	}
+
+	if len(binaryKey) < minKeyLengths[algorithm] {
+		return binaryKey, fmt.Errorf("key must be at least %d bytes long for %s, got %d",
+			minKeyLengths[algorithm], algorithm, len(binaryKey))
+	}
+
+	return binaryKey, err
+}
+
+// generateHMAC TODO
+func generateHMAC(tokenDigest string, binaryEncryptionKey []byte, encryptionAlgorithm string, digestLength int) (token []byte, err error) {
 	// check if provided algorithm exist
 	if _, ok := encryptionAlgorithmMap[encryptionAlgorithm]; !ok {
 		err = fmt.Errorf("invalid encryption algorithm '%s'", encryptionAlgorithm)
@@ -43,70 +208,242 @@ func generateHMAC(tokenDigest, encryptionKey, encryptionAlgorithm string) (token
 	if err != nil {
 		return token, err
 	}
-	token = []byte(hex.EncodeToString(hmacHash.Sum(nil)))
+	token = hmacHash.Sum(nil)
+
+	if digestLength > 0 && digestLength < len(token) {
+		token = token[:digestLength]
+	}
 
 	return token, err
 }
 
+// encodeDigest returns the digest as written in tokens
+func encodeDigest(digest []byte, encoding string) string {
+	switch encoding {
+	case DigestEncodingBASE64:
+		return base64.StdEncoding.EncodeToString(digest)
+	case DigestEncodingBASE64URL:
+		return base64.RawURLEncoding.EncodeToString(digest)
+	}
+
+	return hex.EncodeToString(digest)
+}
+
+// decodeDigest returns the digest written in a token. Hex digits can be in
+// any case, and padding is optional in base64url
+func decodeDigest(digest, encoding string) ([]byte, error) {
+	switch encoding {
+	case DigestEncodingBASE64:
+		return base64.StdEncoding.DecodeString(digest)
+	case DigestEncodingBASE64URL:
+		return base64.RawURLEncoding.DecodeString(strings.TrimRight(digest, "="))
+	}
+
+	return hex.DecodeString(digest)
+}
+
 // ValidateToken TODO
 // token: exp={int}~hmac={hash}
-func ValidateTokenUrl(token, encryptionKey, encryptionAlgorithm, url string, mandatoryFields []string) (generatedHmac, receivedHmac string, err error) {
-	tokenFields := map[string]string{}
-	tokenParts := strings.Split(token, "~")
-	for _, fieldv := range tokenParts {
-		fieldParts := strings.SplitN(fieldv, "=", 2)
-		if len(fieldParts) != 2 {
-			continue
-		}
-		tokenFields[fieldParts[0]] = fieldParts[1]
-	}
+// token: exp={int}~acl={path-pattern}[!{path-pattern}...]~hmac={hash}
+// token: [ip={ip-or-cidr}~][st={int}~]exp={int}~hmac={hash}
+// Tokens with 'acl' field sign it instead of the url, so they are valid for any path matching it.
+// Tokens with 'ip' field are only valid for the client IPs in it, and the ones with 'st' field since that time.
+// In Akamai compatibility mode, tokens can also have 'id' and 'data' fields, in any order:
+// token: [ip={ip}~][st={int}~]exp={int}[~acl={path-pattern}][~id={string}][~data={string}]~hmac={hash}
+// Separators, field names and the encoding of the hash can be changed in the format of the config
+func ValidateTokenUrl(token string, cfg TokenConfigT, request TokenRequestT) (generatedHmac, receivedHmac string, err error) {
+	akamai := cfg.Compatibility == CompatibilityAKAMAI
+	format := cfg.Format.WithDefaults()
+
+	tokenFields := parseTokenFields(token, format)
 
-	for _, fv := range mandatoryFields {
+	for _, fv := range cfg.MandatoryFields {
 		if _, ok := tokenFields[fv]; !ok {
-			err = fmt.Errorf("mandatory field '%s' not found in hmac sign", fv)
+			err = reasons.Errorf(reasons.MissingField, "mandatory field '%s' not found in hmac sign", fv)
 			return generatedHmac, receivedHmac, err
 		}
 	}
 
 	// split token to get tokenDigest and HMAC
-	hmacTokenParts := strings.Split(token, "~hmac=")
+	hmacTokenParts := strings.Split(token, format.FieldSeparator+format.HmacField+format.ValueSeparator)
 	if len(hmacTokenParts) != 2 {
-		err = fmt.Errorf("hmac sign without main 'hmac' field")
+		err = reasons.Errorf(reasons.MissingField, "hmac sign without main '%s' field", format.HmacField)
 		return generatedHmac, receivedHmac, err
 	}
-	tokenDigest := fmt.Sprintf("%s~url=%s", hmacTokenParts[0], url)
-	tokenHMAC := []byte(hmacTokenParts[1])
+	tokenDigest := hmacTokenParts[0] + format.FieldSeparator + format.UrlField + format.ValueSeparator + request.Url
+	receivedHmac = hmacTokenParts[1]
+
+	// check the path is covered by the acl
+	if acl, ok := tokenFields[format.AclField]; ok {
+		// akamai tooling escapes the values of the fields when 'escape early' is enabled
+		if akamai {
+			acl = unescapeField(acl)
+		}
+
+		if !matchAcl(acl, format.AclSeparator, request.Path, akamai) {
+			err = reasons.Errorf(reasons.PathNotAllowed, "path '%s' not allowed by hmac sign acl '%s'", request.Path, acl)
+			return generatedHmac, receivedHmac, err
+		}
+		tokenDigest = hmacTokenParts[0]
+	}
+
+	if akamai && cfg.Salt != "" {
+		tokenDigest = tokenDigest + format.FieldSeparator + "salt" + format.ValueSeparator + cfg.Salt
+	}
 
 	// check expiration time
-	expPart, ok := tokenFields["exp"]
+	expPart, ok := tokenFields[format.ExpField]
 	if !ok {
-		err = fmt.Errorf("hmac sign without main 'exp' field")
+		err = reasons.Errorf(reasons.MissingField, "hmac sign without main '%s' field", format.ExpField)
 		return generatedHmac, receivedHmac, err
 	}
 	exp, err := strconv.ParseInt(expPart, 10, 64)
 	if err != nil {
-		err = fmt.Errorf("invalid expiration time format '%s'", expPart)
+		err = reasons.Errorf(reasons.InvalidParam, "invalid expiration time format '%s'", expPart)
 		return generatedHmac, receivedHmac, err
 	}
 
-	if time.Now().Unix() >= exp {
-		err = fmt.Errorf("hmac sign has expired")
+	if clock.Now().Unix() >= exp {
+		err = reasons.Errorf(reasons.Expired, "hmac sign has expired")
+		return generatedHmac, receivedHmac, err
+	}
+
+	err = checkBindingFields(tokenFields, format, request)
+	if err != nil {
 		return generatedHmac, receivedHmac, err
 	}
 
 	// generate HMAC with your local encription key to compare
-	generatedHMAC, err := generateHMAC(tokenDigest, encryptionKey, encryptionAlgorithm)
+	generatedHMAC, err := generateHMAC(tokenDigest, cfg.EncryptionKey, cfg.EncryptionAlgorithm, format.DigestLength)
 	if err != nil {
 		return generatedHmac, receivedHmac, err
 	}
+	generatedHmac = encodeDigest(generatedHMAC, format.DigestEncoding)
 
-	generatedHmac = string(generatedHMAC)
-	receivedHmac = string(tokenHMAC)
+	// compare given with generated HMAC, once decoded so equivalent encodings match
+	receivedHMAC, err := decodeDigest(receivedHmac, format.DigestEncoding)
+	if err != nil {
+		err = reasons.Errorf(reasons.InvalidParam, "hmac sign is not encoded in %s", format.DigestEncoding)
+		return generatedHmac, receivedHmac, err
+	}
 
-	// compare given with generated HMAC
-	if !hmac.Equal(generatedHMAC, tokenHMAC) {
-		err = fmt.Errorf("invalid '%s' sign, result '%s' does not match", receivedHmac, generatedHMAC)
+	if !hmac.Equal(generatedHMAC, receivedHMAC) {
+		err = reasons.Errorf(reasons.BadSignature, "hmac sign does not match")
 	}
 
 	return generatedHmac, receivedHmac, err
 }
+
+// parseTokenFields returns the values of the fields of the token, by name
+func parseTokenFields(token string, format TokenFormatT) map[string]string {
+	tokenFields := map[string]string{}
+	tokenParts := strings.Split(token, format.FieldSeparator)
+	for _, fieldv := range tokenParts {
+		fieldParts := strings.SplitN(fieldv, format.ValueSeparator, 2)
+		if len(fieldParts) != 2 {
+			continue
+		}
+		tokenFields[fieldParts[0]] = fieldParts[1]
+	}
+
+	return tokenFields
+}
+
+// TokenId returns the id field of the token, identifying who it was issued to
+func TokenId(token string, cfg TokenConfigT) string {
+	format := cfg.Format.WithDefaults()
+	id := parseTokenFields(token, format)[format.IdField]
+
+	if cfg.Compatibility == CompatibilityAKAMAI {
+		id = unescapeField(id)
+	}
+
+	return id
+}
+
+// checkBindingFields checks the start time and the client ip of tokens, when they are set
+func checkBindingFields(tokenFields map[string]string, format TokenFormatT, request TokenRequestT) (err error) {
+	if stPart, ok := tokenFields[format.StField]; ok {
+		st, err := strconv.ParseInt(stPart, 10, 64)
+		if err != nil {
+			return reasons.Errorf(reasons.InvalidParam, "invalid start time format '%s'", stPart)
+		}
+
+		if clock.Now().Unix() < st {
+			return reasons.Errorf(reasons.NotYetValid, "hmac sign is not valid yet")
+		}
+	}
+
+	if ipPart, ok := tokenFields[format.IpField]; ok {
+		networks, err := clientip.ParseNetworks([]string{unescapeField(ipPart)})
+		if err != nil {
+			return reasons.Errorf(reasons.InvalidParam, "invalid ip format '%s'", ipPart)
+		}
+
+		if request.ClientIp == nil || !clientip.IsTrusted(request.ClientIp, networks) {
+			return reasons.Errorf(reasons.IpNotAllowed, "hmac sign not valid for client ip '%s'", request.ClientIp)
+		}
+	}
+
+	return nil
+}
+
+// EscapeAkamai escapes a value as Akamai tooling does with 'escape early' enabled,
+// which is the query escaping with lowercase hex digits (e.g. '/' is '%2f')
+func EscapeAkamai(value string) string {
+	escaped := url.QueryEscape(value)
+
+	var builder strings.Builder
+	for i := 0; i < len(escaped); i++ {
+		builder.WriteByte(escaped[i])
+		if escaped[i] == '%' && i+2 < len(escaped) {
+			builder.WriteString(strings.ToLower(escaped[i+1 : i+3]))
+			i += 2
+		}
+	}
+
+	return builder.String()
+}
+
+// unescapeField returns the original value of an escaped field,
+// or the value as it is when it is not escaped
+func unescapeField(value string) string {
+	unescaped, err := url.QueryUnescape(value)
+	if err != nil {
+		return value
+	}
+	return unescaped
+}
+
+// matchAcl returns whether the path matches any of the patterns of the acl, separated by the separator.
+// Patterns with wildcards ('*' or '?') must match the whole path. Others match the same path, or the
+// paths under it as a directory, unless exact is set, as Akamai does
+func matchAcl(acl, separator, path string, exact bool) bool {
+	for _, patternv := range strings.Split(acl, separator) {
+		if patternv == "" {
+			continue
+		}
+
+		if strings.ContainsAny(patternv, "*?") {
+			if utils.MatchWildcard(patternv, path) {
+				return true
+			}
+			continue
+		}
+
+		if path == patternv {
+			return true
+		}
+
+		if exact || !strings.HasPrefix(path, patternv) {
+			continue
+		}
+
+		// prefixes must end on a segment boundary, so '/videos/123' does not cover '/videos/1234'
+		if strings.HasSuffix(patternv, "/") || path[len(patternv)] == '/' {
+			return true
+		}
+	}
+
+	return false
+}
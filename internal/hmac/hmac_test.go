@@ -0,0 +1,515 @@
+package hmac
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"doorkeeper/internal/clock"
+	"doorkeeper/internal/reasons"
+)
+
+// testNow is the time of the clock in the tests validating tokens
+var testNow = time.Unix(1700000000, 0)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+// signToken appends the hmac field to the fields, signing them with the url when it is set
+func signToken(t *testing.T, cfg TokenConfigT, fields, url string) string {
+	t.Helper()
+
+	format := cfg.Format.WithDefaults()
+	digest := fields
+	if url != "" {
+		digest += format.FieldSeparator + format.UrlField + format.ValueSeparator + url
+	}
+	if cfg.Salt != "" {
+		digest += format.FieldSeparator + "salt" + format.ValueSeparator + cfg.Salt
+	}
+
+	mac, err := generateHMAC(digest, cfg.EncryptionKey, cfg.EncryptionAlgorithm, format.DigestLength)
+	if err != nil {
+		t.Fatalf("unable to sign token: %v", err)
+	}
+
+	return fields + format.FieldSeparator + format.HmacField + format.ValueSeparator + encodeDigest(mac, format.DigestEncoding)
+}
+
+// checkReason fails when the error is not the one expected, being no error an empty reason
+func checkReason(t *testing.T, err error, wantReason string) {
+	t.Helper()
+
+	if wantReason == "" {
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		return
+	}
+
+	if err == nil {
+		t.Errorf("expected error with reason '%s', got none", wantReason)
+		return
+	}
+
+	if reason := reasons.Get(err); reason != wantReason {
+		t.Errorf("reason = '%s', want '%s' (error: %v)", reason, wantReason, err)
+	}
+}
+
+func TestMatchAcl(t *testing.T) {
+	tests := []struct {
+		name  string
+		acl   string
+		path  string
+		exact bool
+		want  bool
+	}{
+		{name: "same path", acl: "/videos/123", path: "/videos/123", want: true},
+		{name: "path under directory", acl: "/videos/123", path: "/videos/123/1.ts", want: true},
+		{name: "directory with trailing slash", acl: "/videos/123/", path: "/videos/123/1.ts", want: true},
+		{name: "sibling with same prefix", acl: "/videos/123", path: "/videos/1234/1.ts", want: false},
+		{name: "sibling with suffix", acl: "/videos/123", path: "/videos/123-private", want: false},
+		{name: "parent path", acl: "/videos/123", path: "/videos", want: false},
+		{name: "wildcard whole path", acl: "/videos/*", path: "/videos/123/1.ts", want: true},
+		{name: "wildcard not matching", acl: "/videos/*.ts", path: "/videos/123/1.mp4", want: false},
+		{name: "single char wildcard", acl: "/videos/12?", path: "/videos/123", want: true},
+		{name: "single char wildcard too long", acl: "/videos/12?", path: "/videos/1234", want: false},
+		{name: "list first matches", acl: "/audio!/videos/123", path: "/audio/1.mp3", want: true},
+		{name: "list last matches", acl: "/audio!/videos/123", path: "/videos/123/1.ts", want: true},
+		{name: "list none matches", acl: "/audio!/videos/123", path: "/videos/1234", want: false},
+		{name: "list with empty patterns", acl: "!!/audio!", path: "/audio", want: true},
+		{name: "empty acl", acl: "", path: "/audio", want: false},
+		{name: "exact same path", acl: "/videos/123", path: "/videos/123", exact: true, want: true},
+		{name: "exact path under directory", acl: "/videos/123", path: "/videos/123/1.ts", exact: true, want: false},
+		{name: "exact wildcard", acl: "/videos/123/*", path: "/videos/123/1.ts", exact: true, want: true},
+		{name: "exact list", acl: "/audio!/videos/123", path: "/videos/123", exact: true, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := matchAcl(tt.acl, DefaultTokenFormat.AclSeparator, tt.path, tt.exact)
+			if got != tt.want {
+				t.Errorf("matchAcl(%q, %q, exact=%v) = %v, want %v", tt.acl, tt.path, tt.exact, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTokenAcl(t *testing.T) {
+	clock.SetFixed(testNow)
+	defer clock.Reset()
+
+	cfg := TokenConfigT{EncryptionKey: testKey, EncryptionAlgorithm: "sha256"}
+
+	tests := []struct {
+		name       string
+		token      string
+		url        string
+		path       string
+		wantReason string
+	}{
+		{
+			name:  "token for the url",
+			token: signToken(t, cfg, "exp=1700000100", "/videos/123/1.ts"),
+			url:   "/videos/123/1.ts", path: "/videos/123/1.ts",
+		},
+		{
+			name:  "token for another url",
+			token: signToken(t, cfg, "exp=1700000100", "/videos/123/1.ts"),
+			url:   "/videos/123/2.ts", path: "/videos/123/2.ts", wantReason: reasons.BadSignature,
+		},
+		{
+			name:  "acl covering the path",
+			token: signToken(t, cfg, "exp=1700000100~acl=/videos/123/*", ""),
+			url:   "/videos/123/2.ts", path: "/videos/123/2.ts",
+		},
+		{
+			name:  "acl directory covering the path",
+			token: signToken(t, cfg, "exp=1700000100~acl=/videos/123", ""),
+			url:   "/videos/123/2.ts", path: "/videos/123/2.ts",
+		},
+		{
+			name:  "acl list covering the path",
+			token: signToken(t, cfg, "exp=1700000100~acl=/audio/*!/videos/123/*", ""),
+			url:   "/videos/123/2.ts", path: "/videos/123/2.ts",
+		},
+		{
+			name:  "acl not covering the path",
+			token: signToken(t, cfg, "exp=1700000100~acl=/videos/123/*", ""),
+			url:   "/videos/1234/1.ts", path: "/videos/1234/1.ts", wantReason: reasons.PathNotAllowed,
+		},
+		{
+			name:  "acl changed",
+			token: strings.Replace(signToken(t, cfg, "exp=1700000100~acl=/videos/123/*", ""), "/videos/123/*", "/videos/*", 1),
+			url:   "/videos/1234/1.ts", path: "/videos/1234/1.ts", wantReason: reasons.BadSignature,
+		},
+		{
+			name:  "expired",
+			token: signToken(t, cfg, "exp=1700000000~acl=/videos/*", ""),
+			url:   "/videos/1.ts", path: "/videos/1.ts", wantReason: reasons.Expired,
+		},
+		{
+			name:  "without expiration",
+			token: signToken(t, cfg, "acl=/videos/*", ""),
+			url:   "/videos/1.ts", path: "/videos/1.ts", wantReason: reasons.MissingField,
+		},
+		{
+			name:  "without hmac",
+			token: "exp=1700000100~acl=/videos/*",
+			url:   "/videos/1.ts", path: "/videos/1.ts", wantReason: reasons.MissingField,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := ValidateTokenUrl(tt.token, cfg, TokenRequestT{Url: tt.url, Path: tt.path})
+			checkReason(t, err, tt.wantReason)
+		})
+	}
+}
+
+func TestValidateTokenAkamai(t *testing.T) {
+	clock.SetFixed(testNow)
+	defer clock.Reset()
+
+	cfg := TokenConfigT{EncryptionKey: testKey, EncryptionAlgorithm: "sha256", Compatibility: CompatibilityAKAMAI}
+	salted := cfg
+	salted.Salt = "pepper"
+
+	tests := []struct {
+		name       string
+		cfg        TokenConfigT
+		token      string
+		url        string
+		path       string
+		wantReason string
+	}{
+		{
+			name:  "fields in any order",
+			cfg:   cfg,
+			token: signToken(t, cfg, "st=1699999900~exp=1700000100~id=user-1~data=x", "/videos/1.ts"),
+			url:   "/videos/1.ts", path: "/videos/1.ts",
+		},
+		{
+			name:  "escaped acl",
+			cfg:   cfg,
+			token: signToken(t, cfg, "exp=1700000100~acl="+EscapeAkamai("/videos/*"), ""),
+			url:   "/videos/1.ts", path: "/videos/1.ts",
+		},
+		{
+			name:  "acl without wildcards is exact",
+			cfg:   cfg,
+			token: signToken(t, cfg, "exp=1700000100~acl=/videos/123", ""),
+			url:   "/videos/123/1.ts", path: "/videos/123/1.ts", wantReason: reasons.PathNotAllowed,
+		},
+		{
+			name:  "salt",
+			cfg:   salted,
+			token: signToken(t, salted, "exp=1700000100~acl=/videos/*", ""),
+			url:   "/videos/1.ts", path: "/videos/1.ts",
+		},
+		{
+			name:  "salt not used in the token",
+			cfg:   salted,
+			token: signToken(t, cfg, "exp=1700000100~acl=/videos/*", ""),
+			url:   "/videos/1.ts", path: "/videos/1.ts", wantReason: reasons.BadSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := ValidateTokenUrl(tt.token, tt.cfg, TokenRequestT{Url: tt.url, Path: tt.path})
+			checkReason(t, err, tt.wantReason)
+		})
+	}
+}
+
+// TestValidateTokenAkamaiGolden checks tokens generated by the Akamai EdgeAuth reference
+// tooling, so a change in the digest built from the token is not hidden by signToken
+func TestValidateTokenAkamaiGolden(t *testing.T) {
+	clock.SetFixed(testNow)
+	defer clock.Reset()
+
+	key, err := DecodeKey("00112233445566778899aabbccddeeff", KeyEncodingHEX, "sha256")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		algorithm  string
+		salt       string
+		token      string
+		url        string
+		path       string
+		clientIp   string
+		wantReason string
+	}{
+		{
+			name:  "acl",
+			token: "exp=1700000100~acl=/videos/*~hmac=6af2f79cd57698aa47968f961761fad6cc7423cafd05f11396dbd25b87be8891",
+			url:   "/videos/1.ts", path: "/videos/1.ts",
+		},
+		{
+			name:  "acl with escape early",
+			token: "ip=192.0.2.5~st=1699999900~exp=1700000100~acl=%2fvideos%2f%2a~id=user+1~data=a%2cb~hmac=ae7ab2c7b8b87f026eca64d9b9c52d3149441639b8a7489e312d97e88d6c057c",
+			url:   "/videos/1.ts", path: "/videos/1.ts", clientIp: "192.0.2.5",
+		},
+		{
+			name:  "several acls",
+			token: "exp=1700000100~acl=/videos/*!/img/*~hmac=6fda6394d4d11aa05e07a2dead0dfa46693b9948b4b7d860239e6e4d19f375af",
+			url:   "/img/1.png", path: "/img/1.png",
+		},
+		{
+			name:  "acl with escape early, salt and sha1",
+			token: "exp=1700000100~acl=%2fvideos%2f%2a~hmac=aac46c1b3c49e21c9c248f6cbd62b11a67b7ee2c",
+			url:   "/videos/1.ts", path: "/videos/1.ts", algorithm: "sha1", salt: "pepper",
+		},
+		{
+			name:  "url",
+			token: "exp=1700000100~id=user-1~hmac=818657bef3a3f46960b76bc9aa6bb603ab2f0ee5163c84cfaf83b5b2eece6675",
+			url:   "/videos/1.ts", path: "/videos/1.ts",
+		},
+		{
+			name:  "url with escape early",
+			token: "exp=1700000100~hmac=bfba99054666dd92d7ab55b5fbd64fc96d5f366e8fc5d898d84f97b1133667a1",
+			url:   EscapeAkamai("/videos/1.ts"), path: "/videos/1.ts",
+		},
+		{
+			name:  "url with escape early checked unescaped",
+			token: "exp=1700000100~hmac=bfba99054666dd92d7ab55b5fbd64fc96d5f366e8fc5d898d84f97b1133667a1",
+			url:   "/videos/1.ts", path: "/videos/1.ts", wantReason: reasons.BadSignature,
+		},
+		{
+			name:  "url with salt",
+			token: "exp=1700000100~hmac=a56f1cc99e1cad711670067c66c2b08ae470c331f03d087e0b9b4cc7d1f41503",
+			url:   "/videos/1.ts", path: "/videos/1.ts", salt: "pepper",
+		},
+		{
+			name:  "url of other path",
+			token: "exp=1700000100~id=user-1~hmac=818657bef3a3f46960b76bc9aa6bb603ab2f0ee5163c84cfaf83b5b2eece6675",
+			url:   "/videos/2.ts", path: "/videos/2.ts", wantReason: reasons.BadSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := TokenConfigT{EncryptionKey: key, EncryptionAlgorithm: "sha256", Compatibility: CompatibilityAKAMAI, Salt: tt.salt}
+			if tt.algorithm != "" {
+				cfg.EncryptionAlgorithm = tt.algorithm
+			}
+
+			request := TokenRequestT{Url: tt.url, Path: tt.path, ClientIp: net.ParseIP(tt.clientIp)}
+			_, _, err := ValidateTokenUrl(tt.token, cfg, request)
+			checkReason(t, err, tt.wantReason)
+		})
+	}
+}
+
+func TestEscapeAkamai(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "/videos/*", want: "%2fvideos%2f%2a"},
+		{value: "a b,c", want: "a+b%2cc"},
+		{value: "plain", want: "plain"},
+	}
+
+	for _, tt := range tests {
+		if got := EscapeAkamai(tt.value); got != tt.want {
+			t.Errorf("EscapeAkamai(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+
+		if got := unescapeField(EscapeAkamai(tt.value)); got != tt.value {
+			t.Errorf("unescapeField(EscapeAkamai(%q)) = %q", tt.value, got)
+		}
+	}
+}
+
+func TestTokenId(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		cfg   TokenConfigT
+		want  string
+	}{
+		{name: "id", token: "exp=1~id=user-1~hmac=00", want: "user-1"},
+		{name: "without id", token: "exp=1~hmac=00", want: ""},
+		{name: "escaped id in akamai mode", token: "exp=1~id=user%2f1~hmac=00", cfg: TokenConfigT{Compatibility: CompatibilityAKAMAI}, want: "user/1"},
+		{name: "escaped id", token: "exp=1~id=user%2f1~hmac=00", want: "user%2f1"},
+		{name: "custom format", token: "exp:1&sub:user-1&hmac:00", cfg: TokenConfigT{Format: TokenFormatT{FieldSeparator: "&", ValueSeparator: ":", IdField: "sub"}}, want: "user-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TokenId(tt.token, tt.cfg); got != tt.want {
+				t.Errorf("TokenId() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTokenBinding(t *testing.T) {
+	clock.SetFixed(testNow)
+	defer clock.Reset()
+
+	cfg := TokenConfigT{EncryptionKey: testKey, EncryptionAlgorithm: "sha256"}
+
+	tests := []struct {
+		name       string
+		fields     string
+		clientIp   string
+		wantReason string
+	}{
+		{name: "started", fields: "st=1699999900~exp=1700000100"},
+		{name: "starting now", fields: "st=1700000000~exp=1700000100"},
+		{name: "not started", fields: "st=1700000001~exp=1700000100", wantReason: reasons.NotYetValid},
+		{name: "invalid start", fields: "st=soon~exp=1700000100", wantReason: reasons.InvalidParam},
+		{name: "same ip", fields: "ip=192.0.2.10~exp=1700000100", clientIp: "192.0.2.10"},
+		{name: "another ip", fields: "ip=192.0.2.10~exp=1700000100", clientIp: "192.0.2.11", wantReason: reasons.IpNotAllowed},
+		{name: "ip in network", fields: "ip=192.0.2.0/24~exp=1700000100", clientIp: "192.0.2.11"},
+		{name: "escaped network", fields: "ip=2001%3adb8%3a%3a%2f32~exp=1700000100", clientIp: "2001:db8::1"},
+		{name: "ip out of network", fields: "ip=192.0.2.0/24~exp=1700000100", clientIp: "198.51.100.1", wantReason: reasons.IpNotAllowed},
+		{name: "unknown client ip", fields: "ip=192.0.2.10~exp=1700000100", wantReason: reasons.IpNotAllowed},
+		{name: "invalid ip", fields: "ip=192.0.2~exp=1700000100", clientIp: "192.0.2.10", wantReason: reasons.InvalidParam},
+		{name: "ip and start", fields: "ip=192.0.2.10~st=1699999900~exp=1700000100", clientIp: "192.0.2.10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := signToken(t, cfg, tt.fields, "/videos/1.ts")
+			_, _, err := ValidateTokenUrl(token, cfg, TokenRequestT{Url: "/videos/1.ts", Path: "/videos/1.ts", ClientIp: net.ParseIP(tt.clientIp)})
+			checkReason(t, err, tt.wantReason)
+		})
+	}
+}
+
+func TestDecodeKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       string
+		encoding  string
+		algorithm string
+		wantLen   int
+		wantErr   bool
+	}{
+		{name: "hex", key: "00112233445566778899aabbccddeeff", algorithm: "sha256", wantLen: 16},
+		{name: "hex by default with spaces", key: " 00112233445566778899AABBCCDDEEFF\n", encoding: "", algorithm: "sha256", wantLen: 16},
+		{name: "invalid hex", key: "0011223344556677889", algorithm: "md5", wantErr: true},
+		{name: "base64", key: "ABEiM0RVZneImaq7zN3u/w==", encoding: KeyEncodingBASE64, algorithm: "sha256", wantLen: 16},
+		{name: "base64url without padding", key: "ABEiM0RVZneImaq7zN3u_w", encoding: KeyEncodingBASE64URL, algorithm: "sha256", wantLen: 16},
+		{name: "base64url with padding", key: "ABEiM0RVZneImaq7zN3u_w==", encoding: KeyEncodingBASE64URL, algorithm: "sha256", wantLen: 16},
+		{name: "base64 with url alphabet", key: "ABEiM0RVZneImaq7zN3u_w==", encoding: KeyEncodingBASE64, algorithm: "sha256", wantErr: true},
+		{name: "raw keeps spaces", key: " 0123456789abcde", encoding: KeyEncodingRAW, algorithm: "sha256", wantLen: 16},
+		{name: "empty", key: "", algorithm: "md5", wantErr: true},
+		{name: "empty raw", key: "", encoding: KeyEncodingRAW, algorithm: "md5", wantErr: true},
+		{name: "short for sha1", key: "00112233445566778899", algorithm: "sha1", wantLen: 10},
+		{name: "too short for sha1", key: "001122334455667788", algorithm: "sha1", wantErr: true},
+		{name: "too short for sha256", key: "00112233445566778899aabbccddee", algorithm: "sha256", wantErr: true},
+		{name: "too short for sha512", key: "00112233445566778899aabbccddeeff", algorithm: "sha512", wantErr: true},
+		{name: "unknown encoding", key: "00112233445566778899aabbccddeeff", encoding: "base32", algorithm: "sha256", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := DecodeKey(tt.key, tt.encoding, tt.algorithm)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && len(key) != tt.wantLen {
+				t.Errorf("DecodeKey() = %d bytes, want %d", len(key), tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestValidateTokenFormat(t *testing.T) {
+	clock.SetFixed(testNow)
+	defer clock.Reset()
+
+	custom := TokenFormatT{
+		FieldSeparator: "&",
+		ValueSeparator: ":",
+		AclSeparator:   ",",
+		HmacField:      "sig",
+		ExpField:       "expires",
+		StField:        "start",
+		AclField:       "paths",
+		UrlField:       "u",
+	}
+	base64 := TokenFormatT{DigestEncoding: DigestEncodingBASE64}
+	base64url := TokenFormatT{DigestEncoding: DigestEncodingBASE64URL}
+	truncated := TokenFormatT{DigestLength: 16}
+
+	tests := []struct {
+		name       string
+		format     TokenFormatT
+		signFormat *TokenFormatT // the format of the validation by default
+		fields     string
+		url        string // signed along with the fields, unless the token has an acl
+		edit       func(token string) string
+		wantReason string
+	}{
+		{name: "custom fields and separators", format: custom, fields: "start:1699999900&expires:1700000100", url: "/videos/1.ts"},
+		{name: "custom acl separator", format: custom, fields: "expires:1700000100&paths:/audio/*,/videos/*"},
+		{name: "default fields with custom format", format: custom, signFormat: &TokenFormatT{}, fields: "exp=1700000100", url: "/videos/1.ts", wantReason: reasons.MissingField},
+		{name: "base64 digest", format: base64, fields: "exp=1700000100", url: "/videos/1.ts"},
+		{name: "base64url digest", format: base64url, fields: "exp=1700000100", url: "/videos/1.ts"},
+		{name: "base64url digest with padding", format: base64url, fields: "exp=1700000100", url: "/videos/1.ts", edit: func(token string) string { return token + "=" }},
+		{name: "hex digest in uppercase", fields: "exp=1700000100", url: "/videos/1.ts", edit: func(token string) string {
+			prefix := "exp=1700000100~hmac="
+			return prefix + strings.ToUpper(strings.TrimPrefix(token, prefix))
+		}},
+		{name: "digest in another encoding", format: base64, signFormat: &TokenFormatT{}, fields: "exp=1700000100", url: "/videos/1.ts", wantReason: reasons.BadSignature},
+		{name: "digest not encoded", fields: "exp=1700000100", url: "/videos/1.ts", edit: func(token string) string { return "exp=1700000100~hmac=not-hex" }, wantReason: reasons.InvalidParam},
+		{name: "truncated digest", format: truncated, fields: "exp=1700000100", url: "/videos/1.ts"},
+		{name: "whole digest when truncated", format: truncated, signFormat: &TokenFormatT{}, fields: "exp=1700000100", url: "/videos/1.ts", wantReason: reasons.BadSignature},
+		{name: "truncated digest when not truncated", signFormat: &truncated, fields: "exp=1700000100", url: "/videos/1.ts", wantReason: reasons.BadSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := TokenConfigT{EncryptionKey: testKey, EncryptionAlgorithm: "sha256", Format: tt.format}
+
+			signCfg := cfg
+			if tt.signFormat != nil {
+				signCfg.Format = *tt.signFormat
+			}
+
+			token := signToken(t, signCfg, tt.fields, tt.url)
+			if tt.edit != nil {
+				token = tt.edit(token)
+			}
+
+			_, _, err := ValidateTokenUrl(token, cfg, TokenRequestT{Url: "/videos/1.ts", Path: "/videos/1.ts"})
+			checkReason(t, err, tt.wantReason)
+		})
+	}
+}
+
+func TestCheckDigestLength(t *testing.T) {
+	tests := []struct {
+		length    int
+		algorithm string
+		wantErr   bool
+	}{
+		{length: 0, algorithm: "sha256"},
+		{length: 10, algorithm: "sha256"},
+		{length: 32, algorithm: "sha256"},
+		{length: 9, algorithm: "sha256", wantErr: true},
+		{length: 33, algorithm: "sha256", wantErr: true},
+		{length: 20, algorithm: "sha1"},
+		{length: 21, algorithm: "sha1", wantErr: true},
+		{length: 64, algorithm: "sha512"},
+		{length: 16, algorithm: "sha3", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		if err := CheckDigestLength(tt.length, tt.algorithm); (err != nil) != tt.wantErr {
+			t.Errorf("CheckDigestLength(%d, %s) error = %v, wantErr %v", tt.length, tt.algorithm, err, tt.wantErr)
+		}
+	}
+}
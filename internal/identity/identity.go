@@ -0,0 +1,48 @@
+package identity
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+type contextKeyT struct{}
+
+// holderT keeps the identities set by the authorizations while checking a request
+type holderT struct {
+	mutex      sync.Mutex
+	identities []string
+}
+
+// NewContext returns a copy of the request where authorizations can set
+// the identities carried by the credentials, e.g. the id of a token
+func NewContext(req *http.Request) *http.Request {
+	ctx := context.WithValue(req.Context(), contextKeyT{}, &holderT{})
+	return req.WithContext(ctx)
+}
+
+// Set adds the identity of a credential checked successfully.
+// It is ignored for requests without context for them
+func Set(req *http.Request, identity string) {
+	holder, ok := req.Context().Value(contextKeyT{}).(*holderT)
+	if !ok || identity == "" {
+		return
+	}
+
+	holder.mutex.Lock()
+	holder.identities = append(holder.identities, identity)
+	holder.mutex.Unlock()
+}
+
+// FromRequest returns the identities set for the request, in the order they were set
+func FromRequest(req *http.Request) []string {
+	holder, ok := req.Context().Value(contextKeyT{}).(*holderT)
+	if !ok {
+		return nil
+	}
+
+	holder.mutex.Lock()
+	defer holder.mutex.Unlock()
+
+	return append([]string{}, holder.identities...)
+}
@@ -0,0 +1,47 @@
+package iplist
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"doorkeeper/internal/clientip"
+)
+
+// ParseText parses a list of networks with one CIDR (or IP) per line.
+// Empty lines and comments starting with '#' are ignored
+func ParseText(content []byte, source string) (networks []*net.IPNet, err error) {
+	lineNumber := 0
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		lineNumber++
+
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		var lineNetworks []*net.IPNet
+		lineNetworks, err = clientip.ParseNetworks([]string{line})
+		if err != nil {
+			return networks, fmt.Errorf("invalid network in '%s' line %d: %s", source, lineNumber, err.Error())
+		}
+		networks = append(networks, lineNetworks...)
+	}
+
+	return networks, scanner.Err()
+}
+
+// ReadFile parses a file with a list of networks, as described in ParseText
+func ReadFile(path string) (networks []*net.IPNet, err error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return networks, err
+	}
+
+	return ParseText(content, path)
+}
@@ -0,0 +1,361 @@
+package iplist
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"doorkeeper/api/v1alpha2"
+	"doorkeeper/internal/clientip"
+	"doorkeeper/internal/metrics"
+)
+
+const (
+	FormatTEXT = "TEXT"
+	FormatJSON = "JSON"
+
+	DefaultRefreshInterval = time.Hour
+	DefaultTimeout         = 10 * time.Second
+	DefaultMaxSize         = 10 * 1024 * 1024
+
+	// RetryInterval is the max time between attempts while fetching a list fails
+	RetryInterval = time.Minute
+)
+
+var (
+	Formats = []string{FormatTEXT, FormatJSON}
+
+	refreshFailures = metrics.NewCounterVec("doorkeeper_iplist_refresh_failures_total",
+		"Failed fetches of ip lists from urls, by url", "url")
+
+	// remotes are registered by url while any authorization uses them.
+	// Configs with the same url but different options get their own remote
+	remotesMutex sync.Mutex
+	remotes      = map[string][]*RemoteT{}
+)
+
+// RemoteT is a list of networks published in a URL, such as the ranges of cloud providers.
+// It is refreshed periodically, keeping the last good list when fetching fails
+type RemoteT struct {
+	cfg v1alpha2.IpListUrlConfigT
+
+	// refs counts the users of the remote, guarded by remotesMutex
+	refs     int
+	loadOnce sync.Once
+
+	url             string
+	format          string
+	selectors       []*SelectorT
+	refreshInterval time.Duration
+	maxSize         int64
+	cacheFile       string
+
+	client *http.Client
+
+	// refreshMutex serializes the refreshes, as remotes are shared by several authorizations
+	refreshMutex  sync.Mutex
+	lastAttempt   time.Time
+	lastFailed    bool
+	unreportedErr error
+
+	// version changes with the networks, so users of the list know when to take them again
+	networksMutex sync.RWMutex
+	networks      []*net.IPNet
+	version       uint64
+}
+
+func NewRemote(cfg v1alpha2.IpListUrlConfigT) (r *RemoteT, err error) {
+	r = &RemoteT{
+		cfg:             cfg,
+		url:             cfg.Url,
+		format:          cfg.Format,
+		refreshInterval: cfg.RefreshInterval,
+		maxSize:         cfg.MaxSize,
+		cacheFile:       cfg.CacheFile,
+		client:          &http.Client{Timeout: cfg.Timeout},
+	}
+
+	if r.format == "" {
+		r.format = FormatTEXT
+	}
+
+	if r.refreshInterval == 0 {
+		r.refreshInterval = DefaultRefreshInterval
+	}
+
+	if r.maxSize == 0 {
+		r.maxSize = DefaultMaxSize
+	}
+
+	if r.client.Timeout == 0 {
+		r.client.Timeout = DefaultTimeout
+	}
+
+	for _, selectorv := range cfg.Selectors {
+		var selector *SelectorT
+		selector, err = NewSelector(selectorv)
+		if err != nil {
+			return r, err
+		}
+		r.selectors = append(r.selectors, selector)
+	}
+
+	return r, err
+}
+
+// GetRemote returns the remote for the config, shared with the previous configs defining
+// the same one, so reloading the config does not fetch the lists again nor drop them.
+// Every remote got must be released once it is not used anymore.
+// New remotes are loaded once, but failing to do it is not an error: the list stays empty
+// until a refresh succeeds, and the failure is returned by the first refresh
+func GetRemote(cfg v1alpha2.IpListUrlConfigT) (r *RemoteT, err error) {
+	r, err = acquireRemote(cfg)
+	if err != nil {
+		return r, err
+	}
+
+	// loaded out of the registry lock, so slow urls do not block other remotes.
+	// Concurrent users of a new remote wait for it to be loaded
+	r.loadOnce.Do(func() {
+		_, err := r.Refresh(true)
+
+		r.refreshMutex.Lock()
+		r.unreportedErr = err
+		r.refreshMutex.Unlock()
+	})
+
+	return r, nil
+}
+
+// acquireRemote returns the registered remote for the config, or registers a new one
+func acquireRemote(cfg v1alpha2.IpListUrlConfigT) (r *RemoteT, err error) {
+	remotesMutex.Lock()
+	defer remotesMutex.Unlock()
+
+	for _, remotev := range remotes[cfg.Url] {
+		if reflect.DeepEqual(remotev.cfg, cfg) {
+			remotev.refs++
+			return remotev, nil
+		}
+	}
+
+	r, err = NewRemote(cfg)
+	if err != nil {
+		return r, err
+	}
+
+	r.refs = 1
+	remotes[cfg.Url] = append(remotes[cfg.Url], r)
+
+	return r, nil
+}
+
+// Release drops a reference to the remote got from GetRemote,
+// removing it from the registry when nothing else uses it
+func (r *RemoteT) Release() {
+	remotesMutex.Lock()
+	defer remotesMutex.Unlock()
+
+	r.refs--
+	if r.refs > 0 {
+		return
+	}
+
+	urlRemotes := remotes[r.url]
+	for remotei, remotev := range urlRemotes {
+		if remotev == r {
+			urlRemotes = append(urlRemotes[:remotei], urlRemotes[remotei+1:]...)
+			break
+		}
+	}
+
+	if len(urlRemotes) == 0 {
+		delete(remotes, r.url)
+		return
+	}
+	remotes[r.url] = urlRemotes
+}
+
+// URL returns where the list is fetched from
+func (r *RemoteT) URL() string {
+	return r.url
+}
+
+// Networks returns the last good list of networks, and its version
+func (r *RemoteT) Networks() (networks []*net.IPNet, version uint64) {
+	r.networksMutex.RLock()
+	defer r.networksMutex.RUnlock()
+
+	return r.networks, r.version
+}
+
+func (r *RemoteT) setNetworks(networks []*net.IPNet) {
+	r.networksMutex.Lock()
+	defer r.networksMutex.Unlock()
+
+	r.networks = networks
+	r.version++
+}
+
+// Refresh fetches the list again when the refresh interval has passed since the last attempt,
+// or when forced. Failed attempts are retried sooner. On failure, the last good list is kept,
+// or the one stored in the cache file by a previous execution when there is none yet
+func (r *RemoteT) Refresh(force bool) (refreshed bool, err error) {
+	r.refreshMutex.Lock()
+	defer r.refreshMutex.Unlock()
+
+	interval := r.refreshInterval
+	if r.lastFailed {
+		interval = min(interval, RetryInterval)
+	}
+
+	if !force && time.Since(r.lastAttempt) < interval {
+		err, r.unreportedErr = r.unreportedErr, nil
+		return false, err
+	}
+	r.lastAttempt = time.Now()
+	r.unreportedErr = nil
+
+	return r.refresh()
+}
+
+func (r *RemoteT) refresh() (refreshed bool, err error) {
+	content, err := r.fetch()
+	if err != nil {
+		r.lastFailed = true
+		refreshFailures.Inc(r.url)
+		return r.loadCache(fmt.Errorf("unable to fetch ip list from '%s': %s", r.url, err.Error()))
+	}
+
+	networks, err := r.parse(content)
+	if err != nil {
+		r.lastFailed = true
+		refreshFailures.Inc(r.url)
+		return r.loadCache(fmt.Errorf("invalid ip list from '%s': %s", r.url, err.Error()))
+	}
+	r.setNetworks(networks)
+	r.lastFailed = false
+
+	if r.cacheFile != "" {
+		err = writeFileAtomic(r.cacheFile, content)
+		if err != nil {
+			return true, fmt.Errorf("unable to write ip list cache: %s", err.Error())
+		}
+	}
+
+	return true, nil
+}
+
+// loadCache takes the networks from the cache file when there are none yet.
+// The error of the url is returned anyway, so it is reported
+func (r *RemoteT) loadCache(urlErr error) (loaded bool, err error) {
+	if networks, _ := r.Networks(); r.cacheFile == "" || networks != nil {
+		return false, urlErr
+	}
+
+	content, err := os.ReadFile(r.cacheFile)
+	if err != nil {
+		return false, fmt.Errorf("%s, and unable to read cache: %s", urlErr.Error(), err.Error())
+	}
+
+	networks, err := r.parse(content)
+	if err != nil {
+		return false, fmt.Errorf("%s, and invalid cache: %s", urlErr.Error(), err.Error())
+	}
+	r.setNetworks(networks)
+
+	return true, fmt.Errorf("%s, using the cache", urlErr.Error())
+}
+
+func (r *RemoteT) fetch() (content []byte, err error) {
+	response, err := r.client.Get(r.url)
+	if err != nil {
+		return content, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return content, fmt.Errorf("unexpected status code %d", response.StatusCode)
+	}
+
+	content, err = io.ReadAll(io.LimitReader(response.Body, r.maxSize+1))
+	if err != nil {
+		return content, err
+	}
+
+	if int64(len(content)) > r.maxSize {
+		return content, fmt.Errorf("content exceeds the max size of %d bytes", r.maxSize)
+	}
+
+	return content, err
+}
+
+// parse gets the networks from the content. An empty list is considered an error,
+// as it is probably caused by a change in the published format
+func (r *RemoteT) parse(content []byte) (networks []*net.IPNet, err error) {
+	switch r.format {
+	case FormatJSON:
+		{
+			var document any
+			err = json.Unmarshal(content, &document)
+			if err != nil {
+				return networks, err
+			}
+
+			for _, selector := range r.selectors {
+				var values []string
+				values, err = selector.Select(document)
+				if err != nil {
+					return networks, err
+				}
+
+				var selected []*net.IPNet
+				selected, err = clientip.ParseNetworks(values)
+				if err != nil {
+					return networks, err
+				}
+				networks = append(networks, selected...)
+			}
+		}
+	default:
+		{
+			networks, err = ParseText(content, r.url)
+			if err != nil {
+				return networks, err
+			}
+		}
+	}
+
+	if len(networks) == 0 {
+		return networks, fmt.Errorf("no networks found")
+	}
+
+	return networks, err
+}
+
+// writeFileAtomic writes the file in a temporary one before renaming it,
+// so readers never get it partially written
+func writeFileAtomic(path string, content []byte) (err error) {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(content)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), path)
+}
@@ -0,0 +1,346 @@
+package iplist
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"doorkeeper/api/v1alpha2"
+	"doorkeeper/internal/metrics"
+)
+
+// newListServer serves the content stored in it, or fails while it is empty
+func newListServer(t *testing.T) (server *httptest.Server, content *atomic.Value) {
+	t.Helper()
+
+	content = &atomic.Value{}
+	content.Store("")
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := content.Load().(string)
+		if body == "" {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, content
+}
+
+func networkStrings(r *RemoteT) (result []string) {
+	networks, _ := r.Networks()
+	for _, networkv := range networks {
+		result = append(result, networkv.String())
+	}
+	return result
+}
+
+func TestRemoteParse(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    string
+		selectors []string
+		content   string
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:    "text with comments",
+			content: "# ranges\n10.0.0.0/8\n\n192.0.2.1 # single ip\n2001:db8::/32\n",
+			want:    "10.0.0.0/8,192.0.2.1/32,2001:db8::/32",
+		},
+		{
+			name:    "text with invalid line",
+			content: "10.0.0.0/8\nnot-a-network\n",
+			wantErr: true,
+		},
+		{
+			name:    "text without networks",
+			content: "# nothing\n",
+			wantErr: true,
+		},
+		{
+			name:      "json with filter",
+			format:    FormatJSON,
+			selectors: []string{"$.prefixes[?(@.service=='CLOUDFRONT')].ip_prefix"},
+			content:   `{"prefixes":[{"ip_prefix":"10.0.0.0/8","service":"CLOUDFRONT"},{"ip_prefix":"172.16.0.0/12","service":"EC2"}]}`,
+			want:      "10.0.0.0/8",
+		},
+		{
+			name:      "json with several selectors",
+			format:    FormatJSON,
+			selectors: []string{"result.ipv4_cidrs[*]", "result.ipv6_cidrs[*]"},
+			content:   `{"result":{"ipv4_cidrs":["192.0.2.0/24"],"ipv6_cidrs":["2001:db8::/32"]}}`,
+			want:      "192.0.2.0/24,2001:db8::/32",
+		},
+		{
+			name:      "json with invalid network",
+			format:    FormatJSON,
+			selectors: []string{"result[*]"},
+			content:   `{"result":["192.0.2.0/33"]}`,
+			wantErr:   true,
+		},
+		{
+			name:      "json not matching the selectors",
+			format:    FormatJSON,
+			selectors: []string{"result[*]"},
+			content:   `{"other":["192.0.2.0/24"]}`,
+			wantErr:   true,
+		},
+		{
+			name:      "invalid json",
+			format:    FormatJSON,
+			selectors: []string{"result[*]"},
+			content:   `{"result":`,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewRemote(v1alpha2.IpListUrlConfigT{Url: "http://lists", Format: tt.format, Selectors: tt.selectors})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			networks, err := r.parse([]byte(tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			r.setNetworks(networks)
+			if got := strings.Join(networkStrings(r), ","); got != tt.want {
+				t.Errorf("parse() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoteRefreshKeepsLastGoodList(t *testing.T) {
+	server, content := newListServer(t)
+	content.Store("10.0.0.0/8\n")
+
+	r, err := NewRemote(v1alpha2.IpListUrlConfigT{Url: server.URL})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	refreshed, err := r.Refresh(true)
+	if err != nil || !refreshed {
+		t.Fatalf("Refresh() = (%v, %v), want (true, nil)", refreshed, err)
+	}
+	_, version := r.Networks()
+
+	// not refreshed again until the interval passes
+	content.Store("192.0.2.0/24\n")
+	refreshed, err = r.Refresh(false)
+	if err != nil || refreshed {
+		t.Errorf("Refresh() before the interval = (%v, %v), want (false, nil)", refreshed, err)
+	}
+
+	for _, failingv := range []string{"", "# no networks\n", "not-a-network\n"} {
+		content.Store(failingv)
+		refreshed, err = r.Refresh(true)
+		if err == nil || refreshed {
+			t.Errorf("Refresh() serving %q = (%v, %v), want an error", failingv, refreshed, err)
+		}
+	}
+
+	if got, newVersion := r.Networks(); len(got) != 1 || got[0].String() != "10.0.0.0/8" || newVersion != version {
+		t.Errorf("Networks() = (%v, %d), want the last good list with version %d", got, newVersion, version)
+	}
+
+	if !r.lastFailed {
+		t.Errorf("failed attempts must be retried sooner")
+	}
+
+	content.Store("192.0.2.0/24\n")
+	refreshed, err = r.Refresh(true)
+	if err != nil || !refreshed {
+		t.Fatalf("Refresh() = (%v, %v), want (true, nil)", refreshed, err)
+	}
+
+	if got, newVersion := r.Networks(); len(got) != 1 || got[0].String() != "192.0.2.0/24" || newVersion == version {
+		t.Errorf("Networks() = (%v, %d), want the new list with a new version", got, newVersion)
+	}
+}
+
+func TestRemoteCache(t *testing.T) {
+	server, content := newListServer(t)
+	cacheFile := filepath.Join(t.TempDir(), "list.cache")
+	cfg := v1alpha2.IpListUrlConfigT{Url: server.URL, CacheFile: cacheFile}
+
+	content.Store("10.0.0.0/8\n")
+	r, err := NewRemote(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err = r.Refresh(true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if cached, _ := os.ReadFile(cacheFile); string(cached) != "10.0.0.0/8\n" {
+		t.Errorf("cache = %q, want the content fetched", cached)
+	}
+
+	// a new execution with the url unavailable takes the cache
+	content.Store("")
+	r, err = NewRemote(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	refreshed, err := r.Refresh(true)
+	if err == nil || !refreshed {
+		t.Errorf("Refresh() = (%v, %v), want the cache loaded and the error of the url", refreshed, err)
+	}
+
+	if got := networkStrings(r); len(got) != 1 || got[0] != "10.0.0.0/8" {
+		t.Errorf("Networks() = %v, want the cached list", got)
+	}
+}
+
+func TestGetRemote(t *testing.T) {
+	server, content := newListServer(t)
+
+	// lists failing on creation are empty, and the failure is reported by the first refresh
+	cfg := v1alpha2.IpListUrlConfigT{Url: server.URL + "/down"}
+	r, err := GetRemote(cfg)
+	if err != nil {
+		t.Fatalf("GetRemote() error = %v, want none for unavailable urls", err)
+	}
+
+	if networks, _ := r.Networks(); networks != nil {
+		t.Errorf("Networks() = %v, want none", networks)
+	}
+
+	if _, err = r.Refresh(false); err == nil {
+		t.Errorf("Refresh() error = nil, want the failure on creation")
+	}
+
+	if _, err = r.Refresh(false); err != nil {
+		t.Errorf("Refresh() error = %v, want the failure reported only once", err)
+	}
+
+	if !strings.Contains(metricsOutput(t), `doorkeeper_iplist_refresh_failures_total{url="`+cfg.Url+`"} 1`) {
+		t.Errorf("failure not counted in metrics:\n%s", metricsOutput(t))
+	}
+
+	// the same config shares the remote, so reloads keep the loaded list
+	content.Store("10.0.0.0/8\n")
+	cfg = v1alpha2.IpListUrlConfigT{Url: server.URL + "/up"}
+	first, err := GetRemote(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	content.Store("")
+	second, err := GetRemote(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if first != second {
+		t.Errorf("GetRemote() returned a new remote for the same config")
+	}
+
+	if got := networkStrings(second); len(got) != 1 || got[0] != "10.0.0.0/8" {
+		t.Errorf("Networks() = %v, want the list loaded before", got)
+	}
+
+	cfg.RefreshInterval = RetryInterval
+	other, _ := GetRemote(cfg)
+	if other == first {
+		t.Errorf("GetRemote() shared the remote of a different config")
+	}
+	other.Release()
+
+	// remotes are dropped once every user released them
+	first.Release()
+	if third, _ := GetRemote(v1alpha2.IpListUrlConfigT{Url: server.URL + "/up"}); third != second {
+		t.Errorf("GetRemote() returned a new remote while it was still used")
+	}
+	second.Release()
+	second.Release()
+
+	remotesMutex.Lock()
+	_, registered := remotes[server.URL+"/up"]
+	remotesMutex.Unlock()
+	if registered {
+		t.Errorf("remote still registered after being released by every user")
+	}
+}
+
+func TestGetRemoteConcurrentLoad(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte("10.0.0.0/8\n"))
+	}))
+	t.Cleanup(slow.Close)
+	t.Cleanup(func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+	})
+
+	fast, content := newListServer(t)
+	content.Store("192.0.2.0/24\n")
+
+	slowRemotes := make(chan *RemoteT, 2)
+	for range 2 {
+		go func() {
+			r, _ := GetRemote(v1alpha2.IpListUrlConfigT{Url: slow.URL})
+			slowRemotes <- r
+		}()
+	}
+
+	// other remotes are loaded while the slow one is being fetched
+	r, err := GetRemote(v1alpha2.IpListUrlConfigT{Url: fast.URL})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer r.Release()
+
+	if got := networkStrings(r); len(got) != 1 || got[0] != "192.0.2.0/24" {
+		t.Errorf("Networks() = %v, want the fast list", got)
+	}
+
+	select {
+	case <-slowRemotes:
+		t.Fatalf("GetRemote() returned before the slow list was loaded")
+	default:
+	}
+	close(release)
+
+	// every user of the new remote gets it loaded
+	first, second := <-slowRemotes, <-slowRemotes
+	defer first.Release()
+	defer second.Release()
+
+	if first != second {
+		t.Errorf("GetRemote() returned different remotes for the same config")
+	}
+	if got := networkStrings(second); len(got) != 1 || got[0] != "10.0.0.0/8" {
+		t.Errorf("Networks() = %v, want the slow list", got)
+	}
+}
+
+func metricsOutput(t *testing.T) string {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	metrics.Handler(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	return recorder.Body.String()
+}
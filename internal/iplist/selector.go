@@ -0,0 +1,182 @@
+package iplist
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	stepFIELD    = "field"
+	stepWILDCARD = "wildcard"
+	stepINDEX    = "index"
+	stepFILTER   = "filter"
+)
+
+type stepT struct {
+	kind string
+
+	name  string
+	index int
+
+	filterKey   string
+	filterValue string
+}
+
+// SelectorT is a JSONPath-like expression to get values from JSON documents.
+// It supports fields, wildcards, indexes and equality filters,
+// e.g. '$.prefixes[?(@.service=='CLOUDFRONT')].ip_prefix' or 'result.ipv4_cidrs[*]'
+type SelectorT struct {
+	expression string
+	steps      []stepT
+}
+
+func NewSelector(expression string) (s *SelectorT, err error) {
+	s = &SelectorT{expression: expression}
+
+	path := strings.TrimPrefix(strings.TrimSpace(expression), "$")
+	for len(path) > 0 {
+		switch path[0] {
+		case '.':
+			{
+				path = path[1:]
+				end := strings.IndexAny(path, ".[")
+				if end == -1 {
+					end = len(path)
+				}
+
+				if end == 0 {
+					return s, fmt.Errorf("empty field in selector '%s'", expression)
+				}
+
+				s.steps = append(s.steps, stepT{kind: stepFIELD, name: path[:end]})
+				path = path[end:]
+			}
+		case '[':
+			{
+				end := strings.Index(path, "]")
+				if end == -1 {
+					return s, fmt.Errorf("unclosed bracket in selector '%s'", expression)
+				}
+
+				var step stepT
+				step, err = parseBracket(path[1:end])
+				if err != nil {
+					return s, fmt.Errorf("invalid selector '%s': %s", expression, err.Error())
+				}
+
+				s.steps = append(s.steps, step)
+				path = path[end+1:]
+			}
+		default:
+			{
+				// a field without the leading dot, at the beginning of the expression
+				path = "." + path
+			}
+		}
+	}
+
+	return s, err
+}
+
+// parseBracket parses the content between brackets: '*', an index or a filter like '?(@.key=='value')'
+func parseBracket(content string) (step stepT, err error) {
+	content = strings.TrimSpace(content)
+
+	if content == "*" {
+		return stepT{kind: stepWILDCARD}, nil
+	}
+
+	if strings.HasPrefix(content, "?(") && strings.HasSuffix(content, ")") {
+		filter := strings.TrimSpace(content[2 : len(content)-1])
+		key, value, found := strings.Cut(filter, "==")
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		if !found || !strings.HasPrefix(key, "@.") || len(value) < 2 ||
+			!((value[0] == '\'' && value[len(value)-1] == '\'') || (value[0] == '"' && value[len(value)-1] == '"')) {
+			return step, fmt.Errorf("unsupported filter '%s', only equality with strings is supported", content)
+		}
+
+		return stepT{kind: stepFILTER, filterKey: key[2:], filterValue: value[1 : len(value)-1]}, nil
+	}
+
+	index, err := strconv.Atoi(content)
+	if err != nil {
+		return step, fmt.Errorf("invalid index '%s'", content)
+	}
+
+	return stepT{kind: stepINDEX, index: index}, nil
+}
+
+// Select returns the string values selected from a decoded JSON document
+func (s *SelectorT) Select(document any) (values []string, err error) {
+	current := []any{document}
+	for _, step := range s.steps {
+		next := []any{}
+		for _, node := range current {
+			next = append(next, applyStep(step, node)...)
+		}
+		current = next
+	}
+
+	for _, node := range current {
+		value, ok := node.(string)
+		if !ok {
+			return values, fmt.Errorf("selector '%s' matches a non string value", s.expression)
+		}
+		values = append(values, value)
+	}
+
+	return values, err
+}
+
+func applyStep(step stepT, node any) (result []any) {
+	switch step.kind {
+	case stepFIELD:
+		{
+			if object, ok := node.(map[string]any); ok {
+				if value, ok := object[step.name]; ok {
+					result = append(result, value)
+				}
+			}
+		}
+	case stepWILDCARD:
+		{
+			switch typedNode := node.(type) {
+			case []any:
+				result = append(result, typedNode...)
+			case map[string]any:
+				for _, value := range typedNode {
+					result = append(result, value)
+				}
+			}
+		}
+	case stepINDEX:
+		{
+			if list, ok := node.([]any); ok && step.index >= 0 && step.index < len(list) {
+				result = append(result, list[step.index])
+			}
+		}
+	case stepFILTER:
+		{
+			list, ok := node.([]any)
+			if !ok {
+				return result
+			}
+
+			for _, item := range list {
+				object, ok := item.(map[string]any)
+				if !ok {
+					continue
+				}
+
+				if value, ok := object[step.filterKey].(string); ok && value == step.filterValue {
+					result = append(result, item)
+				}
+			}
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,97 @@
+package iptrie
+
+import (
+	"net"
+)
+
+// nodeT is a node of a binary trie where each level is a bit of the address
+type nodeT struct {
+	children [2]*nodeT
+	terminal bool
+}
+
+// TrieT is a set of networks stored as prefixes in a binary trie, one for each
+// IP version. Lookups take at most as many steps as bits in the address,
+// no matter how many networks are stored
+type TrieT struct {
+	v4   *nodeT
+	v6   *nodeT
+	size int
+}
+
+func New() *TrieT {
+	return &TrieT{
+		v4: &nodeT{},
+		v6: &nodeT{},
+	}
+}
+
+// Insert adds a network to the set
+func (t *TrieT) Insert(network *net.IPNet) {
+	ones, bits := network.Mask.Size()
+
+	node := t.v6
+	ip := network.IP.To16()
+	if bits == 8*net.IPv4len {
+		node = t.v4
+		ip = network.IP.To4()
+	}
+
+	// IPv4-mapped networks (e.g. ::ffff:10.0.0.0/104) go to the IPv4 trie,
+	// as lookups of IPv4 addresses, mapped or not, are done there
+	if ip4 := network.IP.To4(); bits == 8*net.IPv6len && ip4 != nil && ones >= 8*(net.IPv6len-net.IPv4len) {
+		node = t.v4
+		ip = ip4
+		ones -= 8 * (net.IPv6len - net.IPv4len)
+	}
+
+	if ip == nil {
+		return
+	}
+	t.size++
+
+	for i := 0; i < ones; i++ {
+		// shorter prefixes already include this network
+		if node.terminal {
+			return
+		}
+
+		bit := (ip[i/8] >> (7 - uint(i%8))) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &nodeT{}
+		}
+		node = node.children[bit]
+	}
+
+	// longer prefixes are included in this network now
+	node.terminal = true
+	node.children = [2]*nodeT{}
+}
+
+// Contains returns whether the IP is inside any of the networks in the set
+func (t *TrieT) Contains(ip net.IP) bool {
+	node := t.v6
+	if ip4 := ip.To4(); ip4 != nil {
+		node = t.v4
+		ip = ip4
+	}
+
+	for i := 0; i < 8*len(ip); i++ {
+		if node.terminal {
+			return true
+		}
+
+		bit := (ip[i/8] >> (7 - uint(i%8))) & 1
+		node = node.children[bit]
+		if node == nil {
+			return false
+		}
+	}
+
+	return node.terminal
+}
+
+// Len returns the number of networks added to the set
+func (t *TrieT) Len() int {
+	return t.size
+}
@@ -0,0 +1,58 @@
+package iptrie
+
+import (
+	"net"
+	"testing"
+
+	"doorkeeper/internal/clientip"
+)
+
+func TestContains(t *testing.T) {
+	tests := []struct {
+		name     string
+		networks []string
+		ip       string
+		want     bool
+	}{
+		{name: "ipv4 inside", networks: []string{"10.0.0.0/8"}, ip: "10.1.2.3", want: true},
+		{name: "ipv4 outside", networks: []string{"10.0.0.0/8"}, ip: "11.1.2.3", want: false},
+		{name: "ipv4 single address", networks: []string{"192.0.2.1"}, ip: "192.0.2.1", want: true},
+		{name: "ipv4 next to single address", networks: []string{"192.0.2.1"}, ip: "192.0.2.2", want: false},
+		{name: "ipv4 all", networks: []string{"0.0.0.0/0"}, ip: "203.0.113.9", want: true},
+		{name: "ipv4 shorter prefix after longer", networks: []string{"10.1.0.0/16", "10.0.0.0/8"}, ip: "10.2.0.1", want: true},
+		{name: "ipv4 longer prefix after shorter", networks: []string{"10.0.0.0/8", "10.1.0.0/16"}, ip: "10.2.0.1", want: true},
+		{name: "ipv4 mapped address", networks: []string{"10.0.0.0/8"}, ip: "::ffff:10.1.2.3", want: true},
+		{name: "ipv6 inside", networks: []string{"2001:db8::/32"}, ip: "2001:db8::1", want: true},
+		{name: "ipv6 outside", networks: []string{"2001:db8::/32"}, ip: "2001:db9::1", want: false},
+		{name: "ipv6 network with ipv4", networks: []string{"2001:db8::/32"}, ip: "10.1.2.3", want: false},
+		{name: "ipv4 network with ipv6", networks: []string{"0.0.0.0/0"}, ip: "2001:db8::1", want: false},
+		{name: "ipv4-mapped network", networks: []string{"::ffff:10.0.0.0/104"}, ip: "10.1.2.3", want: true},
+		{name: "ipv4-mapped network with mapped address", networks: []string{"::ffff:10.0.0.0/104"}, ip: "::ffff:10.1.2.3", want: true},
+		{name: "ipv4-mapped network outside", networks: []string{"::ffff:10.0.0.0/104"}, ip: "11.1.2.3", want: false},
+		{name: "ipv4-mapped single address", networks: []string{"::ffff:192.0.2.1"}, ip: "192.0.2.1", want: true},
+		{name: "ipv4-mapped whole space", networks: []string{"::ffff:0.0.0.0/96"}, ip: "203.0.113.9", want: true},
+		{name: "empty", networks: nil, ip: "10.1.2.3", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			networks, err := clientip.ParseNetworks(tt.networks)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			trie := New()
+			for _, networkv := range networks {
+				trie.Insert(networkv)
+			}
+
+			if got := trie.Contains(net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("Contains(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+
+			if trie.Len() != len(tt.networks) {
+				t.Errorf("Len() = %d, want %d", trie.Len(), len(tt.networks))
+			}
+		})
+	}
+}
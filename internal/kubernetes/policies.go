@@ -0,0 +1,232 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"doorkeeper/api/v1alpha2"
+	"doorkeeper/internal/config"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+	informerResyncPeriod        = 10 * time.Minute
+	statusUpdateTimeout         = 10 * time.Second
+
+	ConditionTypeReady      = "Ready"
+	ConditionReasonAccepted = "Accepted"
+	ConditionReasonInvalid  = "Invalid"
+)
+
+var (
+	policyResource = schema.GroupVersionResource{
+		Group:    v1alpha2.PolicyGroup,
+		Version:  v1alpha2.PolicyVersion,
+		Resource: v1alpha2.PolicyResource,
+	}
+)
+
+// PolicyT is a DoorkeeperPolicy resource converted to a config source
+type PolicyT struct {
+	Namespace string
+	Name      string
+
+	Source config.SourceT
+	Err    error // set when the spec can not be decoded
+
+	object *unstructured.Unstructured
+}
+
+// PolicyWatcherT keeps an updated view of the DoorkeeperPolicy resources in a namespace
+type PolicyWatcherT struct {
+	client         dynamic.Interface
+	namespace      string
+	allowModifiers bool
+
+	factory  dynamicinformer.DynamicSharedInformerFactory
+	informer cache.SharedIndexInformer
+	changes  chan struct{}
+}
+
+func NewPolicyWatcher(cfg v1alpha2.KubernetesConfigT) (w *PolicyWatcherT, err error) {
+	if cfg.Namespace == "" {
+		var namespace []byte
+		namespace, err = os.ReadFile(serviceAccountNamespaceFile)
+		if err != nil {
+			return w, fmt.Errorf("namespace not set and unable to get the one of the pod: %s", err.Error())
+		}
+		cfg.Namespace = strings.TrimSpace(string(namespace))
+	}
+
+	var restConfig *rest.Config
+	if cfg.Kubeconfig != "" {
+		restConfig, err = clientcmd.BuildConfigFromFlags("", cfg.Kubeconfig)
+	} else {
+		restConfig, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return w, fmt.Errorf("unable to get kubernetes client config: %s", err.Error())
+	}
+
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return w, err
+	}
+
+	return newPolicyWatcher(client, cfg)
+}
+
+// newPolicyWatcher creates the watcher over an existing client, as the fake ones of the tests
+func newPolicyWatcher(client dynamic.Interface, cfg v1alpha2.KubernetesConfigT) (w *PolicyWatcherT, err error) {
+	w = &PolicyWatcherT{
+		client:         client,
+		namespace:      cfg.Namespace,
+		allowModifiers: cfg.AllowModifiers,
+		changes:        make(chan struct{}, 1),
+	}
+
+	w.factory = dynamicinformer.NewFilteredDynamicSharedInformerFactory(w.client, informerResyncPeriod, w.namespace, nil)
+	w.informer = w.factory.ForResource(policyResource).Informer()
+	_, err = w.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj any) { w.notify() },
+		UpdateFunc: func(oldObj, newObj any) {
+			// status updates do not change the generation, and must not trigger a rebuild
+			oldPolicy, oldOk := oldObj.(*unstructured.Unstructured)
+			newPolicy, newOk := newObj.(*unstructured.Unstructured)
+			if oldOk && newOk && oldPolicy.GetGeneration() == newPolicy.GetGeneration() {
+				return
+			}
+			w.notify()
+		},
+		DeleteFunc: func(obj any) { w.notify() },
+	})
+
+	return w, err
+}
+
+func (w *PolicyWatcherT) notify() {
+	select {
+	case w.changes <- struct{}{}:
+	default:
+	}
+}
+
+// Namespace returns the namespace where the policies are watched
+func (w *PolicyWatcherT) Namespace() string {
+	return w.namespace
+}
+
+// Changes returns a channel signaled when policies are created, changed or deleted
+func (w *PolicyWatcherT) Changes() <-chan struct{} {
+	return w.changes
+}
+
+// Start runs the informer and waits until the policies are synced
+func (w *PolicyWatcherT) Start(stop <-chan struct{}) error {
+	w.factory.Start(stop)
+
+	if !cache.WaitForCacheSync(stop, w.informer.HasSynced) {
+		return fmt.Errorf("stopped before policies were synced")
+	}
+
+	return nil
+}
+
+// Policies returns the current policies sorted by name
+func (w *PolicyWatcherT) Policies() (policies []PolicyT) {
+	for _, obj := range w.informer.GetStore().List() {
+		object, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+
+		policy := PolicyT{
+			Namespace: object.GetNamespace(),
+			Name:      object.GetName(),
+			object:    object,
+		}
+		name := fmt.Sprintf("%s %s/%s", v1alpha2.PolicyKind, policy.Namespace, policy.Name)
+
+		spec, _, err := unstructured.NestedMap(object.Object, "spec")
+		if err != nil {
+			policy.Err = fmt.Errorf("invalid spec in '%s': %s", name, err.Error())
+			policies = append(policies, policy)
+			continue
+		}
+
+		specBytes, err := json.Marshal(spec)
+		if err != nil {
+			policy.Err = fmt.Errorf("invalid spec in '%s': %s", name, err.Error())
+			policies = append(policies, policy)
+			continue
+		}
+
+		policy.Source, policy.Err = config.PolicySource(name, specBytes, w.allowModifiers)
+		policies = append(policies, policy)
+	}
+
+	sort.Slice(policies, func(i, j int) bool {
+		return policies[i].Name < policies[j].Name
+	})
+
+	return policies
+}
+
+// SetStatus reports in the Ready condition of the policy whether it was accepted,
+// or the error found validating it. The status is only updated when the condition changes
+func (w *PolicyWatcherT) SetStatus(policy PolicyT, validationErr error) error {
+	condition := map[string]any{
+		"type":               ConditionTypeReady,
+		"status":             string(metav1.ConditionTrue),
+		"reason":             ConditionReasonAccepted,
+		"message":            "policy merged into the config",
+		"observedGeneration": policy.object.GetGeneration(),
+	}
+	if validationErr != nil {
+		condition["status"] = string(metav1.ConditionFalse)
+		condition["reason"] = ConditionReasonInvalid
+		condition["message"] = validationErr.Error()
+	}
+
+	current, _, _ := unstructured.NestedSlice(policy.object.Object, "status", "conditions")
+	for _, cv := range current {
+		currentCondition, ok := cv.(map[string]any)
+		if !ok || currentCondition["type"] != ConditionTypeReady {
+			continue
+		}
+
+		if currentCondition["status"] == condition["status"] &&
+			currentCondition["reason"] == condition["reason"] &&
+			currentCondition["message"] == condition["message"] &&
+			currentCondition["observedGeneration"] == condition["observedGeneration"] {
+			return nil
+		}
+	}
+	condition["lastTransitionTime"] = time.Now().UTC().Format(time.RFC3339)
+
+	object := policy.object.DeepCopy()
+	err := unstructured.SetNestedSlice(object.Object, []any{condition}, "status", "conditions")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), statusUpdateTimeout)
+	defer cancel()
+
+	_, err = w.client.Resource(policyResource).Namespace(policy.Namespace).UpdateStatus(ctx, object, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,192 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"doorkeeper/api/v1alpha2"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+const testNamespace = "doorkeeper"
+
+func newTestPolicy(namespace, name string, spec map[string]any) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": v1alpha2.PolicyGroup + "/" + v1alpha2.PolicyVersion,
+		"kind":       v1alpha2.PolicyKind,
+		"metadata": map[string]any{
+			"namespace":  namespace,
+			"name":       name,
+			"generation": int64(1),
+		},
+		"spec": spec,
+	}}
+}
+
+// newTestWatcher creates a synced watcher over a fake client with the objects
+func newTestWatcher(t *testing.T, allowModifiers bool, objects ...runtime.Object) (w *PolicyWatcherT, client *fake.FakeDynamicClient) {
+	t.Helper()
+
+	client = fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{policyResource: v1alpha2.PolicyKind + "List"}, objects...)
+
+	w, err := newPolicyWatcher(client, v1alpha2.KubernetesConfigT{Namespace: testNamespace, AllowModifiers: allowModifiers})
+	if err != nil {
+		t.Fatalf("unable to create watcher: %v", err)
+	}
+
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+
+	if err = w.Start(stop); err != nil {
+		t.Fatalf("unable to start watcher: %v", err)
+	}
+
+	return w, client
+}
+
+var (
+	testAuthSpec = map[string]any{
+		"authorizations": []any{map[string]any{
+			"name":  "videos",
+			"type":  "MATCH",
+			"param": map[string]any{"type": "HEADER", "name": "x-original-uri"},
+			"match": map[string]any{"pattern": "^/videos/"},
+		}},
+		"requestAuthRequirements": []any{map[string]any{"name": "videos", "type": "all", "authorizations": []any{"videos"}}},
+	}
+	testModifierSpec = map[string]any{
+		"modifiers": []any{map[string]any{
+			"type": "PATH",
+			"path": map[string]any{"pattern": "^/v1", "replace": ""},
+		}},
+	}
+)
+
+func TestPolicies(t *testing.T) {
+	w, _ := newTestWatcher(t, false,
+		newTestPolicy(testNamespace, "b-videos", testAuthSpec),
+		newTestPolicy(testNamespace, "a-unknown-field", map[string]any{"unknown": true}),
+		newTestPolicy(testNamespace, "c-modifiers", testModifierSpec),
+		newTestPolicy("other", "a-other-namespace", testAuthSpec),
+	)
+
+	policies := w.Policies()
+
+	names := []string{}
+	for _, policyv := range policies {
+		names = append(names, policyv.Name)
+	}
+	if strings.Join(names, ",") != "a-unknown-field,b-videos,c-modifiers" {
+		t.Fatalf("Policies() = %v, want the ones in the namespace sorted by name", names)
+	}
+
+	tests := []struct {
+		policy  PolicyT
+		wantErr string
+	}{
+		{policy: policies[0], wantErr: "field unknown not found"},
+		{policy: policies[1]},
+		{policy: policies[2], wantErr: "modifiers are not allowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy.Name, func(t *testing.T) {
+			if tt.wantErr == "" {
+				if tt.policy.Err != nil {
+					t.Fatalf("unexpected error: %v", tt.policy.Err)
+				}
+				if !strings.Contains(string(tt.policy.Source.Content), "/videos/") {
+					t.Errorf("source does not contain the spec:\n%s", tt.policy.Source.Content)
+				}
+				return
+			}
+
+			if tt.policy.Err == nil || !strings.Contains(tt.policy.Err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it containing '%s'", tt.policy.Err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPoliciesAllowModifiers(t *testing.T) {
+	w, _ := newTestWatcher(t, true, newTestPolicy(testNamespace, "modifiers", testModifierSpec))
+
+	policies := w.Policies()
+	if len(policies) != 1 || policies[0].Err != nil {
+		t.Fatalf("Policies() = %+v, want the policy with modifiers accepted", policies)
+	}
+}
+
+func TestPoliciesChanges(t *testing.T) {
+	w, client := newTestWatcher(t, false)
+
+	// the initial sync may signal a change, not relevant here
+	select {
+	case <-w.Changes():
+	default:
+	}
+
+	_, err := client.Resource(policyResource).Namespace(testNamespace).Create(context.Background(),
+		newTestPolicy(testNamespace, "videos", testAuthSpec), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("unable to create policy: %v", err)
+	}
+
+	select {
+	case <-w.Changes():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no change signaled after creating a policy")
+	}
+
+	if policies := w.Policies(); len(policies) != 1 {
+		t.Errorf("Policies() = %+v, want the policy created", policies)
+	}
+}
+
+func TestSetStatus(t *testing.T) {
+	w, client := newTestWatcher(t, false, newTestPolicy(testNamespace, "videos", testAuthSpec))
+
+	getCondition := func() map[string]any {
+		t.Helper()
+
+		object, err := client.Resource(policyResource).Namespace(testNamespace).Get(context.Background(), "videos", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("unable to get policy: %v", err)
+		}
+
+		conditions, _, _ := unstructured.NestedSlice(object.Object, "status", "conditions")
+		if len(conditions) != 1 {
+			t.Fatalf("conditions = %v, want one", conditions)
+		}
+		return conditions[0].(map[string]any)
+	}
+
+	policy := w.Policies()[0]
+	if err := w.SetStatus(policy, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	condition := getCondition()
+	if condition["type"] != ConditionTypeReady || condition["status"] != string(metav1.ConditionTrue) || condition["reason"] != ConditionReasonAccepted {
+		t.Errorf("condition = %v, want the policy accepted", condition)
+	}
+
+	if err := w.SetStatus(policy, errors.New("authorization 'x' not found")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	condition = getCondition()
+	if condition["status"] != string(metav1.ConditionFalse) || condition["reason"] != ConditionReasonInvalid ||
+		condition["message"] != "authorization 'x' not found" {
+		t.Errorf("condition = %v, want the policy rejected with the error", condition)
+	}
+}
@@ -1,8 +1,15 @@
 package logger
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 )
 
 // ----------------------------------------------------------------
@@ -18,6 +25,15 @@ const (
 	ERROR LevelT = LevelT(slog.LevelError)
 
 	extraFieldName = "extra"
+
+	// Formats of the log lines
+	FormatJSON   = "JSON"
+	FormatLOGFMT = "LOGFMT"
+	FormatTEXT   = "TEXT"
+)
+
+var (
+	Formats = []string{FormatJSON, FormatLOGFMT, FormatTEXT}
 )
 
 type ExtraFieldsT map[string]any
@@ -32,52 +48,111 @@ func (e ExtraFieldsT) Del(key string) {
 
 type LoggerT struct {
 	logger *slog.Logger
+
+	// level is shared by the copies of the logger, so changing it is seen by all of them
+	level *slog.LevelVar
+
+	// flat is set for the formats writing the extra fields as attributes of the line,
+	// instead of an object
+	flat bool
+}
+
+// NewLogger creates a logger writing to stdout in the format given, JSON by default
+func NewLogger(level LevelT, format string) (logger LoggerT) {
+	return NewWriterLogger(os.Stdout, level, format)
 }
 
-func NewLogger(level LevelT) (logger LoggerT) {
+// NewWriterLogger creates a logger writing to the writer given
+func NewWriterLogger(writer io.Writer, level LevelT, format string) (logger LoggerT) {
+	logger.level = &slog.LevelVar{}
+	logger.level.Set(slog.Level(level))
+
 	opts := &slog.HandlerOptions{
 		AddSource: false,
-		Level:     slog.Level(level),
+		Level:     logger.level,
+	}
+
+	switch ParseFormat(format) {
+	case FormatLOGFMT:
+		{
+			logger.logger = slog.New(slog.NewTextHandler(writer, opts))
+			logger.flat = true
+		}
+	case FormatTEXT:
+		{
+			logger.logger = slog.New(newTextHandler(writer, opts))
+			logger.flat = true
+		}
+	default:
+		{
+			logger.logger = slog.New(slog.NewJSONHandler(writer, opts))
+		}
 	}
-	jsonHandler := slog.NewJSONHandler(os.Stdout, opts)
-	logger.logger = slog.New(jsonHandler)
 
 	return logger
 }
 
-func (l *LoggerT) Debug(msg string, extra ExtraFieldsT) {
-	if extra == nil {
-		extra = make(ExtraFieldsT)
+// ParseFormat returns the format of the lines written for the one given, JSON by default
+func ParseFormat(format string) string {
+	format = strings.ToUpper(format)
+	if !slices.Contains(Formats, format) {
+		format = FormatJSON
 	}
-	l.logger.Debug(msg, extraFieldName, extra)
+
+	return format
 }
 
-func (l *LoggerT) Info(msg string, extra ExtraFieldsT) {
+// SetLevel changes the verbosity of the logger, and of its copies, safely while it is used
+func (l *LoggerT) SetLevel(level LevelT) {
+	l.level.Set(slog.Level(level))
+}
+
+// Enabled returns whether the lines of the level are written, so expensive fields can be skipped
+func (l *LoggerT) Enabled(level LevelT) bool {
+	return l.logger.Enabled(context.Background(), slog.Level(level))
+}
+
+func (l *LoggerT) log(level slog.Level, msg string, extra ExtraFieldsT) {
 	if extra == nil {
 		extra = make(ExtraFieldsT)
 	}
-	l.logger.Info(msg, extraFieldName, extra)
+
+	if !l.flat {
+		l.logger.Log(context.Background(), level, msg, extraFieldName, extra)
+		return
+	}
+
+	keys := make([]string, 0, len(extra))
+	for keyv := range extra {
+		keys = append(keys, keyv)
+	}
+	slices.Sort(keys)
+
+	args := make([]any, 0, 2*len(keys))
+	for _, keyv := range keys {
+		args = append(args, keyv, extra[keyv])
+	}
+	l.logger.Log(context.Background(), level, msg, args...)
+}
+
+func (l *LoggerT) Debug(msg string, extra ExtraFieldsT) {
+	l.log(slog.LevelDebug, msg, extra)
+}
+
+func (l *LoggerT) Info(msg string, extra ExtraFieldsT) {
+	l.log(slog.LevelInfo, msg, extra)
 }
 
 func (l *LoggerT) Warn(msg string, extra ExtraFieldsT) {
-	if extra == nil {
-		extra = make(ExtraFieldsT)
-	}
-	l.logger.Warn(msg, extraFieldName, extra)
+	l.log(slog.LevelWarn, msg, extra)
 }
 
 func (l *LoggerT) Error(msg string, extra ExtraFieldsT) {
-	if extra == nil {
-		extra = make(ExtraFieldsT)
-	}
-	l.logger.Error(msg, extraFieldName, extra)
+	l.log(slog.LevelError, msg, extra)
 }
 
 func (l *LoggerT) Fatal(msg string, extra ExtraFieldsT) {
-	if extra == nil {
-		extra = make(ExtraFieldsT)
-	}
-	l.logger.Error(msg, extraFieldName, extra)
+	l.log(slog.LevelError, msg, extra)
 	os.Exit(1)
 }
 
@@ -96,3 +171,64 @@ func GetLevel(levelStr string) (l LevelT) {
 
 	return l
 }
+
+// ----------------------------------------------------------------
+// TEXT HANDLER
+// ----------------------------------------------------------------
+
+// textHandlerT writes lines for humans: '<time> <level> <msg> key=value...'
+type textHandlerT struct {
+	opts  *slog.HandlerOptions
+	attrs []slog.Attr
+
+	mutex  *sync.Mutex
+	writer io.Writer
+}
+
+func newTextHandler(writer io.Writer, opts *slog.HandlerOptions) *textHandlerT {
+	return &textHandlerT{
+		opts:   opts,
+		mutex:  &sync.Mutex{},
+		writer: writer,
+	}
+}
+
+func (h *textHandlerT) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.opts.Level.Level()
+}
+
+func (h *textHandlerT) Handle(_ context.Context, record slog.Record) error {
+	line := &strings.Builder{}
+	fmt.Fprintf(line, "%s %-5s %s", record.Time.UTC().Format(time.RFC3339Nano), record.Level.String(), record.Message)
+
+	writeAttr := func(attr slog.Attr) bool {
+		value := attr.Value.Resolve().String()
+		if strings.ContainsAny(value, " \t\n\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(line, " %s=%s", attr.Key, value)
+		return true
+	}
+
+	for _, attrv := range h.attrs {
+		writeAttr(attrv)
+	}
+	record.Attrs(writeAttr)
+	line.WriteString("\n")
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	_, err := io.WriteString(h.writer, line.String())
+	return err
+}
+
+func (h *textHandlerT) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handler := *h
+	handler.attrs = append(slices.Clone(h.attrs), attrs...)
+	return &handler
+}
+
+func (h *textHandlerT) WithGroup(_ string) slog.Handler {
+	return h
+}
@@ -0,0 +1,129 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestFormats(t *testing.T) {
+	extra := ExtraFieldsT{"path": "/video 1.mp4", "statusCode": 200, "decision": "allow"}
+
+	tests := []struct {
+		name   string
+		format string
+		want   *regexp.Regexp
+	}{
+		{
+			name:   "logfmt",
+			format: FormatLOGFMT,
+			want:   regexp.MustCompile(`^time=\S+ level=INFO msg="request decided" decision=allow path="/video 1.mp4" statusCode=200\n$`),
+		},
+		{
+			name:   "text",
+			format: "text",
+			want:   regexp.MustCompile(`^\S+Z INFO  request decided decision=allow path="/video 1.mp4" statusCode=200\n$`),
+		},
+		{
+			name:   "json by default",
+			format: "",
+			want:   regexp.MustCompile(`^\{"time":"\S+","level":"INFO","msg":"request decided","extra":\{"decision":"allow","path":"/video 1.mp4","statusCode":200\}\}\n$`),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := &bytes.Buffer{}
+			log := NewWriterLogger(output, INFO, tt.format)
+
+			log.Info("request decided", extra)
+			if !tt.want.MatchString(output.String()) {
+				t.Errorf("line = %q, want it matching %s", output.String(), tt.want)
+			}
+
+			if tt.format == "" && !json.Valid(output.Bytes()) {
+				t.Errorf("invalid json line %q", output.String())
+			}
+		})
+	}
+}
+
+func TestTextHandlerWithAttrs(t *testing.T) {
+	output := &bytes.Buffer{}
+	log := NewWriterLogger(output, INFO, FormatTEXT)
+	log.logger = log.logger.With("service", "doorkeeper")
+
+	log.Warn("policy rejected", ExtraFieldsT{"error": `invalid "cidr"`})
+
+	line := output.String()
+	if !strings.Contains(line, ` WARN  policy rejected service=doorkeeper error="invalid \"cidr\""`) {
+		t.Errorf("line = %q, want the attrs of the logger before the ones of the line", line)
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	for _, formatv := range Formats {
+		t.Run(formatv, func(t *testing.T) {
+			output := &bytes.Buffer{}
+			log := NewWriterLogger(output, INFO, formatv)
+
+			// copies share the level, as the logger is passed by value
+			copied := log
+
+			copied.Debug("hidden", nil)
+			if output.Len() != 0 || log.Enabled(DEBUG) {
+				t.Fatalf("debug line written with info level: %q", output.String())
+			}
+
+			log.SetLevel(DEBUG)
+			copied.Debug("shown", nil)
+			if !strings.Contains(output.String(), "shown") || !copied.Enabled(DEBUG) {
+				t.Errorf("debug line not written once the level changed: %q", output.String())
+			}
+
+			output.Reset()
+			log.SetLevel(ERROR)
+			copied.Warn("hidden", nil)
+			if output.Len() != 0 {
+				t.Errorf("warn line written with error level: %q", output.String())
+			}
+		})
+	}
+}
+
+func TestSetLevelConcurrently(t *testing.T) {
+	log := NewWriterLogger(&bytes.Buffer{}, INFO, FormatJSON)
+
+	wg := sync.WaitGroup{}
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%2 == 0 {
+				log.SetLevel(ERROR)
+				return
+			}
+			log.Enabled(DEBUG)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := map[string]string{
+		"":       FormatJSON,
+		"json":   FormatJSON,
+		"logfmt": FormatLOGFMT,
+		"TEXT":   FormatTEXT,
+		"xml":    FormatJSON,
+	}
+
+	for format, want := range tests {
+		if got := ParseFormat(format); got != want {
+			t.Errorf("ParseFormat(%q) = %s, want %s", format, got, want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	registryMutex sync.Mutex
+	registry      []*CounterVecT
+
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// CounterVecT counts events by the values of its labels
+type CounterVecT struct {
+	name   string
+	help   string
+	labels []string
+
+	countersMutex sync.RWMutex
+	counters      map[string]*counterT
+}
+
+type counterT struct {
+	labelValues []string
+	value       atomic.Uint64
+}
+
+// NewCounterVec creates a counter, exposed along with the rest of them in the metrics endpoint
+func NewCounterVec(name, help string, labels ...string) *CounterVecT {
+	c := &CounterVecT{
+		name:     name,
+		help:     help,
+		labels:   labels,
+		counters: map[string]*counterT{},
+	}
+
+	registryMutex.Lock()
+	registry = append(registry, c)
+	registryMutex.Unlock()
+
+	return c
+}
+
+// Inc increments the counter with the values of the labels, in the order they were defined
+func (c *CounterVecT) Inc(labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+
+	c.countersMutex.RLock()
+	counter, ok := c.counters[key]
+	c.countersMutex.RUnlock()
+
+	if !ok {
+		c.countersMutex.Lock()
+		counter, ok = c.counters[key]
+		if !ok {
+			counter = &counterT{labelValues: labelValues}
+			c.counters[key] = counter
+		}
+		c.countersMutex.Unlock()
+	}
+
+	counter.value.Add(1)
+}
+
+// write writes the counter in Prometheus text format
+func (c *CounterVecT) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", c.name, c.help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", c.name)
+
+	c.countersMutex.RLock()
+	keys := make([]string, 0, len(c.counters))
+	for keyv := range c.counters {
+		keys = append(keys, keyv)
+	}
+	slices.Sort(keys)
+
+	for _, keyv := range keys {
+		counter := c.counters[keyv]
+
+		labels := []string{}
+		for labeli, labelv := range c.labels {
+			value := ""
+			if labeli < len(counter.labelValues) {
+				value = counter.labelValues[labeli]
+			}
+			labels = append(labels, fmt.Sprintf(`%s="%s"`, labelv, labelValueEscaper.Replace(value)))
+		}
+
+		fmt.Fprintf(w, "%s{%s} %d\n", c.name, strings.Join(labels, ","), counter.value.Load())
+	}
+	c.countersMutex.RUnlock()
+}
+
+// Handler serves all the counters in Prometheus text format
+func Handler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	for _, counterv := range registry {
+		counterv.write(w)
+	}
+}
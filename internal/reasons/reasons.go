@@ -0,0 +1,77 @@
+package reasons
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Reasons of the denials. They are stable, so they can be aggregated
+// in metrics and used by clients of the responses
+const (
+	MissingParam   = "missing_param"
+	InvalidParam   = "invalid_param"
+	MissingField   = "missing_field"
+	Expired        = "expired"
+	NotYetValid    = "not_yet_valid"
+	BadSignature   = "bad_signature"
+	UnknownKey     = "unknown_key"
+	IpNotAllowed   = "ip_not_allowed"
+	PathNotAllowed = "path_not_allowed"
+	GeoNotAllowed  = "geo_not_allowed"
+	OutOfSchedule  = "out_of_schedule"
+	NoMatch        = "no_match"
+	NoRequirements = "no_requirements"
+	Internal       = "internal"
+)
+
+var (
+	Reasons = []string{
+		MissingParam,
+		InvalidParam,
+		MissingField,
+		Expired,
+		NotYetValid,
+		BadSignature,
+		UnknownKey,
+		IpNotAllowed,
+		PathNotAllowed,
+		GeoNotAllowed,
+		OutOfSchedule,
+		NoMatch,
+		NoRequirements,
+		Internal,
+	}
+)
+
+// ErrorT is an error of a check, with the reason of the denial.
+// Messages must never include secret material, as they are logged
+type ErrorT struct {
+	Reason  string
+	Message string
+}
+
+func (e *ErrorT) Error() string {
+	return e.Message
+}
+
+// Errorf returns an error with the reason and the formatted message
+func Errorf(reason string, format string, args ...any) error {
+	return &ErrorT{
+		Reason:  reason,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// Get returns the reason of the error. Errors without reason are internal ones
+func Get(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var reasonErr *ErrorT
+	if errors.As(err, &reasonErr) {
+		return reasonErr.Reason
+	}
+
+	return Internal
+}
@@ -0,0 +1,217 @@
+package redaction
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"doorkeeper/api/v1alpha2"
+	"doorkeeper/internal/utils"
+)
+
+const (
+	ModeMASK = "MASK"
+	ModeHASH = "HASH"
+
+	// maskedValue replaces the values redacted in MASK mode
+	maskedValue = "[REDACTED]"
+
+	// hashedLength is the number of hex characters kept from the hashes in HASH mode
+	hashedLength = 16
+)
+
+var (
+	Modes = []string{ModeMASK, ModeHASH}
+
+	// DefaultHeaders are always redacted, as they carry credentials
+	DefaultHeaders = []string{
+		"authorization",
+		"proxy-authorization",
+		"cookie",
+		"set-cookie",
+		"x-api-key",
+		"x-auth-token",
+	}
+
+	// DefaultQueryParams are always redacted, as they carry tokens and signatures
+	DefaultQueryParams = []string{
+		"token",
+		"__token__",
+		"hmac",
+		"sig",
+		"signature",
+		"x-amz-signature",
+		"key",
+		"api_key",
+		"access_token",
+		"policy",
+		"key-pair-id",
+	}
+)
+
+// PathTokenI is implemented by the authorizations taking credentials from the path,
+// returning where they are in it, so they are redacted from the paths logged
+type PathTokenI interface {
+	PathToken(path string) (start, end int, found bool)
+}
+
+// RedactorT removes sensitive values from the requests before logging them
+type RedactorT struct {
+	mode string
+
+	pathTokens []PathTokenI
+
+	headers       []string
+	queryParams   []string
+	headerRegexes []*regexp.Regexp
+	queryRegexes  []*regexp.Regexp
+}
+
+func NewRedactor(cfg v1alpha2.RedactionConfigT, pathTokens []PathTokenI) (r *RedactorT, err error) {
+	r = &RedactorT{
+		mode:       cfg.Mode,
+		pathTokens: pathTokens,
+	}
+
+	if r.mode == "" {
+		r.mode = ModeMASK
+	}
+
+	if !slices.Contains(Modes, r.mode) {
+		return r, fmt.Errorf("invalid redaction mode '%s'", r.mode)
+	}
+
+	r.headers = append(r.headers, DefaultHeaders...)
+	for _, headerv := range cfg.Headers {
+		r.headers = append(r.headers, strings.ToLower(headerv))
+	}
+
+	r.queryParams = append(r.queryParams, DefaultQueryParams...)
+	for _, paramv := range cfg.QueryParams {
+		r.queryParams = append(r.queryParams, strings.ToLower(paramv))
+	}
+
+	r.headerRegexes, err = compileRegexes(cfg.HeaderPatterns)
+	if err != nil {
+		return r, err
+	}
+
+	r.queryRegexes, err = compileRegexes(cfg.QueryParamPatterns)
+	return r, err
+}
+
+func compileRegexes(patterns []string) (regexes []*regexp.Regexp, err error) {
+	for _, patternv := range patterns {
+		compiledRegex, err := regexp.Compile(patternv)
+		if err != nil {
+			return regexes, fmt.Errorf("invalid pattern '%s': %s", patternv, err.Error())
+		}
+		regexes = append(regexes, compiledRegex)
+	}
+
+	return regexes, err
+}
+
+// RequestLogStruct returns the request as logged, with the sensitive values redacted
+func (r *RedactorT) RequestLogStruct(req *http.Request) (reqLog utils.RequestLogT) {
+	reqLog = utils.RequestLogStruct(req)
+	reqLog.Path = r.RedactPath(reqLog.Path)
+
+	for hk, hvs := range reqLog.Headers {
+		if !matches(strings.ToLower(hk), r.headers, r.headerRegexes) {
+			continue
+		}
+
+		for hvi := range hvs {
+			hvs[hvi] = r.redact(hvs[hvi])
+		}
+	}
+
+	reqLog.QueryParams = r.redactQuery(reqLog.QueryParams)
+
+	return reqLog
+}
+
+// RedactPath returns the path with the credentials of the authorizations redacted
+func (r *RedactorT) RedactPath(path string) string {
+	bounds := [][2]int{}
+	for _, tokenv := range r.pathTokens {
+		start, end, found := tokenv.PathToken(path)
+		if found && start < end {
+			bounds = append(bounds, [2]int{start, end})
+		}
+	}
+
+	// replaced from the end, so the bounds before are still valid. Overlapping ones are skipped
+	slices.SortFunc(bounds, func(a, b [2]int) int { return b[0] - a[0] })
+
+	redacted := path
+	last := len(path)
+	for _, boundv := range bounds {
+		if boundv[1] > last {
+			continue
+		}
+
+		redacted = redacted[:boundv[0]] + r.redact(path[boundv[0]:boundv[1]]) + redacted[boundv[1]:]
+		last = boundv[0]
+	}
+
+	return redacted
+}
+
+// redactQuery redacts the values of the params in the raw query,
+// keeping their order and encoding
+func (r *RedactorT) redactQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return rawQuery
+	}
+
+	params := strings.Split(rawQuery, "&")
+	for parami, paramv := range params {
+		name, value, found := strings.Cut(paramv, "=")
+		if !found {
+			continue
+		}
+
+		unescapedName, err := url.QueryUnescape(name)
+		if err != nil {
+			unescapedName = name
+		}
+
+		if matches(strings.ToLower(unescapedName), r.queryParams, r.queryRegexes) {
+			params[parami] = name + "=" + r.redact(value)
+		}
+	}
+
+	return strings.Join(params, "&")
+}
+
+// redact returns the value masked, or a short hash of it, so equal
+// values can still be correlated across logs
+func (r *RedactorT) redact(value string) string {
+	if r.mode == ModeHASH {
+		hash := sha256.Sum256([]byte(value))
+		return "sha256:" + hex.EncodeToString(hash[:])[:hashedLength]
+	}
+
+	return maskedValue
+}
+
+func matches(name string, names []string, regexes []*regexp.Regexp) bool {
+	if slices.Contains(names, name) {
+		return true
+	}
+
+	for _, regexv := range regexes {
+		if regexv.MatchString(name) {
+			return true
+		}
+	}
+
+	return false
+}
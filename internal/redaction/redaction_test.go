@@ -0,0 +1,216 @@
+package redaction
+
+import (
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"doorkeeper/api/v1alpha2"
+)
+
+// regexTokenT locates the first group of the regex in the path, as the authorizations do
+type regexTokenT struct {
+	regex *regexp.Regexp
+}
+
+func (t regexTokenT) PathToken(path string) (start, end int, found bool) {
+	match := t.regex.FindStringSubmatchIndex(path)
+	if len(match) > 3 && match[2] >= 0 {
+		return match[2], match[3], true
+	}
+	return 0, 0, false
+}
+
+func TestRequestLogStruct(t *testing.T) {
+	hashed := func(value string) string {
+		r, _ := NewRedactor(v1alpha2.RedactionConfigT{Mode: ModeHASH}, nil)
+		return r.redact(value)
+	}
+
+	tests := []struct {
+		name        string
+		cfg         v1alpha2.RedactionConfigT
+		target      string
+		headers     map[string]string
+		wantQuery   string
+		wantHeaders map[string]string
+	}{
+		{
+			name:        "default names masked",
+			target:      "/video.mp4?b=1&token=secret&a=2",
+			headers:     map[string]string{"Authorization": "Bearer secret", "Accept": "*/*"},
+			wantQuery:   "b=1&token=[REDACTED]&a=2",
+			wantHeaders: map[string]string{"Authorization": "[REDACTED]", "Accept": "*/*"},
+		},
+		{
+			name:        "default names hashed",
+			cfg:         v1alpha2.RedactionConfigT{Mode: ModeHASH},
+			target:      "/video.mp4?sig=secret",
+			headers:     map[string]string{"Cookie": "session=secret"},
+			wantQuery:   "sig=" + hashed("secret"),
+			wantHeaders: map[string]string{"Cookie": hashed("session=secret")},
+		},
+		{
+			name: "configured names",
+			cfg: v1alpha2.RedactionConfigT{
+				Headers:     []string{"X-Tenant-Secret"},
+				QueryParams: []string{"Code"},
+			},
+			target:      "/?code=1234&state=abc",
+			headers:     map[string]string{"X-Tenant-Secret": "secret"},
+			wantQuery:   "code=[REDACTED]&state=abc",
+			wantHeaders: map[string]string{"X-Tenant-Secret": "[REDACTED]"},
+		},
+		{
+			name: "patterns",
+			cfg: v1alpha2.RedactionConfigT{
+				HeaderPatterns:     []string{"^x-internal-"},
+				QueryParamPatterns: []string{"_secret$"},
+			},
+			target:      "/?client_secret=1&secretive=2",
+			headers:     map[string]string{"X-Internal-Key": "secret", "X-Public": "value"},
+			wantQuery:   "client_secret=[REDACTED]&secretive=2",
+			wantHeaders: map[string]string{"X-Internal-Key": "[REDACTED]", "X-Public": "value"},
+		},
+		{
+			name:      "order and encoding kept",
+			target:    "/?z=%2F&Token=a%20b&flag&a=1",
+			wantQuery: "z=%2F&Token=[REDACTED]&flag&a=1",
+		},
+		{
+			name:      "escaped names",
+			target:    "/?%5F%5Ftoken%5F%5F=secret",
+			wantQuery: "%5F%5Ftoken%5F%5F=[REDACTED]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewRedactor(tt.cfg, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			req := httptest.NewRequest("GET", tt.target, nil)
+			for hk, hv := range tt.headers {
+				req.Header.Set(hk, hv)
+			}
+			rawQuery := req.URL.RawQuery
+
+			reqLog := r.RequestLogStruct(req)
+			if reqLog.QueryParams != tt.wantQuery {
+				t.Errorf("query = %s, want %s", reqLog.QueryParams, tt.wantQuery)
+			}
+
+			for hk, hv := range tt.wantHeaders {
+				if got := reqLog.Headers.Get(hk); got != hv {
+					t.Errorf("header %s = %s, want %s", hk, got, hv)
+				}
+			}
+
+			// the request keeps its values, as it is still checked and forwarded
+			if req.URL.RawQuery != rawQuery {
+				t.Errorf("request query changed to %s", req.URL.RawQuery)
+			}
+			for hk, hv := range tt.headers {
+				if got := req.Header.Get(hk); got != hv {
+					t.Errorf("request header %s changed to %s", hk, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRedactPath(t *testing.T) {
+	segment := regexTokenT{regexp.MustCompile(`^/t/([^/]+)/`)}
+	suffix := regexTokenT{regexp.MustCompile(`/hdnts=([^/]+)$`)}
+
+	tests := []struct {
+		name       string
+		mode       string
+		pathTokens []PathTokenI
+		path       string
+		want       string
+	}{
+		{
+			name: "without tokens",
+			path: "/t/secret/video.mp4",
+			want: "/t/secret/video.mp4",
+		},
+		{
+			name:       "token masked",
+			pathTokens: []PathTokenI{segment},
+			path:       "/t/secret/video.mp4",
+			want:       "/t/[REDACTED]/video.mp4",
+		},
+		{
+			name:       "token hashed",
+			mode:       ModeHASH,
+			pathTokens: []PathTokenI{segment},
+			path:       "/t/secret/video.mp4",
+			want:       "/t/sha256:2bb80d537b1da3e3/video.mp4",
+		},
+		{
+			name:       "several tokens",
+			pathTokens: []PathTokenI{segment, suffix},
+			path:       "/t/secret/video.mp4/hdnts=other",
+			want:       "/t/[REDACTED]/video.mp4/hdnts=[REDACTED]",
+		},
+		{
+			name:       "token not found",
+			pathTokens: []PathTokenI{segment},
+			path:       "/video.mp4",
+			want:       "/video.mp4",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewRedactor(v1alpha2.RedactionConfigT{Mode: tt.mode}, tt.pathTokens)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := r.RedactPath(tt.path); got != tt.want {
+				t.Errorf("RedactPath() = %s, want %s", got, tt.want)
+			}
+
+			req := httptest.NewRequest("GET", tt.path+"?a=1", nil)
+			if got := r.RequestLogStruct(req).Path; got != tt.want {
+				t.Errorf("logged path = %s, want %s", got, tt.want)
+			}
+			if req.URL.Path != tt.path {
+				t.Errorf("request path changed to %s", req.URL.Path)
+			}
+		})
+	}
+}
+
+func TestNewRedactor(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     v1alpha2.RedactionConfigT
+		wantErr string
+	}{
+		{name: "defaults"},
+		{name: "invalid mode", cfg: v1alpha2.RedactionConfigT{Mode: "ERASE"}, wantErr: "invalid redaction mode"},
+		{name: "invalid pattern", cfg: v1alpha2.RedactionConfigT{HeaderPatterns: []string{"("}}, wantErr: "invalid pattern"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRedactor(tt.cfg, nil)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewRedactor() error = %v, want it containing '%s'", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package requestid
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"doorkeeper/api/v1alpha2"
+)
+
+const (
+	GeneratorUUIDV7 = "UUIDV7"
+	GeneratorULID   = "ULID"
+
+	HeaderTraceparent = "traceparent"
+
+	// maxLength is the longest id taken from the requests, so they can not flood the logs
+	maxLength = 128
+)
+
+var (
+	Generators = []string{GeneratorUUIDV7, GeneratorULID}
+
+	DefaultHeaders        = []string{"x-request-id", HeaderTraceparent}
+	DefaultResponseHeader = "x-request-id"
+
+	// crockfordAlphabet is the base32 alphabet of ULIDs
+	crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+)
+
+// ResolverT gets the ids of the requests from the headers set by the proxies in front,
+// generating unique ones when they are not present
+type ResolverT struct {
+	headers        []string
+	responseHeader string
+	generator      string
+}
+
+func NewResolver(cfg v1alpha2.RequestIdConfigT) (r *ResolverT, err error) {
+	r = &ResolverT{
+		headers:        cfg.Headers,
+		responseHeader: cfg.ResponseHeader,
+		generator:      cfg.Generator,
+	}
+
+	if len(r.headers) == 0 {
+		r.headers = DefaultHeaders
+	}
+
+	if r.responseHeader == "" {
+		r.responseHeader = DefaultResponseHeader
+	}
+
+	if r.generator == "" {
+		r.generator = GeneratorUUIDV7
+	}
+
+	if !slices.Contains(Generators, r.generator) {
+		return r, fmt.Errorf("invalid request id generator '%s'", r.generator)
+	}
+
+	return r, err
+}
+
+// ResponseHeader returns the header the ids are sent back in
+func (r *ResolverT) ResponseHeader() string {
+	return r.responseHeader
+}
+
+// FromRequest returns the id in the first header present with a valid value,
+// or a new one. The trace id is taken from W3C traceparent headers
+func (r *ResolverT) FromRequest(req *http.Request) string {
+	for _, headerv := range r.headers {
+		id := strings.TrimSpace(req.Header.Get(headerv))
+		if strings.EqualFold(headerv, HeaderTraceparent) {
+			id = traceId(id)
+		}
+
+		if validId(id) {
+			return id
+		}
+	}
+
+	return r.Generate()
+}
+
+// Generate returns a new unique id, sortable by creation time
+func (r *ResolverT) Generate() string {
+	if r.generator == GeneratorULID {
+		return newULID(time.Now())
+	}
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		return newULID(time.Now())
+	}
+
+	return id.String()
+}
+
+// traceId returns the trace id of a traceparent header: {version}-{trace-id}-{parent-id}-{flags}
+func traceId(traceparent string) string {
+	parts := strings.Split(traceparent, "-")
+	if len(parts) < 4 || len(parts[1]) != 32 || strings.Trim(parts[1], "0") == "" {
+		return ""
+	}
+
+	if _, err := hex.DecodeString(parts[1]); err != nil {
+		return ""
+	}
+
+	return strings.ToLower(parts[1])
+}
+
+// validId returns whether the id is short and only has printable ASCII characters,
+// so ids from the requests can not inject content in the logs nor in the headers
+func validId(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newULID returns a ULID: 48 bits of unix time in milliseconds and 80 random bits,
+// encoded in Crockford's base32
+func newULID(t time.Time) string {
+	var data [16]byte
+	binary.BigEndian.PutUint64(data[:8], uint64(t.UnixMilli())<<16)
+	_, _ = rand.Read(data[6:])
+
+	// 128 bits are encoded in 26 characters of 5 bits, the first one only taking 3 bits
+	id := make([]byte, 26)
+	high := binary.BigEndian.Uint64(data[:8])
+	low := binary.BigEndian.Uint64(data[8:])
+	for i := 25; i >= 0; i-- {
+		id[i] = crockfordAlphabet[low&0x1f]
+		low = (low >> 5) | (high << 59)
+		high >>= 5
+	}
+
+	return string(id)
+}
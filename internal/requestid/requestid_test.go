@@ -0,0 +1,222 @@
+package requestid
+
+import (
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"doorkeeper/api/v1alpha2"
+)
+
+var (
+	uuidRegex = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	ulidRegex = regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+)
+
+func TestFromRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     v1alpha2.RequestIdConfigT
+		headers map[string]string
+		want    string
+	}{
+		{
+			name:    "request id header",
+			headers: map[string]string{"X-Request-Id": " abc-123 "},
+			want:    "abc-123",
+		},
+		{
+			name:    "trace id of traceparent",
+			headers: map[string]string{"Traceparent": "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"},
+			want:    "4bf92f3577b34da6a3ce929d0e0e4736",
+		},
+		{
+			name:    "first header present",
+			headers: map[string]string{"X-Request-Id": "abc", "Traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+			want:    "abc",
+		},
+		{
+			name:    "next header when the first is invalid",
+			headers: map[string]string{"X-Request-Id": "abc def", "Traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+			want:    "4bf92f3577b34da6a3ce929d0e0e4736",
+		},
+		{
+			name:    "configured headers",
+			cfg:     v1alpha2.RequestIdConfigT{Headers: []string{"x-amzn-trace-id"}},
+			headers: map[string]string{"X-Request-Id": "abc", "X-Amzn-Trace-Id": "Root=1-67891233-abcdef012345678912345678"},
+			want:    "Root=1-67891233-abcdef012345678912345678",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewResolver(tt.cfg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			req := httptest.NewRequest("GET", "/", nil)
+			for hk, hv := range tt.headers {
+				req.Header.Set(hk, hv)
+			}
+
+			if got := r.FromRequest(req); got != tt.want {
+				t.Errorf("FromRequest() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTraceId(t *testing.T) {
+	tests := []struct {
+		name        string
+		traceparent string
+		want        string
+	}{
+		{name: "valid", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", want: "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{name: "future version with more fields", traceparent: "cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", want: "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{name: "all zeros", traceparent: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{name: "short trace id", traceparent: "00-4bf92f3577b34da6-00f067aa0ba902b7-01"},
+		{name: "not hex", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01"},
+		{name: "missing fields", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736"},
+		{name: "empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := traceId(tt.traceparent); got != tt.want {
+				t.Errorf("traceId(%q) = %q, want %q", tt.traceparent, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidId(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+		want bool
+	}{
+		{name: "printable", id: "abc-123_XYZ.~", want: true},
+		{name: "max length", id: strings.Repeat("a", maxLength), want: true},
+		{name: "empty"},
+		{name: "oversized", id: strings.Repeat("a", maxLength+1)},
+		{name: "space", id: "abc def"},
+		{name: "new line", id: "abc\nlevel=ERROR"},
+		{name: "control character", id: "abc\x1b[31m"},
+		{name: "non ascii", id: "abcñ"},
+		{name: "delete", id: "abc\x7f"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validId(tt.id); got != tt.want {
+				t.Errorf("validId(%q) = %v, want %v", tt.id, got, tt.want)
+			}
+		})
+	}
+
+	// invalid ids are replaced by generated ones
+	r, _ := NewResolver(v1alpha2.RequestIdConfigT{})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-Id", strings.Repeat("a", maxLength+1))
+	if got := r.FromRequest(req); !uuidRegex.MatchString(got) {
+		t.Errorf("FromRequest() = %s, want a generated id", got)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		name      string
+		generator string
+		regex     *regexp.Regexp
+	}{
+		{name: "uuidv7 by default", regex: uuidRegex},
+		{name: "uuidv7", generator: GeneratorUUIDV7, regex: uuidRegex},
+		{name: "ulid", generator: GeneratorULID, regex: ulidRegex},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewResolver(v1alpha2.RequestIdConfigT{Generator: tt.generator})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// ids are unique, and sorted by creation time across milliseconds
+			ids := []string{}
+			for range 3 {
+				ids = append(ids, r.Generate(), r.Generate())
+				time.Sleep(2 * time.Millisecond)
+			}
+
+			for idi, idv := range ids {
+				if !tt.regex.MatchString(idv) {
+					t.Errorf("id %q does not match %s", idv, tt.regex)
+				}
+
+				if slices.Index(ids, idv) != idi {
+					t.Errorf("id %q generated twice", idv)
+				}
+
+				if idi >= 2 && idi%2 == 0 && idv <= ids[idi-2] {
+					t.Errorf("id %q not sorted after %q", idv, ids[idi-2])
+				}
+			}
+		})
+	}
+}
+
+func TestULIDTime(t *testing.T) {
+	at := time.UnixMilli(1700000000123)
+	id := newULID(at)
+
+	// the first 10 characters encode the time in milliseconds
+	ms := uint64(0)
+	for _, c := range id[:10] {
+		ms = ms<<5 | uint64(strings.IndexRune(crockfordAlphabet, c))
+	}
+
+	if ms != uint64(at.UnixMilli()) {
+		t.Errorf("time of ULID %s = %d, want %d", id, ms, at.UnixMilli())
+	}
+
+	if later := newULID(at.Add(time.Millisecond)); later <= id {
+		t.Errorf("ULID %s not sorted after %s", later, id)
+	}
+}
+
+func TestUUIDV7Time(t *testing.T) {
+	r, _ := NewResolver(v1alpha2.RequestIdConfigT{})
+
+	before := time.Now().Truncate(time.Millisecond)
+	id, err := uuid.Parse(r.Generate())
+	if err != nil {
+		t.Fatalf("invalid uuid: %v", err)
+	}
+
+	if id.Version() != 7 {
+		t.Errorf("uuid version = %d, want 7", id.Version())
+	}
+
+	sec, nsec := id.Time().UnixTime()
+	if at := time.Unix(sec, nsec); at.Before(before) || at.After(time.Now()) {
+		t.Errorf("time of uuid = %v, want the current time", at)
+	}
+}
+
+func TestNewResolver(t *testing.T) {
+	r, err := NewResolver(v1alpha2.RequestIdConfigT{})
+	if err != nil || r.ResponseHeader() != DefaultResponseHeader {
+		t.Errorf("NewResolver() = (%s, %v), want the default response header", r.ResponseHeader(), err)
+	}
+
+	if _, err = NewResolver(v1alpha2.RequestIdConfigT{Generator: "SNOWFLAKE"}); err == nil {
+		t.Errorf("NewResolver() error = nil, want the invalid generator")
+	}
+}
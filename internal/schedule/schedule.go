@@ -0,0 +1,196 @@
+package schedule
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	// time zones are embedded, so they work in images without tzdata
+	_ "time/tzdata"
+
+	"doorkeeper/api/v1alpha2"
+)
+
+var (
+	// Days are the values allowed for the days of the week in windows
+	Days = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+
+	// dateLayouts are tried in order to parse the dates of windows.
+	// Dates without offset are considered in the time zone of the schedule
+	dateLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"}
+)
+
+// windowT is a period of time. Fields not set are not restricted
+type windowT struct {
+	days map[time.Weekday]bool
+
+	// minutes since midnight, to is exclusive. When from is greater
+	// than to, the window crosses midnight (e.g. 22:00 to 06:00)
+	hours bool
+	from  int
+	to    int
+
+	start time.Time
+	end   time.Time
+}
+
+// ScheduleT is a set of time windows in a time zone
+type ScheduleT struct {
+	location *time.Location
+	windows  []windowT
+}
+
+func NewSchedule(cfg v1alpha2.ScheduleConfigT) (s *ScheduleT, err error) {
+	s = &ScheduleT{location: time.UTC}
+
+	if cfg.TimeZone != "" {
+		s.location, err = time.LoadLocation(cfg.TimeZone)
+		if err != nil {
+			return s, fmt.Errorf("invalid time zone '%s': %s", cfg.TimeZone, err.Error())
+		}
+	}
+
+	if len(cfg.Windows) == 0 {
+		return s, fmt.Errorf("no windows defined")
+	}
+
+	for _, windowv := range cfg.Windows {
+		var window windowT
+		window, err = s.parseWindow(windowv)
+		if err != nil {
+			return s, err
+		}
+		s.windows = append(s.windows, window)
+	}
+
+	return s, err
+}
+
+func (s *ScheduleT) parseWindow(cfg v1alpha2.ScheduleWindowConfigT) (w windowT, err error) {
+	if len(cfg.Days) == 0 && cfg.From == "" && cfg.To == "" && cfg.Start == "" && cfg.End == "" {
+		return w, fmt.Errorf("windows must set days, hours or dates")
+	}
+
+	if len(cfg.Days) > 0 {
+		w.days = map[time.Weekday]bool{}
+		for _, dayv := range cfg.Days {
+			day := slices.Index(Days, strings.ToUpper(dayv))
+			if day == -1 {
+				return w, fmt.Errorf("days in windows must be some of %v", Days)
+			}
+			w.days[time.Weekday(day)] = true
+		}
+	}
+
+	if cfg.From != "" || cfg.To != "" {
+		w.hours = true
+
+		w.from, err = parseHour(cfg.From)
+		if err != nil {
+			return w, err
+		}
+
+		w.to, err = parseHour(cfg.To)
+		if err != nil {
+			return w, err
+		}
+	}
+
+	if cfg.Start != "" {
+		w.start, err = s.parseDate(cfg.Start)
+		if err != nil {
+			return w, err
+		}
+	}
+
+	if cfg.End != "" {
+		w.end, err = s.parseDate(cfg.End)
+		if err != nil {
+			return w, err
+		}
+	}
+
+	if !w.start.IsZero() && !w.end.IsZero() && !w.end.After(w.start) {
+		return w, fmt.Errorf("end of windows must be after their start")
+	}
+
+	return w, err
+}
+
+// parseHour returns the minutes since midnight of a 'HH:MM' time.
+// Empty values are the midnight, so a window can be open at any of its sides
+func parseHour(value string) (minutes int, err error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	if value == "24:00" {
+		return 24 * 60, nil
+	}
+
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hour '%s', must be in HH:MM format", value)
+	}
+
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+func (s *ScheduleT) parseDate(value string) (date time.Time, err error) {
+	for _, layoutv := range dateLayouts {
+		date, err = time.ParseInLocation(layoutv, value, s.location)
+		if err == nil {
+			return date, nil
+		}
+	}
+
+	return date, fmt.Errorf("invalid date '%s', must be in RFC3339 or YYYY-MM-DD[THH:MM[:SS]] format", value)
+}
+
+// Contains returns whether the time is inside any of the windows
+func (s *ScheduleT) Contains(t time.Time) bool {
+	t = t.In(s.location)
+	for _, windowv := range s.windows {
+		if windowv.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *windowT) contains(t time.Time) bool {
+	if !w.start.IsZero() && t.Before(w.start) {
+		return false
+	}
+
+	if !w.end.IsZero() && !t.Before(w.end) {
+		return false
+	}
+
+	// in windows crossing midnight, the hours after it belong to the day before
+	day := t.Weekday()
+	if w.hours {
+		minutes := t.Hour()*60 + t.Minute()
+
+		switch {
+		case w.from < w.to:
+			if minutes < w.from || minutes >= w.to {
+				return false
+			}
+		case w.from > w.to:
+			if minutes < w.from && minutes >= w.to {
+				return false
+			}
+			if minutes < w.to {
+				day = (day + 6) % 7
+			}
+		}
+	}
+
+	if w.days != nil && !w.days[day] {
+		return false
+	}
+
+	return true
+}
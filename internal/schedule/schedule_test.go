@@ -0,0 +1,103 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"doorkeeper/api/v1alpha2"
+)
+
+func TestContains(t *testing.T) {
+	workHours := v1alpha2.ScheduleWindowConfigT{Days: []string{"MON", "TUE", "WED", "THU", "FRI"}, From: "09:00", To: "18:00"}
+	fridayNight := v1alpha2.ScheduleWindowConfigT{Days: []string{"FRI"}, From: "22:00", To: "06:00"}
+	earlyMorning := v1alpha2.ScheduleWindowConfigT{From: "01:00", To: "04:00"}
+	skippedHour := v1alpha2.ScheduleWindowConfigT{From: "02:00", To: "03:00"}
+
+	tests := []struct {
+		name     string
+		timeZone string
+		windows  []v1alpha2.ScheduleWindowConfigT
+		time     string
+		want     bool
+	}{
+		// 2024-03-01 is a friday
+		{name: "inside work hours", windows: []v1alpha2.ScheduleWindowConfigT{workHours}, time: "2024-03-01T09:00:00Z", want: true},
+		{name: "end of work hours is exclusive", windows: []v1alpha2.ScheduleWindowConfigT{workHours}, time: "2024-03-01T18:00:00Z", want: false},
+		{name: "weekend", windows: []v1alpha2.ScheduleWindowConfigT{workHours}, time: "2024-03-02T10:00:00Z", want: false},
+		{name: "work hours in time zone", timeZone: "Europe/Madrid", windows: []v1alpha2.ScheduleWindowConfigT{workHours}, time: "2024-03-01T08:30:00Z", want: true},
+		{name: "work hours in time zone, utc inside", timeZone: "Europe/Madrid", windows: []v1alpha2.ScheduleWindowConfigT{workHours}, time: "2024-03-01T17:30:00Z", want: false},
+		{name: "lowercase days", windows: []v1alpha2.ScheduleWindowConfigT{{Days: []string{"fri"}}}, time: "2024-03-01T23:59:00Z", want: true},
+
+		// windows crossing midnight belong to the day they start
+		{name: "friday night before midnight", windows: []v1alpha2.ScheduleWindowConfigT{fridayNight}, time: "2024-03-01T23:00:00Z", want: true},
+		{name: "friday night after midnight", windows: []v1alpha2.ScheduleWindowConfigT{fridayNight}, time: "2024-03-02T05:59:00Z", want: true},
+		{name: "friday night end", windows: []v1alpha2.ScheduleWindowConfigT{fridayNight}, time: "2024-03-02T06:00:00Z", want: false},
+		{name: "thursday night after midnight", windows: []v1alpha2.ScheduleWindowConfigT{fridayNight}, time: "2024-03-01T05:00:00Z", want: false},
+		{name: "friday night from time zone", timeZone: "America/New_York", windows: []v1alpha2.ScheduleWindowConfigT{fridayNight}, time: "2024-03-02T08:00:00Z", want: true},
+		{name: "open end until midnight", windows: []v1alpha2.ScheduleWindowConfigT{{From: "22:00"}}, time: "2024-03-01T23:59:00Z", want: true},
+		{name: "open end after midnight", windows: []v1alpha2.ScheduleWindowConfigT{{From: "22:00"}}, time: "2024-03-02T00:00:00Z", want: false},
+		{name: "until the end of the day", windows: []v1alpha2.ScheduleWindowConfigT{{From: "22:00", To: "24:00"}}, time: "2024-03-01T23:59:00Z", want: true},
+
+		// in Europe/Madrid, 2024-03-31 02:00 is 03:00, and 2024-10-27 03:00 is 02:00 again
+		{name: "spring forward before the change", timeZone: "Europe/Madrid", windows: []v1alpha2.ScheduleWindowConfigT{earlyMorning}, time: "2024-03-31T00:30:00Z", want: true},
+		{name: "spring forward after the change", timeZone: "Europe/Madrid", windows: []v1alpha2.ScheduleWindowConfigT{earlyMorning}, time: "2024-03-31T01:30:00Z", want: true},
+		{name: "spring forward window end", timeZone: "Europe/Madrid", windows: []v1alpha2.ScheduleWindowConfigT{earlyMorning}, time: "2024-03-31T02:00:00Z", want: false},
+		{name: "spring forward skipped hour", timeZone: "Europe/Madrid", windows: []v1alpha2.ScheduleWindowConfigT{skippedHour}, time: "2024-03-31T01:00:00Z", want: false},
+		{name: "fall back first repeated hour", timeZone: "Europe/Madrid", windows: []v1alpha2.ScheduleWindowConfigT{skippedHour}, time: "2024-10-27T00:30:00Z", want: true},
+		{name: "fall back second repeated hour", timeZone: "Europe/Madrid", windows: []v1alpha2.ScheduleWindowConfigT{skippedHour}, time: "2024-10-27T01:30:00Z", want: true},
+		{name: "fall back after repeated hour", timeZone: "Europe/Madrid", windows: []v1alpha2.ScheduleWindowConfigT{skippedHour}, time: "2024-10-27T02:00:00Z", want: false},
+		{name: "night crossing fall back", timeZone: "Europe/Madrid", windows: []v1alpha2.ScheduleWindowConfigT{{Days: []string{"SAT"}, From: "22:00", To: "06:00"}}, time: "2024-10-27T04:59:00Z", want: true},
+		{name: "night crossing fall back end", timeZone: "Europe/Madrid", windows: []v1alpha2.ScheduleWindowConfigT{{Days: []string{"SAT"}, From: "22:00", To: "06:00"}}, time: "2024-10-27T05:00:00Z", want: false},
+
+		// dates without offset are in the time zone of the schedule
+		{name: "after start date", timeZone: "Europe/Madrid", windows: []v1alpha2.ScheduleWindowConfigT{{Start: "2024-03-31"}}, time: "2024-03-30T23:00:00Z", want: true},
+		{name: "before start date", timeZone: "Europe/Madrid", windows: []v1alpha2.ScheduleWindowConfigT{{Start: "2024-03-31"}}, time: "2024-03-30T22:59:00Z", want: false},
+		{name: "end date is exclusive", windows: []v1alpha2.ScheduleWindowConfigT{{Start: "2024-03-01T10:00", End: "2024-03-01T12:00"}}, time: "2024-03-01T12:00:00Z", want: false},
+		{name: "dates with offset", timeZone: "Europe/Madrid", windows: []v1alpha2.ScheduleWindowConfigT{{Start: "2024-03-01T10:00:00Z", End: "2024-03-01T12:00:00Z"}}, time: "2024-03-01T11:00:00Z", want: true},
+		{name: "hours inside dates", windows: []v1alpha2.ScheduleWindowConfigT{{Start: "2024-03-01", End: "2024-03-08", From: "09:00", To: "10:00"}}, time: "2024-03-09T09:30:00Z", want: false},
+
+		{name: "any window", windows: []v1alpha2.ScheduleWindowConfigT{workHours, fridayNight}, time: "2024-03-01T23:00:00Z", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewSchedule(v1alpha2.ScheduleConfigT{TimeZone: tt.timeZone, Windows: tt.windows})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			now, err := time.Parse(time.RFC3339, tt.time)
+			if err != nil {
+				t.Fatalf("invalid time: %v", err)
+			}
+
+			if got := s.Contains(now); got != tt.want {
+				t.Errorf("Contains(%s) = %v, want %v", now.In(s.location), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewScheduleErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  v1alpha2.ScheduleConfigT
+	}{
+		{name: "no windows", cfg: v1alpha2.ScheduleConfigT{}},
+		{name: "empty window", cfg: v1alpha2.ScheduleConfigT{Windows: []v1alpha2.ScheduleWindowConfigT{{}}}},
+		{name: "unknown time zone", cfg: v1alpha2.ScheduleConfigT{TimeZone: "Mars/Olympus", Windows: []v1alpha2.ScheduleWindowConfigT{{From: "09:00"}}}},
+		{name: "unknown day", cfg: v1alpha2.ScheduleConfigT{Windows: []v1alpha2.ScheduleWindowConfigT{{Days: []string{"MONDAY"}}}}},
+		{name: "invalid hour", cfg: v1alpha2.ScheduleConfigT{Windows: []v1alpha2.ScheduleWindowConfigT{{From: "9am"}}}},
+		{name: "hour out of range", cfg: v1alpha2.ScheduleConfigT{Windows: []v1alpha2.ScheduleWindowConfigT{{To: "24:30"}}}},
+		{name: "invalid date", cfg: v1alpha2.ScheduleConfigT{Windows: []v1alpha2.ScheduleWindowConfigT{{Start: "01/03/2024"}}}},
+		{name: "end before start", cfg: v1alpha2.ScheduleConfigT{Windows: []v1alpha2.ScheduleWindowConfigT{{Start: "2024-03-02", End: "2024-03-01"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewSchedule(tt.cfg); err == nil {
+				t.Errorf("NewSchedule() error = nil, want one")
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package servertls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sync/atomic"
+
+	"doorkeeper/api/v1alpha2"
+	"doorkeeper/internal/utils"
+)
+
+const (
+	Version12 = "1.2"
+	Version13 = "1.3"
+)
+
+var (
+	Versions = []string{Version12, Version13}
+
+	versionsIds = map[string]uint16{
+		Version12: tls.VersionTLS12,
+		Version13: tls.VersionTLS13,
+	}
+)
+
+// ReloaderT serves the certificates of the listener, loading them again when their files change,
+// so certificates renewed in place (e.g. by cert-manager) are used without a restart
+type ReloaderT struct {
+	certFile     string
+	keyFile      string
+	clientCaFile string
+	minVersion   uint16
+
+	filesState []utils.FileStateT
+	config     atomic.Pointer[tls.Config]
+}
+
+// NewReloader loads the certificates set in the config, or returns nil when TLS is disabled
+func NewReloader(cfg v1alpha2.TlsConfigT) (r *ReloaderT, err error) {
+	if cfg.CertFile == "" {
+		return nil, nil
+	}
+
+	err = Check(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	r = &ReloaderT{
+		certFile:     cfg.CertFile,
+		keyFile:      cfg.KeyFile,
+		clientCaFile: cfg.ClientCaFile,
+		minVersion:   versionsIds[Version12],
+	}
+
+	if cfg.MinVersion != "" {
+		r.minVersion = versionsIds[cfg.MinVersion]
+	}
+
+	err = r.load()
+	return r, err
+}
+
+// Check validates the config without loading the files
+func Check(cfg v1alpha2.TlsConfigT) error {
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return fmt.Errorf("cert file and key file must be set together")
+	}
+
+	if cfg.ClientCaFile != "" && cfg.CertFile == "" {
+		return fmt.Errorf("client ca file requires cert file and key file")
+	}
+
+	if cfg.MetricsClientCert && cfg.ClientCaFile == "" {
+		return fmt.Errorf("metrics client cert requires client ca file")
+	}
+
+	if cfg.MinVersion != "" && !slices.Contains(Versions, cfg.MinVersion) {
+		return fmt.Errorf("min version must be one of %v", Versions)
+	}
+
+	return nil
+}
+
+func (r *ReloaderT) load() (err error) {
+	paths := []string{r.certFile, r.keyFile}
+	if r.clientCaFile != "" {
+		paths = append(paths, r.clientCaFile)
+	}
+
+	// state is taken before reading the files, so changes made meanwhile are loaded in the next reload
+	state, err := utils.GetFilesState(paths)
+	if err != nil {
+		return fmt.Errorf("unable to stat tls files: %s", err.Error())
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load tls certificate: %s", err.Error())
+	}
+
+	config := &tls.Config{
+		MinVersion:   r.minVersion,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+
+	// certificates are verified when given, and required by the handlers but the probes
+	if r.clientCaFile != "" {
+		caBundle, err := os.ReadFile(r.clientCaFile)
+		if err != nil {
+			return fmt.Errorf("unable to read tls client ca file: %s", err.Error())
+		}
+
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(caBundle) {
+			return fmt.Errorf("no certificates found in tls client ca file")
+		}
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	r.config.Store(config)
+	r.filesState = state
+
+	return err
+}
+
+// Reload loads the files again when they change. On errors, the current ones are kept
+func (r *ReloaderT) Reload() (reloaded bool, err error) {
+	changed, err := utils.FilesChanged(r.filesState)
+	if err != nil || !changed {
+		return false, err
+	}
+
+	err = r.load()
+	return err == nil, err
+}
+
+// Config returns the config for the server, serving the last certificates loaded
+func (r *ReloaderT) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion: r.minVersion,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.config.Load(), nil
+		},
+	}
+}
+
+// RequireClientCert wraps the handler to reject the requests without a verified client certificate,
+// when a client CA is set
+func (r *ReloaderT) RequireClientCert(next http.HandlerFunc) http.HandlerFunc {
+	if r.clientCaFile == "" {
+		return next
+	}
+
+	return RequireVerifiedCert(next)
+}
+
+// RequireVerifiedCert wraps the handler to reject the requests without a verified client certificate.
+// Certificates are verified in the TLS handshake, so only their presence is checked
+func RequireVerifiedCert(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+			http.Error(w, "client certificate required", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, req)
+	}
+}
@@ -0,0 +1,304 @@
+package servertls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"doorkeeper/api/v1alpha2"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     v1alpha2.TlsConfigT
+		wantErr bool
+	}{
+		{name: "disabled", cfg: v1alpha2.TlsConfigT{}},
+		{name: "server certificate", cfg: v1alpha2.TlsConfigT{CertFile: "tls.crt", KeyFile: "tls.key"}},
+		{name: "key without certificate", cfg: v1alpha2.TlsConfigT{KeyFile: "tls.key"}, wantErr: true},
+		{name: "mtls", cfg: v1alpha2.TlsConfigT{CertFile: "tls.crt", KeyFile: "tls.key", ClientCaFile: "ca.crt", MetricsClientCert: true}},
+		{name: "client ca without certificate", cfg: v1alpha2.TlsConfigT{ClientCaFile: "ca.crt"}, wantErr: true},
+		{name: "metrics client cert without client ca", cfg: v1alpha2.TlsConfigT{CertFile: "tls.crt", KeyFile: "tls.key", MetricsClientCert: true}, wantErr: true},
+		{name: "min version", cfg: v1alpha2.TlsConfigT{CertFile: "tls.crt", KeyFile: "tls.key", MinVersion: "1.3"}},
+		{name: "unsupported min version", cfg: v1alpha2.TlsConfigT{CertFile: "tls.crt", KeyFile: "tls.key", MinVersion: "1.1"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Check(tt.cfg); (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// testCertT is a self-signed certificate, valid to serve and to authenticate clients
+type testCertT struct {
+	cert    *x509.Certificate
+	keyPair tls.Certificate
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCert(t *testing.T, serial int64) testCertT {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unable to create certificate: %v", err)
+	}
+
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("unable to marshal key: %v", err)
+	}
+
+	c := testCertT{
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}),
+	}
+
+	c.cert, _ = x509.ParseCertificate(der)
+	c.keyPair, err = tls.X509KeyPair(c.certPEM, c.keyPEM)
+	if err != nil {
+		t.Fatalf("invalid key pair: %v", err)
+	}
+
+	return c
+}
+
+// write replaces the files with the certificate, moving their modification time forward
+// so the change is detected even within the resolution of the file system
+func (c testCertT) write(t *testing.T, certFile, keyFile string, offset time.Duration) {
+	t.Helper()
+
+	at := time.Now().Add(offset)
+	for path, content := range map[string][]byte{certFile: c.certPEM, keyFile: c.keyPEM} {
+		if err := os.WriteFile(path, content, 0o600); err != nil {
+			t.Fatalf("unable to write %s: %v", path, err)
+		}
+		if err := os.Chtimes(path, at, at); err != nil {
+			t.Fatalf("unable to touch %s: %v", path, err)
+		}
+	}
+}
+
+// startServer serves the handler with the config of the reloader, as the server does
+func startServer(t *testing.T, r *ReloaderT, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewUnstartedServer(r.RequireClientCert(handler))
+	server.TLS = r.Config()
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// servedSerial returns the serial of the certificate served in a handshake
+func servedSerial(t *testing.T, address string) int64 {
+	t.Helper()
+
+	conn, err := tls.Dial("tcp", address, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	defer conn.Close()
+
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	newTestCert(t, 1).write(t, certFile, keyFile, 0)
+
+	r, err := NewReloader(v1alpha2.TlsConfigT{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	server := startServer(t, r, func(w http.ResponseWriter, _ *http.Request) {})
+	address := server.Listener.Addr().String()
+
+	if serial := servedSerial(t, address); serial != 1 {
+		t.Fatalf("served certificate %d, want 1", serial)
+	}
+
+	if reloaded, err := r.Reload(); reloaded || err != nil {
+		t.Errorf("Reload() = (%v, %v), want nothing reloaded without changes", reloaded, err)
+	}
+
+	// rotated certificates are served in the next handshakes
+	newTestCert(t, 2).write(t, certFile, keyFile, time.Minute)
+	if reloaded, err := r.Reload(); !reloaded || err != nil {
+		t.Fatalf("Reload() = (%v, %v), want the rotated certificate loaded", reloaded, err)
+	}
+
+	if serial := servedSerial(t, address); serial != 2 {
+		t.Errorf("served certificate %d after the rotation, want 2", serial)
+	}
+
+	// invalid files keep the current certificate, and are retried while they do not change
+	other := newTestCert(t, 3)
+	other.keyPEM = newTestCert(t, 4).keyPEM
+	other.write(t, certFile, keyFile, 2*time.Minute)
+
+	for range 2 {
+		if reloaded, err := r.Reload(); reloaded || err == nil {
+			t.Errorf("Reload() = (%v, %v), want the mismatched key reported", reloaded, err)
+		}
+	}
+
+	if serial := servedSerial(t, address); serial != 2 {
+		t.Errorf("served certificate %d with invalid files, want the current one", serial)
+	}
+
+	// missing files keep the current certificate too
+	os.Remove(keyFile)
+	if reloaded, err := r.Reload(); reloaded || err == nil {
+		t.Errorf("Reload() = (%v, %v), want the missing key reported", reloaded, err)
+	}
+
+	if serial := servedSerial(t, address); serial != 2 {
+		t.Errorf("served certificate %d with missing files, want the current one", serial)
+	}
+}
+
+func TestRequireClientCert(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	caFile := filepath.Join(dir, "ca.crt")
+
+	serverCert := newTestCert(t, 1)
+	serverCert.write(t, certFile, keyFile, 0)
+
+	clientCert := newTestCert(t, 10)
+	if err := os.WriteFile(caFile, clientCert.certPEM, 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r, err := NewReloader(v1alpha2.TlsConfigT{CertFile: certFile, KeyFile: keyFile, ClientCaFile: caFile})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	server := startServer(t, r, func(w http.ResponseWriter, _ *http.Request) {
+		io.WriteString(w, "ok")
+	})
+
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCert.cert)
+
+	tests := []struct {
+		name          string
+		certificates  []tls.Certificate
+		wantStatus    int
+		wantHandshake bool
+	}{
+		{
+			name:          "valid client certificate",
+			certificates:  []tls.Certificate{clientCert.keyPair},
+			wantStatus:    http.StatusOK,
+			wantHandshake: true,
+		},
+		{
+			name:          "without client certificate",
+			wantStatus:    http.StatusUnauthorized,
+			wantHandshake: true,
+		},
+		{
+			name:         "client certificate of another ca",
+			certificates: []tls.Certificate{newTestCert(t, 11).keyPair},
+		},
+		{
+			name:         "server certificate as client certificate",
+			certificates: []tls.Certificate{serverCert.keyPair},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+				RootCAs:      roots,
+				Certificates: tt.certificates,
+			}}}
+			defer client.CloseIdleConnections()
+
+			resp, err := client.Get(server.URL)
+			if !tt.wantHandshake {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatalf("request with status %d, want the handshake rejected", resp.StatusCode)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestRequireClientCertWithoutCa(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	newTestCert(t, 1).write(t, certFile, keyFile, 0)
+
+	r, err := NewReloader(v1alpha2.TlsConfigT{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	server := startServer(t, r, func(w http.ResponseWriter, _ *http.Request) {})
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d without client ca, want client certificates not required", resp.StatusCode)
+	}
+}
@@ -0,0 +1,275 @@
+package signedcookie
+
+import (
+	"crypto"
+	cryptohmac "crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"net"
+	"strings"
+	"time"
+
+	"doorkeeper/api/v1alpha2"
+	"doorkeeper/internal/clientip"
+	"doorkeeper/internal/hmac"
+	"doorkeeper/internal/reasons"
+	"doorkeeper/internal/utils"
+)
+
+const (
+	AlgorithmRSASHA1    = "RSA_SHA1"
+	AlgorithmRSASHA256  = "RSA_SHA256"
+	AlgorithmHMACSHA1   = "HMAC_SHA1"
+	AlgorithmHMACSHA256 = "HMAC_SHA256"
+	AlgorithmHMACSHA512 = "HMAC_SHA512"
+
+	// Default names of the cookies, as sent by CloudFront
+	DefaultPolicyCookie    = "CloudFront-Policy"
+	DefaultSignatureCookie = "CloudFront-Signature"
+	DefaultKeyPairIdCookie = "CloudFront-Key-Pair-Id"
+	DefaultExpiresCookie   = "CloudFront-Expires"
+)
+
+var (
+	Algorithms = []string{
+		AlgorithmRSASHA1,
+		AlgorithmRSASHA256,
+		AlgorithmHMACSHA1,
+		AlgorithmHMACSHA256,
+		AlgorithmHMACSHA512,
+	}
+
+	algorithmHashes = map[string]crypto.Hash{
+		AlgorithmRSASHA1:    crypto.SHA1,
+		AlgorithmRSASHA256:  crypto.SHA256,
+		AlgorithmHMACSHA1:   crypto.SHA1,
+		AlgorithmHMACSHA256: crypto.SHA256,
+		AlgorithmHMACSHA512: crypto.SHA512,
+	}
+
+	// hmacAlgorithms are the names of the algorithms in the hmac package, to decode the keys
+	hmacAlgorithms = map[string]string{
+		AlgorithmHMACSHA1:   "sha1",
+		AlgorithmHMACSHA256: "sha256",
+		AlgorithmHMACSHA512: "sha512",
+	}
+
+	// WeakAlgorithms are rejected when the security policy disallows weak algorithms
+	WeakAlgorithms = []string{AlgorithmRSASHA1, AlgorithmHMACSHA1}
+
+	hmacHashes = map[crypto.Hash]func() hash.Hash{
+		crypto.SHA1:   sha1.New,
+		crypto.SHA256: sha256.New,
+		crypto.SHA512: sha512.New,
+	}
+
+	// cloudFrontEncoding replaces the characters of base64 that are invalid in cookies
+	cloudFrontEncoding = strings.NewReplacer("-", "+", "_", "=", "~", "/")
+)
+
+// PolicyT is a CloudFront-style policy, stating the resources that can be accessed and when
+type PolicyT struct {
+	Statement []StatementT `json:"Statement"`
+}
+
+type StatementT struct {
+	Resource  string     `json:"Resource,omitempty"`
+	Condition ConditionT `json:"Condition"`
+}
+
+type ConditionT struct {
+	DateLessThan    *EpochTimeT `json:"DateLessThan,omitempty"`
+	DateGreaterThan *EpochTimeT `json:"DateGreaterThan,omitempty"`
+	IpAddress       *SourceIpT  `json:"IpAddress,omitempty"`
+}
+
+type EpochTimeT struct {
+	EpochTime int64 `json:"AWS:EpochTime"`
+}
+
+type SourceIpT struct {
+	SourceIp string `json:"AWS:SourceIp"`
+}
+
+// VerifierT checks the signatures of policies with the keys of the config, identified by their ids
+type VerifierT struct {
+	algorithm string
+	hash      crypto.Hash
+
+	hmacKeys map[string][]byte
+	rsaKeys  map[string]*rsa.PublicKey
+}
+
+func NewVerifier(cfg v1alpha2.SignedCookieConfigT) (v *VerifierT, err error) {
+	v = &VerifierT{
+		algorithm: cfg.Algorithm,
+		hmacKeys:  map[string][]byte{},
+		rsaKeys:   map[string]*rsa.PublicKey{},
+	}
+
+	var ok bool
+	v.hash, ok = algorithmHashes[v.algorithm]
+	if !ok {
+		return v, fmt.Errorf("invalid algorithm '%s'", v.algorithm)
+	}
+
+	if len(cfg.Keys) == 0 {
+		return v, fmt.Errorf("no keys defined")
+	}
+
+	for _, keyv := range cfg.Keys {
+		if strings.HasPrefix(v.algorithm, "RSA_") {
+			v.rsaKeys[keyv.Id], err = parseRSAPublicKey(keyv.Key)
+		} else {
+			// keys are checked to be long enough, so empty secrets (e.g. from empty files) are rejected
+			v.hmacKeys[keyv.Id], err = hmac.DecodeKey(keyv.Key, cfg.KeyEncoding, hmacAlgorithms[v.algorithm])
+		}
+
+		if err != nil {
+			return v, fmt.Errorf("invalid key '%s': %s", keyv.Id, err.Error())
+		}
+	}
+
+	return v, err
+}
+
+// parseRSAPublicKey parses a PEM public key, in PKIX or PKCS#1 format
+func parseRSAPublicKey(key string) (publicKey *rsa.PublicKey, err error) {
+	block, _ := pem.Decode([]byte(strings.TrimSpace(key)))
+	if block == nil {
+		return nil, fmt.Errorf("key is not PEM encoded")
+	}
+
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+
+	parsedKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, ok := parsedKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("key is not an RSA public key")
+	}
+
+	return publicKey, nil
+}
+
+// Verify checks the signature of the policy with the key identified by keyId.
+// When there is only one key, the id can be omitted
+func (v *VerifierT) Verify(policy, signature []byte, keyId string) (err error) {
+	keysCount := len(v.hmacKeys) + len(v.rsaKeys)
+	if keyId == "" && keysCount == 1 {
+		for idv := range v.hmacKeys {
+			keyId = idv
+		}
+		for idv := range v.rsaKeys {
+			keyId = idv
+		}
+	}
+
+	if rsaKey, ok := v.rsaKeys[keyId]; ok {
+		hasher := v.hash.New()
+		hasher.Write(policy)
+
+		err = rsa.VerifyPKCS1v15(rsaKey, v.hash, hasher.Sum(nil), signature)
+		if err != nil {
+			return reasons.Errorf(reasons.BadSignature, "invalid policy signature")
+		}
+		return nil
+	}
+
+	if hmacKey, ok := v.hmacKeys[keyId]; ok {
+		mac := cryptohmac.New(hmacHashes[v.hash], hmacKey)
+		mac.Write(policy)
+
+		if !cryptohmac.Equal(mac.Sum(nil), signature) {
+			return reasons.Errorf(reasons.BadSignature, "invalid policy signature")
+		}
+		return nil
+	}
+
+	return reasons.Errorf(reasons.UnknownKey, "unknown key pair id '%s'", keyId)
+}
+
+// DecodeBase64 decodes the values of the cookies, which use a base64
+// variant replacing '+', '=' and '/' with '-', '_' and '~'
+func DecodeBase64(value string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(cloudFrontEncoding.Replace(value))
+}
+
+// ParsePolicy decodes a custom policy
+func ParsePolicy(content []byte) (policy PolicyT, err error) {
+	err = json.Unmarshal(content, &policy)
+	if err != nil {
+		return policy, reasons.Errorf(reasons.InvalidParam, "invalid policy: %s", err.Error())
+	}
+
+	if len(policy.Statement) == 0 {
+		return policy, reasons.Errorf(reasons.InvalidParam, "invalid policy: no statements")
+	}
+
+	return policy, err
+}
+
+// CannedPolicy returns the policy signed when only the expiration is sent,
+// which allows the access to an exact resource
+func CannedPolicy(resource string, expires int64) []byte {
+	return []byte(fmt.Sprintf(`{"Statement":[{"Resource":"%s","Condition":{"DateLessThan":{"AWS:EpochTime":%d}}}]}`,
+		resource, expires))
+}
+
+// Allows returns whether any statement of the policy allows accessing the resource
+// from the IP at the time given. Statements must always set their expiration
+func (p *PolicyT) Allows(resource string, ip net.IP, now time.Time) (err error) {
+	err = reasons.Errorf(reasons.InvalidParam, "no statements in policy")
+	for _, statementv := range p.Statement {
+		err = statementv.allows(resource, ip, now)
+		if err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+func (s *StatementT) allows(resource string, ip net.IP, now time.Time) error {
+	if s.Resource != "" && !utils.MatchWildcard(s.Resource, resource) {
+		return reasons.Errorf(reasons.PathNotAllowed, "resource '%s' not allowed by policy", resource)
+	}
+
+	if s.Condition.DateLessThan == nil {
+		return reasons.Errorf(reasons.InvalidParam, "policy without expiration")
+	}
+
+	if now.Unix() >= s.Condition.DateLessThan.EpochTime {
+		return reasons.Errorf(reasons.Expired, "policy has expired")
+	}
+
+	if s.Condition.DateGreaterThan != nil && now.Unix() < s.Condition.DateGreaterThan.EpochTime {
+		return reasons.Errorf(reasons.NotYetValid, "policy is not valid yet")
+	}
+
+	if s.Condition.IpAddress != nil {
+		networks, err := clientip.ParseNetworks([]string{s.Condition.IpAddress.SourceIp})
+		if err != nil {
+			return reasons.Errorf(reasons.InvalidParam, "invalid source ip in policy: %s", err.Error())
+		}
+
+		if ip == nil || !clientip.IsTrusted(ip, networks) {
+			return reasons.Errorf(reasons.IpNotAllowed, "client ip not allowed by policy")
+		}
+	}
+
+	return nil
+}
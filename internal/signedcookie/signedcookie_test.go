@@ -0,0 +1,247 @@
+package signedcookie
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"doorkeeper/api/v1alpha2"
+	"doorkeeper/internal/reasons"
+)
+
+func TestNewVerifierKeys(t *testing.T) {
+	tests := []struct {
+		name        string
+		algorithm   string
+		keyEncoding string
+		key         string
+		wantErr     bool
+	}{
+		{name: "hex key", algorithm: AlgorithmHMACSHA256, key: "00112233445566778899aabbccddeeff"},
+		{name: "base64 key", algorithm: AlgorithmHMACSHA256, keyEncoding: "base64", key: "ABEiM0RVZneImaq7zN3u/w=="},
+		{name: "raw key", algorithm: AlgorithmHMACSHA256, keyEncoding: "raw", key: "0123456789abcdef"},
+		{name: "empty key", algorithm: AlgorithmHMACSHA256, key: "", wantErr: true},
+		{name: "blank key", algorithm: AlgorithmHMACSHA256, key: " \n", wantErr: true},
+		{name: "empty raw key", algorithm: AlgorithmHMACSHA1, keyEncoding: "raw", key: "", wantErr: true},
+		{name: "short key", algorithm: AlgorithmHMACSHA256, key: "0011223344556677", wantErr: true},
+		{name: "short key for sha512", algorithm: AlgorithmHMACSHA512, key: "00112233445566778899aabbccddeeff", wantErr: true},
+		{name: "invalid hex key", algorithm: AlgorithmHMACSHA256, key: "not-hex", wantErr: true},
+		{name: "not a PEM key", algorithm: AlgorithmRSASHA256, key: "00112233445566778899aabbccddeeff", wantErr: true},
+		{name: "unknown algorithm", algorithm: "HMAC_MD5", key: "00112233445566778899aabbccddeeff", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewVerifier(v1alpha2.SignedCookieConfigT{
+				Algorithm:   tt.algorithm,
+				KeyEncoding: tt.keyEncoding,
+				Keys:        []v1alpha2.SignedCookieKeyConfigT{{Id: "k1", Key: tt.key}},
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewVerifier() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyHmac(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	verifier, err := NewVerifier(v1alpha2.SignedCookieConfigT{
+		Algorithm:   AlgorithmHMACSHA256,
+		KeyEncoding: "raw",
+		Keys:        []v1alpha2.SignedCookieKeyConfigT{{Id: "k1", Key: string(key)}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	policy := CannedPolicy("https://cdn.example.com/videos/1.ts", 2000000000)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(policy)
+	signature := mac.Sum(nil)
+
+	tests := []struct {
+		name       string
+		policy     []byte
+		signature  []byte
+		keyId      string
+		wantReason string
+	}{
+		{name: "valid signature", policy: policy, signature: signature, keyId: "k1"},
+		{name: "key id omitted with one key", policy: policy, signature: signature},
+		{name: "tampered policy", policy: append([]byte{' '}, policy...), signature: signature, keyId: "k1", wantReason: reasons.BadSignature},
+		{name: "truncated signature", policy: policy, signature: signature[:16], keyId: "k1", wantReason: reasons.BadSignature},
+		{name: "unknown key", policy: policy, signature: signature, keyId: "k2", wantReason: reasons.UnknownKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifier.Verify(tt.policy, tt.signature, tt.keyId)
+			checkReason(t, err, tt.wantReason)
+		})
+	}
+}
+
+func TestVerifyRSA(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	publicKey, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	verifier, err := NewVerifier(v1alpha2.SignedCookieConfigT{
+		Algorithm: AlgorithmRSASHA256,
+		Keys: []v1alpha2.SignedCookieKeyConfigT{
+			{Id: "k1", Key: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey}))},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	policy := CannedPolicy("https://cdn.example.com/videos/1.ts", 2000000000)
+	digest := sha256.Sum256(policy)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	checkReason(t, verifier.Verify(policy, signature, "k1"), "")
+	checkReason(t, verifier.Verify([]byte("{}"), signature, "k1"), reasons.BadSignature)
+}
+
+func TestPolicyAllows(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	epoch := func(seconds int64) *EpochTimeT { return &EpochTimeT{EpochTime: seconds} }
+
+	tests := []struct {
+		name       string
+		statement  StatementT
+		resource   string
+		ip         string
+		wantReason string
+	}{
+		{
+			name:      "resource with wildcard",
+			statement: StatementT{Resource: "https://cdn.example.com/videos/*", Condition: ConditionT{DateLessThan: epoch(1700000100)}},
+			resource:  "https://cdn.example.com/videos/1/2.ts",
+		},
+		{
+			name:       "resource not matching",
+			statement:  StatementT{Resource: "https://cdn.example.com/videos/*", Condition: ConditionT{DateLessThan: epoch(1700000100)}},
+			resource:   "https://cdn.example.com/audio/1.mp3",
+			wantReason: reasons.PathNotAllowed,
+		},
+		{
+			name:       "expired",
+			statement:  StatementT{Condition: ConditionT{DateLessThan: epoch(1700000000)}},
+			resource:   "https://cdn.example.com/videos/1.ts",
+			wantReason: reasons.Expired,
+		},
+		{
+			name:       "without expiration",
+			statement:  StatementT{Resource: "*"},
+			resource:   "https://cdn.example.com/videos/1.ts",
+			wantReason: reasons.InvalidParam,
+		},
+		{
+			name:       "not valid yet",
+			statement:  StatementT{Condition: ConditionT{DateLessThan: epoch(1700000100), DateGreaterThan: epoch(1700000050)}},
+			resource:   "https://cdn.example.com/videos/1.ts",
+			wantReason: reasons.NotYetValid,
+		},
+		{
+			name:      "ip in network",
+			statement: StatementT{Condition: ConditionT{DateLessThan: epoch(1700000100), IpAddress: &SourceIpT{SourceIp: "192.0.2.0/24"}}},
+			resource:  "https://cdn.example.com/videos/1.ts",
+			ip:        "192.0.2.10",
+		},
+		{
+			name:       "ip out of network",
+			statement:  StatementT{Condition: ConditionT{DateLessThan: epoch(1700000100), IpAddress: &SourceIpT{SourceIp: "192.0.2.0/24"}}},
+			resource:   "https://cdn.example.com/videos/1.ts",
+			ip:         "198.51.100.10",
+			wantReason: reasons.IpNotAllowed,
+		},
+		{
+			name:       "ip unknown",
+			statement:  StatementT{Condition: ConditionT{DateLessThan: epoch(1700000100), IpAddress: &SourceIpT{SourceIp: "192.0.2.0/24"}}},
+			resource:   "https://cdn.example.com/videos/1.ts",
+			wantReason: reasons.IpNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := PolicyT{Statement: []StatementT{tt.statement}}
+			checkReason(t, policy.Allows(tt.resource, net.ParseIP(tt.ip), now), tt.wantReason)
+		})
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := ParsePolicy(CannedPolicy("https://cdn.example.com/videos/1.ts", 1700000100))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(policy.Statement) != 1 || policy.Statement[0].Condition.DateLessThan.EpochTime != 1700000100 {
+		t.Errorf("unexpected canned policy: %+v", policy)
+	}
+
+	_, err = ParsePolicy([]byte(`{"Statement":[]}`))
+	checkReason(t, err, reasons.InvalidParam)
+
+	_, err = ParsePolicy([]byte(`not json`))
+	checkReason(t, err, reasons.InvalidParam)
+}
+
+func TestDecodeBase64(t *testing.T) {
+	// standard base64 of these bytes has '+', '/' and '=' characters
+	want := []byte{0xfb, 0xff, 0xbf, 0x01}
+	encoded := strings.NewReplacer("+", "-", "=", "_", "/", "~").Replace(base64.StdEncoding.EncodeToString(want))
+
+	decoded, err := DecodeBase64(encoded)
+	if err != nil {
+		t.Fatalf("unexpected error decoding '%s': %v", encoded, err)
+	}
+
+	if !bytes.Equal(decoded, want) {
+		t.Errorf("DecodeBase64('%s') = %x, want %x", encoded, decoded, want)
+	}
+}
+
+// checkReason fails when the error is not the one expected, being no error an empty reason
+func checkReason(t *testing.T, err error, wantReason string) {
+	t.Helper()
+
+	if wantReason == "" {
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		return
+	}
+
+	if err == nil {
+		t.Errorf("expected error with reason '%s', got none", wantReason)
+		return
+	}
+
+	if reason := reasons.Get(err); reason != wantReason {
+		t.Errorf("reason = '%s', want '%s' (error: %v)", reason, wantReason, err)
+	}
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"os"
+	"slices"
+	"time"
+)
+
+// FileStateT identifies a version of a file, to detect when it changes
+type FileStateT struct {
+	Path    string
+	ModTime time.Time
+	Size    int64
+}
+
+// GetFilesState returns the current state of the files. Symlinks are followed,
+// so files replaced by Kubernetes when updating mounted volumes are detected too
+func GetFilesState(paths []string) (states []FileStateT, err error) {
+	for _, pathv := range paths {
+		var info os.FileInfo
+		info, err = os.Stat(pathv)
+		if err != nil {
+			return states, err
+		}
+
+		states = append(states, FileStateT{
+			Path:    pathv,
+			ModTime: info.ModTime(),
+			Size:    info.Size(),
+		})
+	}
+
+	return states, err
+}
+
+// FilesChanged returns whether the current state of the files differs from the given one
+func FilesChanged(states []FileStateT) (changed bool, err error) {
+	paths := make([]string, 0, len(states))
+	for _, statev := range states {
+		paths = append(paths, statev.Path)
+	}
+
+	current, err := GetFilesState(paths)
+	if err != nil {
+		return false, err
+	}
+
+	equal := slices.EqualFunc(states, current, func(a, b FileStateT) bool {
+		return a.Path == b.Path && a.ModTime.Equal(b.ModTime) && a.Size == b.Size
+	})
+
+	return !equal, err
+}
@@ -1,10 +1,7 @@
 package utils
 
 import (
-	"crypto/md5"
 	"doorkeeper/internal/logger"
-	"encoding/hex"
-	"fmt"
 	"net/http"
 )
 
@@ -17,10 +14,55 @@ const (
 	LogFieldKeyAuthorization = "authorization"
 	LogFieldKeyRequirement   = "requirement"
 	LogFieldKeyError         = "error"
+	LogFieldKeyReason        = "reason"
+	LogFieldKeyNamespace     = "namespace"
+	LogFieldKeyPolicy        = "policy"
+
+	// Fields of the decision logs
+	LogFieldKeyDecision   = "decision"
+	LogFieldKeyStatusCode = "statusCode"
+	LogFieldKeyMethod     = "method"
+	LogFieldKeyHost       = "host"
+	LogFieldKeyPath       = "path"
+	LogFieldKeyQuery      = "query"
+	LogFieldKeyHeaders    = "headers"
+	LogFieldKeyClientIp   = "clientIp"
+	LogFieldKeyUserAgent  = "userAgent"
+	LogFieldKeyDurationMs = "durationMs"
 
 	LogFieldValueService = "doorkeeper"
 )
 
+var (
+	DecisionLogFields = []string{
+		LogFieldKeyRequestID,
+		LogFieldKeyDecision,
+		LogFieldKeyReason,
+		LogFieldKeyStatusCode,
+		LogFieldKeyMethod,
+		LogFieldKeyHost,
+		LogFieldKeyPath,
+		LogFieldKeyQuery,
+		LogFieldKeyHeaders,
+		LogFieldKeyClientIp,
+		LogFieldKeyUserAgent,
+		LogFieldKeyDurationMs,
+		LogFieldKeyRequestMod,
+	}
+
+	DefaultDecisionLogFields = []string{
+		LogFieldKeyRequestID,
+		LogFieldKeyDecision,
+		LogFieldKeyReason,
+		LogFieldKeyStatusCode,
+		LogFieldKeyMethod,
+		LogFieldKeyHost,
+		LogFieldKeyPath,
+		LogFieldKeyClientIp,
+		LogFieldKeyDurationMs,
+	}
+)
+
 type RequestLogT struct {
 	Method      string      `json:"method"`
 	Host        string      `json:"host"`
@@ -29,24 +71,6 @@ type RequestLogT struct {
 	Headers     http.Header `json:"headers"`
 }
 
-func RequestID(r *http.Request) string {
-	headers := "{"
-	for hk, hvs := range r.Header {
-		headers += "(" + hk + fmt.Sprintf("%v", hvs) + ")"
-
-	}
-	headers += "}"
-
-	reqStr := fmt.Sprintf("{method: '%s', host: '%s', path: '%s/%s', headers: '%s'}", r.Method, r.Host, r.URL.Path, r.URL.RawQuery, headers)
-	md5Hash := md5.New()
-	_, err := md5Hash.Write([]byte(reqStr))
-	if err != nil {
-		return "UnableGetRequestID"
-	}
-
-	return hex.EncodeToString(md5Hash.Sum(nil))
-}
-
 func RequestLogStruct(r *http.Request) (req RequestLogT) {
 	req.Method = r.Method
 	req.Host = r.Host
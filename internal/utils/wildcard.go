@@ -0,0 +1,31 @@
+package utils
+
+// MatchWildcard returns whether the value matches the pattern, where '*' matches
+// any sequence of characters, including the empty one, and '?' any single character
+func MatchWildcard(pattern, value string) bool {
+	// classic greedy algorithm with backtracking to the last star
+	p, v := 0, 0
+	starP, starV := -1, 0
+	for v < len(value) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == value[v]):
+			p++
+			v++
+		case p < len(pattern) && pattern[p] == '*':
+			starP, starV = p, v
+			p++
+		case starP != -1:
+			p = starP + 1
+			starV++
+			v = starV
+		default:
+			return false
+		}
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+
+	return p == len(pattern)
+}